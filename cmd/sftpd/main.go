@@ -69,10 +69,39 @@ func main() {
 		cfg.Server.Port = *port
 	}
 
+	// Fail early on algorithm names the SSH library does not implement
+	if err := services.ValidateAlgorithms(models.ConnectionOptions{
+		Ciphers:           cfg.SSH.Ciphers,
+		KeyExchanges:      cfg.SSH.KeyExchanges,
+		MACs:              cfg.SSH.MACs,
+		HostKeyAlgorithms: cfg.SSH.HostKeyAlgorithms,
+	}); err != nil {
+		log.Fatalf("Invalid SSH configuration: %v", err)
+	}
+
 	// Create services
-	sessionService := services.NewSessionService(cfg)
+	hostKeyService := services.NewHostKeyService(cfg)
+	vaultService := services.NewVaultService(cfg)
+	sshConfigService := services.NewSSHConfigService(cfg)
+	sessionStore, err := services.NewSessionStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open session store: %v", err)
+	}
+	sessionService, err := services.NewSessionService(cfg, hostKeyService, sessionStore, vaultService, sshConfigService)
+	if err != nil {
+		log.Fatalf("Failed to start session service: %v", err)
+	}
 	fileService := services.NewFileService(sessionService)
 	loginHistoryService := services.NewLoginHistoryService(cfg)
+	diagnosticsService := services.NewDiagnosticsService(cfg, hostKeyService)
+	userService, err := services.NewUserService(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load users: %v", err)
+	}
+	oidcService := services.NewOIDCService(cfg)
+	ldapService := services.NewLDAPService(cfg)
+	loginLimiter := services.NewLoginLimiter(cfg)
+	flashService := services.NewFlashService()
 
 	// Load templates
 	templates, err := loadTemplates()
@@ -81,10 +110,10 @@ func main() {
 	}
 
 	// Create handlers
-	handler := handlers.New(sessionService, fileService, loginHistoryService, cfg, templates)
+	handler := handlers.New(sessionService, fileService, loginHistoryService, vaultService, sshConfigService, diagnosticsService, userService, oidcService, ldapService, loginLimiter, flashService, cfg, templates)
 
 	// Create middleware
-	mw := middleware.New(sessionService, cfg)
+	mw := middleware.New(sessionService, userService, flashService, cfg)
 
 	// Setup routes
 	mux := setupRoutes(handler, mw, cfg)
@@ -92,7 +121,7 @@ func main() {
 	// Create HTTP server
 	server := &http.Server{
 		Addr:         cfg.GetAddr(),
-		Handler:      mux,
+		Handler:      mw.RealIP(mux),
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
 		IdleTimeout:  cfg.Server.IdleTimeout,
@@ -102,6 +131,9 @@ func main() {
 	go func() {
 		log.Printf("🚀 SFTP Web Client v%s starting on %s", version, cfg.GetAddr())
 		log.Printf("📁 Open http://%s in your browser", cfg.GetAddr())
+		if !cfg.Policy.Targets.Enabled() {
+			log.Printf("⚠️  No target policy configured, users may connect to any host and port")
+		}
 
 		var err error
 		if cfg.Server.TLSEnabled {
@@ -132,6 +164,11 @@ func main() {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	// Keep sessions for the next start when they are stored on disk
+	if err := sessionService.Close(); err != nil {
+		log.Printf("Failed to save sessions: %v", err)
+	}
+
 	log.Println("✅ Server stopped")
 }
 
@@ -147,41 +184,120 @@ func setupRoutes(h *handlers.Handler, mw *middleware.Middleware, cfg *config.Con
 
 	// Public routes (no authentication required)
 	publicMux := http.NewServeMux()
-	publicMux.HandleFunc("/", h.Home)
-	publicMux.HandleFunc("/connect", h.Login)
+	publicMux.HandleFunc("/login", h.SignIn)
+	publicMux.HandleFunc("/auth/oidc/login", h.OIDCLogin)
+	publicMux.HandleFunc("/auth/oidc/callback", h.OIDCCallback)
 	publicMux.HandleFunc("/health", healthCheck)
 	publicMux.HandleFunc("/version", versionHandler)
 
-	// Protected routes (authentication required)
+	// User routes (signed-in application user required)
+	userMux := http.NewServeMux()
+	userMux.HandleFunc("/", h.Home)
+	userMux.HandleFunc("/logout", h.SignOut)
+	userMux.HandleFunc("/connect", h.Login)
+	userMux.HandleFunc("/connect/hostkey", h.ConfirmHostKey)
+	userMux.HandleFunc("/connect/challenge", h.AnswerChallenge)
+	userMux.HandleFunc("/import/ssh-config", h.ImportSSHConfig)
+	userMux.Handle("/profiles", mw.Require(config.PermProfiles)(http.HandlerFunc(h.Profiles)))
+	userMux.Handle("/profiles/unlock", mw.Require(config.PermProfiles)(http.HandlerFunc(h.UnlockProfiles)))
+	userMux.Handle("/profiles/lock", mw.Require(config.PermProfiles)(http.HandlerFunc(h.LockProfiles)))
+	userMux.Handle("/profiles/save", mw.Require(config.PermProfiles)(http.HandlerFunc(h.SaveProfile)))
+	userMux.Handle("/profiles/delete", mw.Require(config.PermProfiles)(http.HandlerFunc(h.DeleteProfile)))
+	userMux.Handle("/profiles/connect", mw.Require(config.PermProfiles)(http.HandlerFunc(h.ConnectProfile)))
+	userMux.Handle("/diagnostics", mw.Require(config.PermDiagnostics)(http.HandlerFunc(h.Diagnostics)))
+	userMux.Handle("/api/diagnostics", mw.Require(config.PermDiagnostics)(http.HandlerFunc(h.RunDiagnostics)))
+	userMux.HandleFunc("/api/session/extend", h.ExtendSession)
+
+	// Status routes, which pages poll on a timer (signed-in user required,
+	// without counting as activity)
+	statusMux := http.NewServeMux()
+	statusMux.HandleFunc("/api/notices", h.Notices)
+	statusMux.HandleFunc("/api/session", h.SessionStatus)
+
+	// Admin routes (signed-in user whose role allows administration)
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc("/admin", h.Admin)
+	adminMux.HandleFunc("/admin/users", h.Users)
+	adminMux.HandleFunc("/admin/users/save", h.SaveUser)
+	adminMux.HandleFunc("/admin/users/delete", h.DeleteUser)
+	adminMux.HandleFunc("/admin/lockouts", h.Lockouts)
+	adminMux.HandleFunc("/admin/lockouts/clear", h.ClearLockout)
+	adminMux.HandleFunc("/admin/sessions", h.Sessions)
+	adminMux.HandleFunc("/admin/sessions/terminate", h.TerminateSession)
+
+	// Protected routes (signed-in user with an SFTP connection required);
+	// file operations also need a role that allows them
 	protectedMux := http.NewServeMux()
-	protectedMux.HandleFunc("/disconnect", h.Logout)
-	protectedMux.HandleFunc("/download", h.Download)
-	protectedMux.HandleFunc("/download-multiple", h.DownloadMultiple)
-	protectedMux.HandleFunc("/upload", h.Upload)
-	protectedMux.HandleFunc("/preview", h.Preview)
-	protectedMux.HandleFunc("/delete", h.Delete)
+	protectedMux.HandleFunc("/disconnect", h.Disconnect)
+	protectedMux.Handle("/download", mw.Require(config.PermDownload)(http.HandlerFunc(h.Download)))
+	protectedMux.Handle("/download-multiple", mw.Require(config.PermDownload)(http.HandlerFunc(h.DownloadMultiple)))
+	protectedMux.Handle("/upload", mw.Require(config.PermUpload)(http.HandlerFunc(h.Upload)))
+	protectedMux.Handle("/preview", mw.Require(config.PermPreview)(http.HandlerFunc(h.Preview)))
+	protectedMux.Handle("/delete", mw.Require(config.PermDelete)(http.HandlerFunc(h.Delete)))
+	protectedMux.Handle("/rename", mw.Require(config.PermRename)(http.HandlerFunc(h.Rename)))
+	protectedMux.Handle("/move", mw.Require(config.PermRename)(http.HandlerFunc(h.Move)))
 
 	// Apply middleware to public routes
 	publicHandler := mw.SecurityHeaders(
 		mw.CORS(
-			mw.Logger(
-				mw.Recovery(publicMux))))
+			mw.Flash(
+				mw.CSRF(
+					mw.Logger(
+						mw.Recovery(publicMux))))))
+
+	// Apply middleware to user routes
+	userHandler := mw.SecurityHeaders(
+		mw.CORS(
+			mw.Flash(
+				mw.UserAuth(
+					mw.CSRF(
+						mw.Logger(
+							mw.Recovery(userMux)))))))
+
+	// Apply middleware to status routes. Polls are not logged.
+	statusHandler := mw.SecurityHeaders(
+		mw.CORS(
+			mw.PassiveUserAuth(
+				mw.CSRF(
+					mw.Recovery(statusMux)))))
+
+	// Apply middleware to admin routes
+	adminHandler := mw.SecurityHeaders(
+		mw.CORS(
+			mw.UserAuth(
+				mw.CSRF(
+					mw.Require(config.PermAdmin)(
+						mw.Logger(
+							mw.Recovery(adminMux)))))))
 
 	// Apply middleware to protected routes
 	protectedHandler := mw.SecurityHeaders(
 		mw.CORS(
-			mw.SessionAuth(
-				mw.Logger(
-					mw.Recovery(protectedMux)))))
+			mw.Flash(
+				mw.UserAuth(
+					mw.CSRF(
+						mw.SessionAuth(
+							mw.Logger(
+								mw.Recovery(protectedMux))))))))
 
 	// Mount handlers
-	mux.Handle("/", publicHandler)
+	mux.Handle("/", userHandler)
+	mux.Handle("/login", publicHandler)
+	mux.Handle("/auth/", publicHandler)
+	mux.Handle("/health", publicHandler)
+	mux.Handle("/version", publicHandler)
+	mux.Handle("/api/notices", statusHandler)
+	mux.Handle("/api/session", statusHandler)
+	mux.Handle("/admin", adminHandler)
+	mux.Handle("/admin/", adminHandler)
 	mux.Handle("/disconnect", protectedHandler)
 	mux.Handle("/download", protectedHandler)
 	mux.Handle("/download-multiple", protectedHandler)
 	mux.Handle("/upload", protectedHandler)
 	mux.Handle("/preview", protectedHandler)
 	mux.Handle("/delete", protectedHandler)
+	mux.Handle("/rename", protectedHandler)
+	mux.Handle("/move", protectedHandler)
 
 	return mux
 }
@@ -369,7 +485,28 @@ ENVIRONMENT VARIABLES:
     SFTP_TLS_ENABLED  Enable TLS (default: false)
     SFTP_CERT_FILE    TLS certificate file
     SFTP_KEY_FILE     TLS private key file
+    SFTP_TRUSTED_PROXIES  Reverse proxies whose X-Forwarded-For is trusted (comma-separated IPs or CIDRs)
     SFTP_LOG_LEVEL    Log level (debug, info, warn, error)
+    SFTP_HOST_KEY_MODE  Host key checking: strict, tofu or prompt (default: prompt)
+    SFTP_PROXY        Outbound proxy URL (socks5://host:port or http://host:port)
+    SFTP_ALLOW_CONNECTION_PROXY  Let users choose a proxy per connection (default: true, off under a target policy)
+    SFTP_VAULT_FILE   Encrypted profile vaults, one per user (default: profiles.vault)
+    SFTP_SSH_CONFIG   OpenSSH client config whose hosts are offered on the login page
+    SFTP_MAX_CONNECTIONS  SFTP connections per browser session (default: 5)
+    SFTP_MAX_SESSIONS_PER_USER  SFTP connections per application user (default: no limit)
+    SFTP_MAX_SESSIONS_PER_HOST  SFTP connections per target host (default: no limit)
+    SFTP_USERS_FILE   Application user accounts (default: users.json)
+    SFTP_ADMIN_PASSWORD  Password of the admin account created on first start
+    SFTP_DEFAULT_ROLE Role of accounts without one (default: viewer)
+    SFTP_SESSION_SECRET  Secret that signs session cookies (default: random per start)
+    SFTP_SESSION_STORE  Where sessions are kept: memory or disk (default: memory)
+    SFTP_SESSION_STORE_FILE  Encrypted session store of the disk store (default: sessions.db)
+    SFTP_OIDC_ISSUER  OpenID Connect issuer URL; enables single sign-on
+    SFTP_OIDC_CLIENT_ID, SFTP_OIDC_CLIENT_SECRET  OpenID Connect client credentials
+    SFTP_OIDC_REDIRECT_URL  Callback URL registered with the provider
+    SFTP_LDAP_URL     LDAP server (ldap:// or ldaps://); enables directory sign-in
+    SFTP_LDAP_BIND_DN, SFTP_LDAP_BIND_PASSWORD  Service account used to find users
+    SFTP_LDAP_BASE_DN Where users are searched
 
 EXAMPLES:
     # Start with default settings
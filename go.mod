@@ -5,6 +5,7 @@ go 1.21
 require (
 	github.com/pkg/sftp v1.13.6
 	golang.org/x/crypto v0.17.0
+	golang.org/x/net v0.19.0
 )
 
 require (
@@ -3,8 +3,13 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,6 +20,8 @@ type Config struct {
 	Session  SessionConfig  `json:"session"`
 	UI       UIConfig       `json:"ui"`
 	Logging  LoggingConfig  `json:"logging"`
+	SSH      SSHConfig      `json:"ssh"`
+	Policy   PolicyConfig   `json:"policy"`
 }
 
 // ServerConfig contains HTTP server configuration
@@ -27,19 +34,283 @@ type ServerConfig struct {
 	TLSEnabled   bool          `json:"tls_enabled"`
 	CertFile     string        `json:"cert_file"`
 	KeyFile      string        `json:"key_file"`
+	// TrustedProxies are the addresses or CIDR ranges of reverse proxies
+	// whose X-Forwarded-For and X-Real-IP headers name the client. The
+	// headers of any other client are ignored.
+	TrustedProxies []string `json:"trusted_proxies"`
 }
 
 // SecurityConfig contains security-related settings
 type SecurityConfig struct {
-	MaxLoginAttempts    int           `json:"max_login_attempts"`
+	MaxLoginAttempts int `json:"max_login_attempts"`
+	// MaxLockout caps the lockout after repeated failed SFTP logins
+	MaxLockout time.Duration `json:"max_lockout"`
+	// LoginTimeout is the absolute lifetime of a sign-in; users must sign
+	// in again after it however active they are
 	LoginTimeout        time.Duration `json:"login_timeout"`
 	SessionCookieName   string        `json:"session_cookie_name"`
 	SessionCookieSecure bool          `json:"session_cookie_secure"`
-	CSRFEnabled         bool          `json:"csrf_enabled"`
-	CORSEnabled         bool          `json:"cors_enabled"`
-	AllowedOrigins      []string      `json:"allowed_origins"`
+	// SessionSecret signs session cookies. A random secret is used when it
+	// is empty, which signs everyone out when the server restarts.
+	SessionSecret string `json:"session_secret"`
+	// SessionBinding ties sessions to the client that signed in
+	SessionBinding   SessionBindingConfig `json:"session_binding"`
+	CSRFEnabled      bool                 `json:"csrf_enabled"`
+	CORSEnabled      bool                 `json:"cors_enabled"`
+	AllowedOrigins   []string             `json:"allowed_origins"`
+	HostKeyMode      string               `json:"host_key_mode"`
+	KnownHostsFile   string               `json:"known_hosts_file"`
+	ChallengeTimeout time.Duration        `json:"challenge_timeout"`
+	VaultFile        string               `json:"vault_file"`
+	VaultAutoLock    time.Duration        `json:"vault_auto_lock"`
+	UsersFile        string               `json:"users_file"`
+
+	// OIDC configures single sign-on with an OpenID Connect provider
+	OIDC OIDCConfig `json:"oidc"`
+	// LDAP configures password sign-in against an LDAP directory
+	LDAP LDAPConfig `json:"ldap"`
+}
+
+// SessionBindingConfig ties browser sessions to the client that signed in.
+// A session cookie presented by another client ends the session.
+type SessionBindingConfig struct {
+	// IPv4Prefix and IPv6Prefix bind a session to the network of the
+	// client's address, e.g. 24 for its /24. 0 turns the check off.
+	IPv4Prefix int `json:"ipv4_prefix"`
+	IPv6Prefix int `json:"ipv6_prefix"`
+	// UserAgent binds a session to the browser's User-Agent header
+	UserAgent bool `json:"user_agent"`
+}
+
+// OIDCConfig configures sign-in through an OpenID Connect provider using
+// the authorization code flow with PKCE. It is enabled when an issuer is set.
+type OIDCConfig struct {
+	// Issuer is the provider's issuer URL; its discovery document is read
+	// from /.well-known/openid-configuration below it
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+	// RedirectURL is the callback registered with the provider. When empty
+	// it is derived from the request as <scheme>://<host>/auth/oidc/callback.
+	RedirectURL string `json:"redirect_url"`
+	// ProviderName labels the sign-in button
+	ProviderName string `json:"provider_name"`
+
+	// UsernameClaim names the ID token claim used as the app username
+	UsernameClaim string `json:"username_claim"`
+	// RolesClaim names a string or list claim, e.g. groups, whose values
+	// are looked up in RoleMapping. The most privileged match wins; without
+	// a match the policy's default role is used.
+	RolesClaim  string            `json:"roles_claim"`
+	RoleMapping map[string]string `json:"role_mapping"`
+}
+
+// Enabled reports whether OIDC sign-in is configured
+func (o OIDCConfig) Enabled() bool {
+	return o.Issuer != ""
+}
+
+// LDAPConfig configures sign-in with a bind against an LDAP directory or
+// Active Directory. It is enabled when a URL is set.
+type LDAPConfig struct {
+	// URL is an ldap:// or ldaps:// server URL
+	URL                string        `json:"url"`
+	StartTLS           bool          `json:"start_tls"`
+	InsecureSkipVerify bool          `json:"insecure_skip_verify"`
+	Timeout            time.Duration `json:"timeout"`
+
+	// BindDN and BindPassword are a service account used to look users up.
+	// Without them, users bind directly as UserDNTemplate.
+	BindDN       string `json:"bind_dn"`
+	BindPassword string `json:"bind_password"`
+	// BaseDN is searched with UserFilter, where {username} is replaced by
+	// the escaped sign-in name, e.g. (sAMAccountName={username}) for AD
+	BaseDN     string `json:"base_dn"`
+	UserFilter string `json:"user_filter"`
+	// UserDNTemplate builds the bind DN from the sign-in name, e.g.
+	// uid={username},ou=people,dc=example,dc=com
+	UserDNTemplate string `json:"user_dn_template"`
+
+	// GroupAttribute lists the user's groups on the user entry (memberOf).
+	// GroupFilter additionally searches GroupBaseDN for groups, where {dn}
+	// is replaced by the user's DN, e.g. (member={dn}).
+	GroupAttribute string `json:"group_attribute"`
+	GroupBaseDN    string `json:"group_base_dn"`
+	GroupFilter    string `json:"group_filter"`
+	// GroupMapping maps full group DNs to roles. The match ranked first in
+	// the role priority wins; without a match the default role is used.
+	GroupMapping map[string]string `json:"group_mapping"`
+}
+
+// Enabled reports whether LDAP sign-in is configured
+func (l LDAPConfig) Enabled() bool {
+	return l.URL != ""
+}
+
+// Host key verification modes
+const (
+	// HostKeyModeStrict only accepts hosts already present in known_hosts
+	HostKeyModeStrict = "strict"
+	// HostKeyModeTOFU silently trusts and records keys of unknown hosts
+	HostKeyModeTOFU = "tofu"
+	// HostKeyModePrompt asks the user to confirm keys of unknown hosts
+	HostKeyModePrompt = "prompt"
+)
+
+// PolicyConfig maps application user roles to the permissions they grant
+type PolicyConfig struct {
+	// Roles lists the permissions of each role. Roles from the config file
+	// are merged over the built-in viewer, uploader, editor and admin roles.
+	Roles map[string][]string `json:"roles"`
+	// DefaultRole is given to accounts that have no role
+	DefaultRole string `json:"default_role"`
+	// RolePriority ranks roles from most to least privileged. When an
+	// identity provider maps a user's groups to several roles, the one
+	// listed first is given, so every mapped role must be listed.
+	RolePriority []string `json:"role_priority"`
+	// Targets limits the SSH servers users may connect to
+	Targets TargetPolicy `json:"targets"`
+}
+
+// TargetPolicy is an allowlist of SSH servers, so the web client cannot be
+// used to reach arbitrary hosts and ports. Without any rules every target
+// is allowed.
+type TargetPolicy struct {
+	// Allow lists the targets every role may reach
+	Allow []TargetRule `json:"allow"`
+	// Roles lists further targets per role
+	Roles map[string][]TargetRule `json:"roles"`
+	// Deny lists host patterns and networks that are refused even when a
+	// rule allows them, e.g. 169.254.0.0/16 for cloud metadata services
+	Deny []string `json:"deny"`
+}
+
+// TargetRule allows connections to matching hosts on some ports
+type TargetRule struct {
+	// Host is a host name pattern such as *.example.com, matched against
+	// the name the user entered, or an IP address or CIDR network such as
+	// 10.0.0.0/8, matched against the resolved address
+	Host string `json:"host"`
+	// Ports lists ports and ranges such as "22" or "2200-2299". Without
+	// ports only 22 is allowed.
+	Ports []string `json:"ports"`
+}
+
+// Enabled reports whether any target rules are configured
+func (t TargetPolicy) Enabled() bool {
+	return len(t.Allow) > 0 || len(t.Roles) > 0 || len(t.Deny) > 0
+}
+
+// restricted reports whether targets must match an allow rule
+func (t TargetPolicy) restricted() bool {
+	return len(t.Allow) > 0 || len(t.Roles) > 0
+}
+
+// matches reports whether the rule covers a host name, resolved to ip, on
+// port
+func (r TargetRule) matches(host string, ip net.IP, port int) bool {
+	return matchTargetHost(r.Host, host, ip) && r.allowsPort(port)
+}
+
+// allowsPort reports whether port is one of the rule's ports
+func (r TargetRule) allowsPort(port int) bool {
+	if len(r.Ports) == 0 {
+		return port == 22
+	}
+	for _, ports := range r.Ports {
+		if low, high, err := parsePortRange(ports); err == nil && port >= low && port <= high {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTargetHost matches a host pattern, IP address or CIDR network
+// against a host name and the address it resolved to
+func matchTargetHost(pattern, host string, ip net.IP) bool {
+	if _, network, err := net.ParseCIDR(pattern); err == nil {
+		return ip != nil && network.Contains(ip)
+	}
+	if patternIP := net.ParseIP(pattern); patternIP != nil {
+		return ip != nil && patternIP.Equal(ip)
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	matched, _ := path.Match(strings.ToLower(pattern), host)
+	return matched
+}
+
+// parsePortRange parses "22" or "2200-2299"
+func parsePortRange(ports string) (int, int, error) {
+	lowText, highText, isRange := strings.Cut(strings.TrimSpace(ports), "-")
+	low, err := strconv.Atoi(lowText)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port %q", ports)
+	}
+	high := low
+	if isRange {
+		if high, err = strconv.Atoi(highText); err != nil {
+			return 0, 0, fmt.Errorf("invalid port range %q", ports)
+		}
+	}
+	if low < 1 || high > 65535 || low > high {
+		return 0, 0, fmt.Errorf("invalid port range %q", ports)
+	}
+	return low, high, nil
+}
+
+// validateTargetHost checks that a pattern is a valid CIDR, IP or glob
+func validateTargetHost(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("host is required")
+	}
+	if strings.Contains(pattern, "/") {
+		if _, _, err := net.ParseCIDR(pattern); err != nil {
+			return fmt.Errorf("invalid network %q", pattern)
+		}
+		return nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid host pattern %q", pattern)
+	}
+	return nil
 }
 
+// Permissions that roles can grant
+const (
+	// PermDownload allows downloading files, directories and ZIP bundles
+	PermDownload = "download"
+	// PermPreview allows previewing files in the browser
+	PermPreview = "preview"
+	// PermUpload allows uploading files
+	PermUpload = "upload"
+	// PermDelete allows deleting files and directories
+	PermDelete = "delete"
+	// PermRename allows renaming and moving files
+	PermRename = "rename"
+	// PermChmod allows changing file permissions
+	PermChmod = "chmod"
+	// PermProfiles allows keeping connection profiles in the profile vault
+	// and connecting with them
+	PermProfiles = "profiles"
+	// PermDiagnostics allows probing SSH servers from the diagnostics page
+	PermDiagnostics = "diagnostics"
+	// PermAdmin allows managing application users
+	PermAdmin = "admin"
+)
+
+// AllPermissions lists every permission in the order they are displayed
+var AllPermissions = []string{PermDownload, PermPreview, PermUpload, PermDelete, PermRename, PermChmod, PermProfiles, PermDiagnostics, PermAdmin}
+
+// Built-in roles
+const (
+	RoleViewer   = "viewer"
+	RoleUploader = "uploader"
+	RoleEditor   = "editor"
+	RoleAdmin    = "admin"
+)
+
 // SessionConfig contains session management settings
 type SessionConfig struct {
 	Timeout         time.Duration `json:"timeout"`
@@ -48,8 +319,34 @@ type SessionConfig struct {
 	SaveHistory     bool          `json:"save_history"`
 	HistoryFile     string        `json:"history_file"`
 	MaxHistory      int           `json:"max_history"`
+
+	// MaxConnections limits the SFTP connections one browser session owns
+	MaxConnections int `json:"max_connections"`
+	// MaxSessionsPerUser and MaxSessionsPerHost limit the SFTP connections
+	// of one application user and to one target host, across all browser
+	// sessions. 0 turns a limit off.
+	MaxSessionsPerUser int `json:"max_sessions_per_user"`
+	MaxSessionsPerHost int `json:"max_sessions_per_host"`
+	// EvictIdle is how long a connection must be unused before it may be
+	// closed to make room for a new one when a limit is reached. 0 turns
+	// eviction off, so new connections are refused instead.
+	EvictIdle time.Duration `json:"evict_idle"`
+
+	// Store keeps sessions in "memory" or on "disk". Sessions on disk are
+	// encrypted with a key derived from the session secret and survive a
+	// restart. Their SSH connections are re-established on first use from
+	// the vault profile or ssh_config entry they were opened with; other
+	// connections need a fresh login.
+	Store     string `json:"store"`
+	StoreFile string `json:"store_file"`
 }
 
+// Session stores
+const (
+	SessionStoreMemory = "memory"
+	SessionStoreDisk   = "disk"
+)
+
 // UIConfig contains user interface settings
 type UIConfig struct {
 	DefaultTheme   string `json:"default_theme"`
@@ -62,6 +359,43 @@ type UIConfig struct {
 	EnableUpload   bool   `json:"enable_upload"`
 }
 
+// SSHConfig contains settings for outbound SSH connections
+type SSHConfig struct {
+	DialTimeout time.Duration `json:"dial_timeout"`
+	// KeepaliveInterval is how often keepalive requests are sent on idle
+	// connections; zero disables them
+	KeepaliveInterval time.Duration `json:"keepalive_interval"`
+	// KeepaliveCountMax is how many unanswered keepalives mark a
+	// connection as dead
+	KeepaliveCountMax int `json:"keepalive_count_max"`
+
+	// Ciphers, KeyExchanges, MACs and HostKeyAlgorithms restrict the SSH
+	// algorithms offered to servers; empty lists use the library defaults
+	Ciphers           []string `json:"ciphers"`
+	KeyExchanges      []string `json:"key_exchanges"`
+	MACs              []string `json:"macs"`
+	HostKeyAlgorithms []string `json:"host_key_algorithms"`
+
+	SFTP SFTPClientConfig `json:"sftp"`
+	// Proxy is an optional socks5:// or http:// (CONNECT) proxy URL used for
+	// all connections unless a connection overrides it
+	Proxy string `json:"proxy"`
+	// AllowConnectionProxy lets users choose a proxy, or "direct", per
+	// connection. It has no effect while a target policy is active.
+	AllowConnectionProxy bool `json:"allow_connection_proxy"`
+	// ClientConfigFile is an OpenSSH client config (ssh_config) whose Host
+	// blocks are offered as connections on the login page
+	ClientConfigFile string `json:"client_config_file"`
+}
+
+// SFTPClientConfig contains pkg/sftp client tuning
+type SFTPClientConfig struct {
+	MaxPacket                    int  `json:"max_packet"`
+	MaxConcurrentRequestsPerFile int  `json:"max_concurrent_requests_per_file"`
+	UseConcurrentWrites          bool `json:"use_concurrent_writes"`
+	UseConcurrentReads           bool `json:"use_concurrent_reads"`
+}
+
 // LoggingConfig contains logging configuration
 type LoggingConfig struct {
 	Level      string `json:"level"`
@@ -108,17 +442,38 @@ func DefaultConfig() *Config {
 		},
 		Security: SecurityConfig{
 			MaxLoginAttempts:    5,
+			MaxLockout:          24 * time.Hour,
 			LoginTimeout:        24 * time.Hour,
 			SessionCookieName:   "sftp_session",
 			SessionCookieSecure: false,
 			CSRFEnabled:         true,
 			CORSEnabled:         false,
 			AllowedOrigins:      []string{"http://localhost:8088"},
+			HostKeyMode:         HostKeyModePrompt,
+			KnownHostsFile:      "known_hosts",
+			ChallengeTimeout:    2 * time.Minute,
+			VaultFile:           "profiles.vault",
+			VaultAutoLock:       15 * time.Minute,
+			UsersFile:           "users.json",
+			OIDC: OIDCConfig{
+				Scopes:        []string{"openid", "profile", "email"},
+				ProviderName:  "Single Sign-On",
+				UsernameClaim: "preferred_username",
+			},
+			LDAP: LDAPConfig{
+				Timeout:        10 * time.Second,
+				UserFilter:     "(uid={username})",
+				GroupAttribute: "memberOf",
+			},
 		},
 		Session: SessionConfig{
 			Timeout:         30 * time.Minute,
 			CleanupInterval: 5 * time.Minute,
 			MaxSessions:     100,
+			MaxConnections:  5,
+			EvictIdle:       5 * time.Minute,
+			Store:           SessionStoreMemory,
+			StoreFile:       "sessions.db",
 			SaveHistory:     true,
 			HistoryFile:     "login_history.json",
 			MaxHistory:      50,
@@ -142,6 +497,27 @@ func DefaultConfig() *Config {
 			MaxBackups: 3,
 			Compress:   true,
 		},
+		SSH: SSHConfig{
+			DialTimeout:          30 * time.Second,
+			KeepaliveInterval:    30 * time.Second,
+			KeepaliveCountMax:    3,
+			AllowConnectionProxy: true,
+			SFTP: SFTPClientConfig{
+				MaxPacket:                    32768,
+				MaxConcurrentRequestsPerFile: 64,
+				UseConcurrentReads:           true,
+			},
+		},
+		Policy: PolicyConfig{
+			Roles: map[string][]string{
+				RoleViewer:   {PermDownload, PermPreview, PermProfiles, PermDiagnostics},
+				RoleUploader: {PermDownload, PermPreview, PermUpload, PermProfiles, PermDiagnostics},
+				RoleEditor:   {PermDownload, PermPreview, PermUpload, PermDelete, PermRename, PermChmod, PermProfiles, PermDiagnostics},
+				RoleAdmin:    AllPermissions,
+			},
+			DefaultRole:  RoleViewer,
+			RolePriority: []string{RoleAdmin, RoleEditor, RoleUploader, RoleViewer},
+		},
 	}
 }
 
@@ -178,6 +554,9 @@ func loadFromEnv(config *Config) {
 	if keyFile := os.Getenv("SFTP_KEY_FILE"); keyFile != "" {
 		config.Server.KeyFile = keyFile
 	}
+	if proxies := os.Getenv("SFTP_TRUSTED_PROXIES"); proxies != "" {
+		config.Server.TrustedProxies = strings.Split(proxies, ",")
+	}
 
 	// Security config
 	if maxAttempts := os.Getenv("SFTP_MAX_LOGIN_ATTEMPTS"); maxAttempts != "" {
@@ -188,6 +567,45 @@ func loadFromEnv(config *Config) {
 	if secure := os.Getenv("SFTP_COOKIE_SECURE"); secure == "true" {
 		config.Security.SessionCookieSecure = true
 	}
+	if secret := os.Getenv("SFTP_SESSION_SECRET"); secret != "" {
+		config.Security.SessionSecret = secret
+	}
+	if mode := os.Getenv("SFTP_HOST_KEY_MODE"); mode != "" {
+		config.Security.HostKeyMode = mode
+	}
+	if knownHosts := os.Getenv("SFTP_KNOWN_HOSTS_FILE"); knownHosts != "" {
+		config.Security.KnownHostsFile = knownHosts
+	}
+	if vaultFile := os.Getenv("SFTP_VAULT_FILE"); vaultFile != "" {
+		config.Security.VaultFile = vaultFile
+	}
+	if usersFile := os.Getenv("SFTP_USERS_FILE"); usersFile != "" {
+		config.Security.UsersFile = usersFile
+	}
+	if issuer := os.Getenv("SFTP_OIDC_ISSUER"); issuer != "" {
+		config.Security.OIDC.Issuer = issuer
+	}
+	if clientID := os.Getenv("SFTP_OIDC_CLIENT_ID"); clientID != "" {
+		config.Security.OIDC.ClientID = clientID
+	}
+	if clientSecret := os.Getenv("SFTP_OIDC_CLIENT_SECRET"); clientSecret != "" {
+		config.Security.OIDC.ClientSecret = clientSecret
+	}
+	if redirectURL := os.Getenv("SFTP_OIDC_REDIRECT_URL"); redirectURL != "" {
+		config.Security.OIDC.RedirectURL = redirectURL
+	}
+	if ldapURL := os.Getenv("SFTP_LDAP_URL"); ldapURL != "" {
+		config.Security.LDAP.URL = ldapURL
+	}
+	if bindDN := os.Getenv("SFTP_LDAP_BIND_DN"); bindDN != "" {
+		config.Security.LDAP.BindDN = bindDN
+	}
+	if bindPassword := os.Getenv("SFTP_LDAP_BIND_PASSWORD"); bindPassword != "" {
+		config.Security.LDAP.BindPassword = bindPassword
+	}
+	if baseDN := os.Getenv("SFTP_LDAP_BASE_DN"); baseDN != "" {
+		config.Security.LDAP.BaseDN = baseDN
+	}
 
 	// Session config
 	if timeout := os.Getenv("SFTP_SESSION_TIMEOUT"); timeout != "" {
@@ -201,6 +619,33 @@ func loadFromEnv(config *Config) {
 		}
 	}
 
+	if maxConnections := os.Getenv("SFTP_MAX_CONNECTIONS"); maxConnections != "" {
+		if m, err := strconv.Atoi(maxConnections); err == nil {
+			config.Session.MaxConnections = m
+		}
+	}
+	if maxPerUser := os.Getenv("SFTP_MAX_SESSIONS_PER_USER"); maxPerUser != "" {
+		if m, err := strconv.Atoi(maxPerUser); err == nil {
+			config.Session.MaxSessionsPerUser = m
+		}
+	}
+	if maxPerHost := os.Getenv("SFTP_MAX_SESSIONS_PER_HOST"); maxPerHost != "" {
+		if m, err := strconv.Atoi(maxPerHost); err == nil {
+			config.Session.MaxSessionsPerHost = m
+		}
+	}
+	if store := os.Getenv("SFTP_SESSION_STORE"); store != "" {
+		config.Session.Store = store
+	}
+	if storeFile := os.Getenv("SFTP_SESSION_STORE_FILE"); storeFile != "" {
+		config.Session.StoreFile = storeFile
+	}
+
+	// Policy config
+	if defaultRole := os.Getenv("SFTP_DEFAULT_ROLE"); defaultRole != "" {
+		config.Policy.DefaultRole = defaultRole
+	}
+
 	// UI config
 	if theme := os.Getenv("SFTP_DEFAULT_THEME"); theme != "" {
 		config.UI.DefaultTheme = theme
@@ -216,6 +661,22 @@ func loadFromEnv(config *Config) {
 	if format := os.Getenv("SFTP_LOG_FORMAT"); format != "" {
 		config.Logging.Format = format
 	}
+
+	// SSH config
+	if proxy := os.Getenv("SFTP_PROXY"); proxy != "" {
+		config.SSH.Proxy = proxy
+	}
+	if allow := os.Getenv("SFTP_ALLOW_CONNECTION_PROXY"); allow == "false" {
+		config.SSH.AllowConnectionProxy = false
+	}
+	if clientConfig := os.Getenv("SFTP_SSH_CONFIG"); clientConfig != "" {
+		config.SSH.ClientConfigFile = clientConfig
+	}
+	if keepalive := os.Getenv("SFTP_KEEPALIVE_INTERVAL"); keepalive != "" {
+		if k, err := time.ParseDuration(keepalive); err == nil {
+			config.SSH.KeepaliveInterval = k
+		}
+	}
 }
 
 // Validate validates the configuration
@@ -231,11 +692,104 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if _, err := c.Server.TrustedProxyNets(); err != nil {
+		return err
+	}
+
 	// Validate security config
 	if c.Security.MaxLoginAttempts < 1 {
 		return fmt.Errorf("max_login_attempts must be at least 1")
 	}
 
+	if c.Security.MaxLockout < time.Minute {
+		return fmt.Errorf("max_lockout must be at least 1 minute")
+	}
+
+	if c.Security.LoginTimeout < time.Minute {
+		return fmt.Errorf("login_timeout must be at least 1 minute")
+	}
+
+	if secret := c.Security.SessionSecret; secret != "" && len(secret) < 32 {
+		return fmt.Errorf("session_secret must be at least 32 characters")
+	}
+
+	if binding := c.Security.SessionBinding; binding.IPv4Prefix < 0 || binding.IPv4Prefix > 32 || binding.IPv6Prefix < 0 || binding.IPv6Prefix > 128 {
+		return fmt.Errorf("session_binding prefixes must be 0-32 for IPv4 and 0-128 for IPv6")
+	}
+
+	switch c.Security.HostKeyMode {
+	case HostKeyModeStrict, HostKeyModeTOFU, HostKeyModePrompt:
+	default:
+		return fmt.Errorf("invalid host_key_mode: %s", c.Security.HostKeyMode)
+	}
+
+	if c.Security.KnownHostsFile == "" {
+		return fmt.Errorf("known_hosts_file must be specified")
+	}
+
+	if c.Security.ChallengeTimeout < 10*time.Second {
+		return fmt.Errorf("challenge_timeout must be at least 10 seconds")
+	}
+
+	if c.Security.VaultAutoLock < time.Minute {
+		return fmt.Errorf("vault_auto_lock must be at least 1 minute")
+	}
+
+	if c.Security.UsersFile == "" {
+		return fmt.Errorf("users_file is required")
+	}
+
+	if oidc := c.Security.OIDC; oidc.Enabled() {
+		issuerURL, err := url.Parse(oidc.Issuer)
+		if err != nil || issuerURL.Host == "" || (issuerURL.Scheme != "https" && issuerURL.Scheme != "http") {
+			return fmt.Errorf("invalid oidc issuer: %s", oidc.Issuer)
+		}
+		if oidc.ClientID == "" {
+			return fmt.Errorf("oidc client_id is required")
+		}
+		if oidc.UsernameClaim == "" {
+			return fmt.Errorf("oidc username_claim is required")
+		}
+		for value, role := range oidc.RoleMapping {
+			if _, ok := c.Policy.Roles[role]; !ok {
+				return fmt.Errorf("oidc role_mapping %s: unknown role: %s", value, role)
+			}
+			if !containsString(c.Policy.RolePriority, role) {
+				return fmt.Errorf("oidc role_mapping %s: role %s is not ranked in policy role_priority", value, role)
+			}
+		}
+	}
+
+	if ldap := c.Security.LDAP; ldap.Enabled() {
+		ldapURL, err := url.Parse(ldap.URL)
+		if err != nil || ldapURL.Host == "" || (ldapURL.Scheme != "ldap" && ldapURL.Scheme != "ldaps") {
+			return fmt.Errorf("invalid ldap url: %s", ldap.URL)
+		}
+		if ldap.Timeout < time.Second {
+			return fmt.Errorf("ldap timeout must be at least 1 second")
+		}
+		if ldap.UserDNTemplate == "" && (ldap.BindDN == "" || ldap.BaseDN == "") {
+			return fmt.Errorf("ldap needs user_dn_template, or bind_dn and base_dn to search for users")
+		}
+		if ldap.BindDN != "" && !strings.Contains(ldap.UserFilter, "{username}") {
+			return fmt.Errorf("ldap user_filter must contain {username}")
+		}
+		if ldap.GroupFilter != "" && ldap.GroupBaseDN == "" {
+			return fmt.Errorf("ldap group_filter requires group_base_dn")
+		}
+		for group, role := range ldap.GroupMapping {
+			if !strings.Contains(group, "=") {
+				return fmt.Errorf("ldap group_mapping %s: must be the full DN of a group", group)
+			}
+			if _, ok := c.Policy.Roles[role]; !ok {
+				return fmt.Errorf("ldap group_mapping %s: unknown role: %s", group, role)
+			}
+			if !containsString(c.Policy.RolePriority, role) {
+				return fmt.Errorf("ldap group_mapping %s: role %s is not ranked in policy role_priority", group, role)
+			}
+		}
+	}
+
 	// Validate session config
 	if c.Session.Timeout < time.Minute {
 		return fmt.Errorf("session timeout must be at least 1 minute")
@@ -244,6 +798,28 @@ func (c *Config) Validate() error {
 	if c.Session.MaxSessions < 1 {
 		return fmt.Errorf("max_sessions must be at least 1")
 	}
+	if c.Session.MaxConnections < 1 {
+		return fmt.Errorf("max_connections must be at least 1")
+	}
+	if c.Session.MaxSessionsPerUser < 0 || c.Session.MaxSessionsPerHost < 0 {
+		return fmt.Errorf("max_sessions_per_user and max_sessions_per_host must not be negative")
+	}
+	if c.Session.EvictIdle < 0 {
+		return fmt.Errorf("evict_idle must not be negative")
+	}
+	switch c.Session.Store {
+	case SessionStoreMemory:
+	case SessionStoreDisk:
+		if c.Session.StoreFile == "" {
+			return fmt.Errorf("session store_file is required for the disk store")
+		}
+		// Restored sessions are useless unless their cookies still verify
+		if c.Security.SessionSecret == "" {
+			return fmt.Errorf("the disk session store requires session_secret")
+		}
+	default:
+		return fmt.Errorf("invalid session store: %s", c.Session.Store)
+	}
 
 	// Validate UI config
 	if c.UI.DefaultView != "list" && c.UI.DefaultView != "grid" && c.UI.DefaultView != "detailed" {
@@ -260,6 +836,148 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid log level: %s", c.Logging.Level)
 	}
 
+	// Validate SSH config
+	if c.SSH.DialTimeout < time.Second {
+		return fmt.Errorf("ssh dial_timeout must be at least 1 second")
+	}
+
+	if c.SSH.KeepaliveInterval != 0 && c.SSH.KeepaliveInterval < time.Second {
+		return fmt.Errorf("ssh keepalive_interval must be at least 1 second or 0 to disable")
+	}
+
+	if c.SSH.KeepaliveCountMax < 1 {
+		return fmt.Errorf("ssh keepalive_count_max must be at least 1")
+	}
+
+	if c.SSH.SFTP.MaxPacket < 1<<10 || c.SSH.SFTP.MaxPacket > 1<<18 {
+		return fmt.Errorf("sftp max_packet must be between 1024 and 262144 bytes")
+	}
+
+	if c.SSH.SFTP.MaxConcurrentRequestsPerFile < 1 || c.SSH.SFTP.MaxConcurrentRequestsPerFile > 1024 {
+		return fmt.Errorf("sftp max_concurrent_requests_per_file must be between 1 and 1024")
+	}
+
+	// Validate policy config
+	for role, permissions := range c.Policy.Roles {
+		for _, permission := range permissions {
+			if !containsString(AllPermissions, permission) {
+				return fmt.Errorf("role %s: unknown permission: %s", role, permission)
+			}
+		}
+	}
+
+	if _, ok := c.Policy.Roles[c.Policy.DefaultRole]; !ok {
+		return fmt.Errorf("policy default_role %s is not a defined role", c.Policy.DefaultRole)
+	}
+
+	for i, role := range c.Policy.RolePriority {
+		if _, ok := c.Policy.Roles[role]; !ok {
+			return fmt.Errorf("policy role_priority: %s is not a defined role", role)
+		}
+		if containsString(c.Policy.RolePriority[:i], role) {
+			return fmt.Errorf("policy role_priority: %s is listed twice", role)
+		}
+	}
+
+	if !c.Allows(RoleAdmin, PermAdmin) {
+		return fmt.Errorf("policy role %s must grant the %s permission", RoleAdmin, PermAdmin)
+	}
+
+	if err := validateTargetRules("allow", c.Policy.Targets.Allow); err != nil {
+		return err
+	}
+	for role, rules := range c.Policy.Targets.Roles {
+		if _, ok := c.Policy.Roles[role]; !ok {
+			return fmt.Errorf("policy targets: %s is not a defined role", role)
+		}
+		if err := validateTargetRules(role, rules); err != nil {
+			return err
+		}
+	}
+	for _, pattern := range c.Policy.Targets.Deny {
+		if err := validateTargetHost(pattern); err != nil {
+			return fmt.Errorf("policy targets deny: %w", err)
+		}
+	}
+
+	if c.SSH.Proxy != "" {
+		proxyURL, err := url.Parse(c.SSH.Proxy)
+		if err != nil {
+			return fmt.Errorf("invalid ssh proxy: %w", err)
+		}
+		if proxyURL.Scheme != "socks5" && proxyURL.Scheme != "socks5h" && proxyURL.Scheme != "http" {
+			return fmt.Errorf("invalid ssh proxy scheme: %s", proxyURL.Scheme)
+		}
+	}
+
+	return nil
+}
+
+// ConnectionProxyAllowed reports whether a connection may choose its own
+// proxy. A target policy turns it off: a proxy of the user's choosing, or
+// none, would reach servers on the user's behalf that the policy forbids.
+func (c *Config) ConnectionProxyAllowed() bool {
+	return c.SSH.AllowConnectionProxy && !c.Policy.Targets.Enabled()
+}
+
+// Allows reports whether a role grants a permission
+func (c *Config) Allows(role, permission string) bool {
+	return containsString(c.Policy.Roles[role], permission)
+}
+
+// AllowsTarget reports whether a role may connect to a host, resolved to
+// ip, on port. Rules with IP addresses or networks only match when ip is
+// known; deny entries win over allow rules.
+func (c *Config) AllowsTarget(role, host string, ip net.IP, port int) bool {
+	targets := c.Policy.Targets
+	for _, pattern := range targets.Deny {
+		if matchTargetHost(pattern, host, ip) {
+			return false
+		}
+	}
+	if !targets.restricted() {
+		return true
+	}
+
+	for _, rule := range append(append([]TargetRule{}, targets.Allow...), targets.Roles[role]...) {
+		if rule.matches(host, ip, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions returns the set of permissions a role grants
+func (c *Config) Permissions(role string) map[string]bool {
+	permissions := make(map[string]bool)
+	for _, permission := range c.Policy.Roles[role] {
+		permissions[permission] = true
+	}
+	return permissions
+}
+
+// RoleNames returns the defined roles sorted by name
+func (c *Config) RoleNames() []string {
+	roles := make([]string, 0, len(c.Policy.Roles))
+	for role := range c.Policy.Roles {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// validateTargetRules checks the hosts and ports of target rules
+func validateTargetRules(name string, rules []TargetRule) error {
+	for _, rule := range rules {
+		if err := validateTargetHost(rule.Host); err != nil {
+			return fmt.Errorf("policy targets %s: %w", name, err)
+		}
+		for _, ports := range rule.Ports {
+			if _, _, err := parsePortRange(ports); err != nil {
+				return fmt.Errorf("policy targets %s: %w", name, err)
+			}
+		}
+	}
 	return nil
 }
 
@@ -268,6 +986,35 @@ func (c *Config) GetAddr() string {
 	return fmt.Sprintf("%s:%d", c.Server.Host, c.Server.Port)
 }
 
+// TrustedProxyNets parses the trusted proxies into address ranges; a single
+// address is a range of its own
+func (s *ServerConfig) TrustedProxyNets() ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, proxy := range s.TrustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %s", proxy)
+			}
+			if ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %s", proxy)
+		}
+		nets = append(nets, network)
+	}
+	return nets, nil
+}
+
 // Save saves the configuration to a file
 func (c *Config) Save(path string) error {
 	data, err := json.MarshalIndent(c, "", "  ")
@@ -277,3 +1024,13 @@ func (c *Config) Save(path string) error {
 
 	return os.WriteFile(path, data, 0644)
 }
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"net"
+	"testing"
+)
+
+// testTargetPolicy allows an SSH farm to everyone, an internal zone to
+// admins and refuses the metadata service and one host
+func testTargetPolicy() *Config {
+	cfg := DefaultConfig()
+	cfg.Policy.Targets = TargetPolicy{
+		Allow: []TargetRule{
+			{Host: "*.sftp.example.com"},
+			{Host: "10.20.0.0/16", Ports: []string{"22", "2200-2299"}},
+			{Host: "192.0.2.10", Ports: []string{"2222"}},
+		},
+		Roles: map[string][]TargetRule{
+			RoleAdmin: {{Host: "*.internal.example.com"}},
+		},
+		Deny: []string{"169.254.0.0/16", "legacy.sftp.example.com"},
+	}
+	return cfg
+}
+
+func TestAllowsTarget(t *testing.T) {
+	cfg := testTargetPolicy()
+
+	tests := []struct {
+		name string
+		role string
+		host string
+		ip   string
+		port int
+		want bool
+	}{
+		{name: "name rule", role: RoleViewer, host: "files.sftp.example.com", port: 22, want: true},
+		{name: "name rule ignores case and trailing dot", role: RoleViewer, host: "Files.SFTP.example.com.", port: 22, want: true},
+		{name: "name rule covers port 22 only", role: RoleViewer, host: "files.sftp.example.com", port: 2222},
+		{name: "name rule needs a subdomain", role: RoleViewer, host: "sftp.example.com", port: 22},
+		{name: "suffix is not a subdomain", role: RoleViewer, host: "files.sftp.example.com.evil.test", port: 22},
+		{name: "network rule", role: RoleViewer, host: "db.corp", ip: "10.20.3.4", port: 22, want: true},
+		{name: "network rule port range", role: RoleViewer, host: "10.20.3.4", ip: "10.20.3.4", port: 2250, want: true},
+		{name: "network rule port outside range", role: RoleViewer, host: "10.20.3.4", ip: "10.20.3.4", port: 2300},
+		{name: "network rule without address", role: RoleViewer, host: "db.corp", port: 22},
+		{name: "outside network", role: RoleViewer, host: "10.21.0.1", ip: "10.21.0.1", port: 22},
+		{name: "address rule", role: RoleViewer, host: "192.0.2.10", ip: "192.0.2.10", port: 2222, want: true},
+		{name: "address rule port", role: RoleViewer, host: "192.0.2.10", ip: "192.0.2.10", port: 22},
+		{name: "role rule", role: RoleAdmin, host: "vault.internal.example.com", port: 22, want: true},
+		{name: "role rule for another role", role: RoleViewer, host: "vault.internal.example.com", port: 22},
+		{name: "admins keep the common rules", role: RoleAdmin, host: "files.sftp.example.com", port: 22, want: true},
+		{name: "denied name", role: RoleAdmin, host: "legacy.sftp.example.com", port: 22},
+		{name: "denied network wins over a name rule", role: RoleViewer, host: "meta.sftp.example.com", ip: "169.254.169.254", port: 22},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.AllowsTarget(tt.role, tt.host, net.ParseIP(tt.ip), tt.port); got != tt.want {
+				t.Errorf("AllowsTarget(%q, %q, %q, %d) = %v, want %v", tt.role, tt.host, tt.ip, tt.port, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowsTargetDenyOnly(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Policy.Targets.Deny = []string{"127.0.0.0/8"}
+
+	if !cfg.AllowsTarget(RoleViewer, "files.example.com", net.ParseIP("192.0.2.1"), 2222) {
+		t.Error("a deny-only policy refused a host it does not list")
+	}
+	if cfg.AllowsTarget(RoleViewer, "localhost", net.ParseIP("127.0.0.1"), 22) {
+		t.Error("a deny-only policy allowed a denied address")
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		ports     string
+		low, high int
+		wantErr   bool
+	}{
+		{ports: "22", low: 22, high: 22},
+		{ports: " 2200-2299 ", low: 2200, high: 2299},
+		{ports: "1-65535", low: 1, high: 65535},
+		{ports: "0", wantErr: true},
+		{ports: "65536", wantErr: true},
+		{ports: "2299-2200", wantErr: true},
+		{ports: "22-", wantErr: true},
+		{ports: "ssh", wantErr: true},
+		{ports: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		low, high, err := parsePortRange(tt.ports)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePortRange(%q) error = %v, want error %v", tt.ports, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (low != tt.low || high != tt.high) {
+			t.Errorf("parsePortRange(%q) = %d-%d, want %d-%d", tt.ports, low, high, tt.low, tt.high)
+		}
+	}
+}
+
+func TestValidateTargetPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets TargetPolicy
+		wantErr bool
+	}{
+		{name: "valid", targets: testTargetPolicy().Policy.Targets},
+		{name: "empty host", targets: TargetPolicy{Allow: []TargetRule{{Host: ""}}}, wantErr: true},
+		{name: "bad network", targets: TargetPolicy{Allow: []TargetRule{{Host: "10.0.0.0/33"}}}, wantErr: true},
+		{name: "bad pattern", targets: TargetPolicy{Allow: []TargetRule{{Host: "[a-"}}}, wantErr: true},
+		{name: "bad port", targets: TargetPolicy{Allow: []TargetRule{{Host: "*", Ports: []string{"22-21"}}}}, wantErr: true},
+		{name: "unknown role", targets: TargetPolicy{Roles: map[string][]TargetRule{"operator": {{Host: "*"}}}}, wantErr: true},
+		{name: "bad deny network", targets: TargetPolicy{Deny: []string{"fe80::/129"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Policy.Targets = tt.targets
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRolePriority(t *testing.T) {
+	tests := []struct {
+		name     string
+		priority []string
+		mapping  map[string]string
+		wantErr  bool
+	}{
+		{name: "default", priority: DefaultConfig().Policy.RolePriority, mapping: map[string]string{"cn=admins,dc=example,dc=com": RoleAdmin}},
+		{name: "unknown role", priority: []string{RoleAdmin, "operator"}, wantErr: true},
+		{name: "listed twice", priority: []string{RoleAdmin, RoleViewer, RoleAdmin}, wantErr: true},
+		{name: "mapped role not ranked", priority: []string{RoleAdmin}, mapping: map[string]string{"cn=viewers,dc=example,dc=com": RoleViewer}, wantErr: true},
+		{name: "group name instead of DN", priority: DefaultConfig().Policy.RolePriority, mapping: map[string]string{"admins": RoleAdmin}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Policy.RolePriority = tt.priority
+			cfg.Security.LDAP.URL = "ldaps://ldap.example.com"
+			cfg.Security.LDAP.UserDNTemplate = "uid={username},ou=people,dc=example,dc=com"
+			cfg.Security.LDAP.GroupMapping = tt.mapping
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"sftp-gui/internal/models"
+)
+
+// Admin renders the user administration page
+func (h *Handler) Admin(w http.ResponseWriter, r *http.Request) {
+	data := &models.PageData{
+		Theme:       h.config.UI.DefaultTheme,
+		User:        h.currentUser(r),
+		CSRFToken:   h.csrfToken(r),
+		Permissions: h.permissions(r),
+		Roles:       h.config.RoleNames(),
+		DefaultRole: h.config.Policy.DefaultRole,
+	}
+	h.templates.ExecuteTemplate(w, "admin.html", data)
+}
+
+// Users lists the application accounts
+func (h *Handler) Users(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, models.APIResponse{
+		Success: true,
+		Data:    h.userService.List(),
+	})
+}
+
+// SaveUser creates or updates an application account. Disabling an account
+// signs it out everywhere.
+func (h *Handler) SaveUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := h.userService.Save(
+		strings.TrimSpace(r.FormValue("username")),
+		r.FormValue("password"),
+		r.FormValue("role"),
+		r.FormValue("disabled") == "true",
+	)
+	if err != nil {
+		h.writeUserError(w, err)
+		return
+	}
+
+	if user.Disabled {
+		h.sessionService.DeleteUserSessions(user.Username)
+	}
+
+	h.writeJSON(w, models.APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("User %s saved", user.Username),
+		Data:    user,
+	})
+}
+
+// DeleteUser removes an application account, ends its sessions and
+// forgets its login history
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.FormValue("username")
+	if current := h.currentUser(r); current != nil && current.Username == username {
+		h.writeJSONError(w, "You cannot delete your own account", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.userService.Delete(username); err != nil {
+		h.writeUserError(w, err)
+		return
+	}
+	h.sessionService.DeleteUserSessions(username)
+	if err := h.loginHistoryService.ClearHistory(username); err != nil {
+		fmt.Printf("Failed to clear login history of %s: %v\n", username, err)
+	}
+
+	h.writeJSON(w, models.APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("User %s deleted", username),
+	})
+}
+
+// Lockouts lists the client addresses and SFTP accounts with failed logins
+func (h *Handler) Lockouts(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, models.APIResponse{
+		Success: true,
+		Data:    h.loginLimiter.List(),
+	})
+}
+
+// ClearLockout lifts the lockout of a client address or SFTP account
+func (h *Handler) ClearLockout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.FormValue("key")
+	if err := h.loginLimiter.Clear(key); err != nil {
+		h.writeJSONError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	h.writeJSON(w, models.APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Cleared %s", key),
+	})
+}
+
+// Sessions lists the open SFTP connections of all users
+func (h *Handler) Sessions(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, models.APIResponse{
+		Success: true,
+		Data: models.SessionOverview{
+			Stats:    h.sessionService.GetStats(),
+			Sessions: h.sessionService.ActiveSessions(),
+		},
+	})
+}
+
+// TerminateSession closes an SFTP connection of any user. The user's
+// browser is told who closed it and why.
+func (h *Handler) TerminateSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	notice := "An administrator closed your connection"
+	if admin := h.currentUser(r); admin != nil {
+		notice = fmt.Sprintf("Administrator %s closed your connection", admin.Username)
+	}
+	if reason := strings.TrimSpace(r.FormValue("reason")); reason != "" {
+		notice += ": " + reason
+	}
+
+	session, err := h.sessionService.TerminateSession(r.FormValue("id"), notice)
+	if err != nil {
+		h.writeJSONError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	fmt.Printf("Terminated connection %s of %s to %s@%s:%d (%s)\n", session.ID, session.AppUser, session.Username, session.Host, session.Port, notice)
+
+	h.writeJSON(w, models.APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Closed the connection of %s to %s", session.AppUser, session.Name),
+	})
+}
+
+// writeUserError writes a user service error with a matching status code
+func (h *Handler) writeUserError(w http.ResponseWriter, err error) {
+	var validationErr models.ValidationError
+
+	switch {
+	case errors.Is(err, models.ErrUserNotFound):
+		h.writeJSONError(w, err.Error(), http.StatusNotFound)
+	case errors.As(err, &validationErr):
+		h.writeJSONError(w, err.Error(), http.StatusBadRequest)
+	default:
+		h.writeJSONError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"sftp-gui/internal/middleware"
+	"sftp-gui/internal/models"
+	"sftp-gui/internal/services"
+)
+
+// oidcStateCookie ties an OpenID Connect sign-in to the browser that
+// started it
+const oidcStateCookie = "sftp_oidc_state"
+
+// SignIn renders the application sign-in form and signs users in. SFTP
+// connections can only be opened once signed in.
+func (h *Handler) SignIn(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		// Skip the form when the browser is already signed in
+		if cookie, err := r.Cookie(h.config.Security.SessionCookieName); err == nil {
+			if _, err := h.sessionService.GetWebSession(cookie.Value, middleware.ClientIP(r), r.UserAgent()); err == nil {
+				http.Redirect(w, r, "/", http.StatusFound)
+				return
+			}
+		}
+
+		errorMsg, successMsg := h.takeFlashes(r)
+		data := &models.PageData{
+			Theme:     h.config.UI.DefaultTheme,
+			Error:     errorMsg,
+			Success:   successMsg,
+			CSRFToken: h.csrfToken(r),
+		}
+		if h.oidcService.Enabled() {
+			data.SingleSignOn = h.oidcService.ProviderName()
+		}
+		h.templates.ExecuteTemplate(w, "login.html", data)
+		return
+	}
+
+	username := strings.TrimSpace(r.FormValue("username"))
+	user, err := h.authenticate(username, r.FormValue("password"))
+	if err != nil {
+		fmt.Printf("Failed sign-in for %q from %s: %v\n", username, middleware.ClientIP(r), err)
+		h.redirectError(w, r, "/login", err, models.ErrSignInFailed)
+		return
+	}
+
+	if err := h.startWebSession(w, r, user); err != nil {
+		h.redirectError(w, r, "/login", err, models.ErrSignInFailed)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// authenticate checks a password sign-in. Local accounts are checked
+// against their password hash; any other name is bound against LDAP when
+// it is configured, creating or updating the account on success.
+func (h *Handler) authenticate(username, password string) (*models.User, error) {
+	if !h.ldapService.Enabled() || h.userService.IsLocal(username) {
+		return h.userService.Authenticate(username, password)
+	}
+
+	identity, err := h.ldapService.Authenticate(username, password)
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		return nil, err
+	}
+	if err != nil {
+		// Keep directory details out of the page
+		fmt.Printf("LDAP sign-in for %q failed: %v\n", username, err)
+		return nil, models.NewAuthError("directory sign-in is unavailable, please try again later")
+	}
+
+	return h.userService.Provision(identity.Username, services.LDAPSource, "", identity.Role)
+}
+
+// OIDCLogin sends the browser to the OpenID Connect provider to sign in
+func (h *Handler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if !h.oidcService.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	authURL, state, err := h.oidcService.Begin(h.oidcRedirectURL(r))
+	if err != nil {
+		fmt.Printf("OIDC sign-in failed: %v\n", err)
+		h.redirectError(w, r, "/login", err, models.ErrSSOFailed)
+		return
+	}
+
+	// Bind the sign-in to this browser. The provider redirects back
+	// cross-site, so the cookie must be Lax rather than Strict.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   h.config.Security.SessionCookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes an OpenID Connect sign-in when the provider
+// redirects back with an authorization code
+func (h *Handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if !h.oidcService.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	// The state cookie is single use
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		Path:     "/auth/oidc",
+		Expires:  time.Unix(0, 0),
+		HttpOnly: true,
+		Secure:   h.config.Security.SessionCookieSecure,
+	})
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		fmt.Printf("OIDC provider refused sign-in: %s %s\n", providerErr, query.Get("error_description"))
+		h.redirectError(w, r, "/login", fmt.Errorf("%w: %s", models.ErrSSOFailed, providerErr), nil)
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		h.redirectError(w, r, "/login", models.ErrSignInMismatch, nil)
+		return
+	}
+
+	identity, err := h.oidcService.Finish(state, query.Get("code"))
+	if err != nil {
+		fmt.Printf("OIDC sign-in failed: %v\n", err)
+		h.redirectError(w, r, "/login", err, models.ErrSSOFailed)
+		return
+	}
+
+	user, err := h.userService.Provision(identity.Username, services.OIDCSource, identity.Subject, identity.Role)
+	if err != nil {
+		fmt.Printf("OIDC sign-in for %q (subject %s) refused: %v\n", identity.Username, identity.Subject, err)
+		h.redirectError(w, r, "/login", err, models.ErrSSOFailed)
+		return
+	}
+
+	if err := h.startWebSession(w, r, user); err != nil {
+		h.redirectError(w, r, "/login", err, models.ErrSSOFailed)
+		return
+	}
+
+	// Browsers withhold SameSite=Strict cookies on a redirect chain that
+	// started at the provider, so continue with a same-site navigation
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, `<!DOCTYPE html><html><head><meta http-equiv="refresh" content="0;url=/"></head><body><a href="/">Continue</a></body></html>`)
+}
+
+// oidcRedirectURL returns the callback URL registered with the provider
+func (h *Handler) oidcRedirectURL(r *http.Request) string {
+	if redirectURL := h.config.Security.OIDC.RedirectURL; redirectURL != "" {
+		return redirectURL
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + "/auth/oidc/callback"
+}
+
+// startWebSession signs a user in by starting a browser session and setting
+// its cookie. A session the browser already had is ended, so every sign-in
+// gets a fresh session ID.
+func (h *Handler) startWebSession(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	if cookie, err := r.Cookie(h.config.Security.SessionCookieName); err == nil {
+		if webSessionID, err := h.sessionService.ParseSessionCookie(cookie.Value); err == nil {
+			h.sessionService.DeleteWebSession(webSessionID)
+		}
+	}
+
+	webSession, err := h.sessionService.CreateWebSession(user, middleware.ClientIP(r), r.UserAgent())
+	if err != nil {
+		return err
+	}
+
+	// Set session cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.config.Security.SessionCookieName,
+		Value:    h.sessionService.SessionCookie(webSession),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   h.config.Security.SessionCookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return nil
+}
+
+// SignOut closes all SFTP connections of the browser session and signs the
+// user out
+func (h *Handler) SignOut(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	if cookie, err := r.Cookie(h.config.Security.SessionCookieName); err == nil {
+		if webSessionID, err := h.sessionService.ParseSessionCookie(cookie.Value); err == nil {
+			h.sessionService.DeleteWebSession(webSessionID)
+		}
+	}
+
+	// Clear session cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.config.Security.SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		HttpOnly: true,
+		Secure:   h.config.Security.SessionCookieSecure,
+	})
+
+	h.redirectSuccess(w, r, "/login", "Signed out")
+}
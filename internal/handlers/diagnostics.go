@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"sftp-gui/internal/models"
+)
+
+// Diagnostics renders the connection diagnostics page
+func (h *Handler) Diagnostics(w http.ResponseWriter, r *http.Request) {
+	data := models.PageData{
+		Theme:           h.config.UI.DefaultTheme,
+		ConnectionProxy: h.config.ConnectionProxyAllowed(),
+		User:            h.currentUser(r),
+		CSRFToken:       h.csrfToken(r),
+		Permissions:     h.permissions(r),
+	}
+	h.templates.ExecuteTemplate(w, "diagnostics.html", data)
+}
+
+// RunDiagnostics checks DNS, TCP and the SSH handshake for a host and
+// reports the server's banner, host key and authentication methods. It never
+// logs in. It only answers POST, so the CSRF check covers every probe.
+func (h *Handler) RunDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	host := strings.TrimSpace(r.FormValue("host"))
+	if host == "" {
+		h.writeJSONError(w, "Host is required", http.StatusBadRequest)
+		return
+	}
+
+	port := 22
+	if value := r.FormValue("port"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 65535 {
+			h.writeJSONError(w, "Invalid port", http.StatusBadRequest)
+			return
+		}
+		port = parsed
+	}
+
+	proxy := strings.TrimSpace(r.FormValue("proxy"))
+	if err := models.ValidateConnectionProxy(proxy); err != nil {
+		h.writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var appUser, role string
+	if user := h.currentUser(r); user != nil {
+		appUser, role = user.Username, user.Role
+	}
+
+	report := h.diagnosticsService.Run(host, port, strings.TrimSpace(r.FormValue("username")), proxy, appUser, role)
+
+	h.writeJSON(w, models.APIResponse{
+		Success: true,
+		Data:    report,
+	})
+}
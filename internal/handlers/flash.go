@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+
+	"sftp-gui/internal/middleware"
+	"sftp-gui/internal/models"
+	"sftp-gui/internal/services"
+)
+
+// redirectError redirects to url, which shows err once. Errors without a
+// message written for users are logged and shown as fallback.
+func (h *Handler) redirectError(w http.ResponseWriter, r *http.Request, url string, err, fallback error) {
+	h.flash(r, models.FlashError, services.UserError(err, fallback).Error())
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// redirectSuccess redirects to url, which shows message once
+func (h *Handler) redirectSuccess(w http.ResponseWriter, r *http.Request, url, message string) {
+	h.flash(r, models.FlashSuccess, message)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// flash queues a message for the next page the browser loads
+func (h *Handler) flash(r *http.Request, level, message string) {
+	if key, ok := middleware.GetFlashKeyFromContext(r.Context()); ok {
+		h.flashService.Add(key, level, message)
+	}
+}
+
+// takeFlashes returns the latest error and success messages queued for the
+// browser and forgets all of them
+func (h *Handler) takeFlashes(r *http.Request) (errorMsg, successMsg string) {
+	key, ok := middleware.GetFlashKeyFromContext(r.Context())
+	if !ok {
+		return "", ""
+	}
+
+	for _, flash := range h.flashService.Pop(key) {
+		switch flash.Level {
+		case models.FlashError:
+			errorMsg = flash.Message
+		case models.FlashSuccess:
+			successMsg = flash.Message
+		}
+	}
+	return errorMsg, successMsg
+}
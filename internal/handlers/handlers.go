@@ -2,13 +2,15 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"strconv"
-	"time"
+	"strings"
 
 	"sftp-gui/internal/config"
 	"sftp-gui/internal/middleware"
@@ -16,11 +18,22 @@ import (
 	"sftp-gui/internal/services"
 )
 
+// maxPrivateKeySize limits the size of pasted or uploaded private keys
+const maxPrivateKeySize = 64 << 10
+
 // Handler holds all handler dependencies
 type Handler struct {
 	sessionService      *services.SessionService
 	fileService         *services.FileService
 	loginHistoryService *services.LoginHistoryService
+	vaultService        *services.VaultService
+	sshConfigService    *services.SSHConfigService
+	diagnosticsService  *services.DiagnosticsService
+	userService         *services.UserService
+	oidcService         *services.OIDCService
+	ldapService         *services.LDAPService
+	loginLimiter        *services.LoginLimiter
+	flashService        *services.FlashService
 	config              *config.Config
 	templates           *template.Template
 }
@@ -30,6 +43,14 @@ func New(
 	sessionService *services.SessionService,
 	fileService *services.FileService,
 	loginHistoryService *services.LoginHistoryService,
+	vaultService *services.VaultService,
+	sshConfigService *services.SSHConfigService,
+	diagnosticsService *services.DiagnosticsService,
+	userService *services.UserService,
+	oidcService *services.OIDCService,
+	ldapService *services.LDAPService,
+	loginLimiter *services.LoginLimiter,
+	flashService *services.FlashService,
 	cfg *config.Config,
 	templates *template.Template,
 ) *Handler {
@@ -37,6 +58,14 @@ func New(
 		sessionService:      sessionService,
 		fileService:         fileService,
 		loginHistoryService: loginHistoryService,
+		vaultService:        vaultService,
+		sshConfigService:    sshConfigService,
+		diagnosticsService:  diagnosticsService,
+		userService:         userService,
+		oidcService:         oidcService,
+		ldapService:         ldapService,
+		loginLimiter:        loginLimiter,
+		flashService:        flashService,
 		config:              cfg,
 		templates:           templates,
 	}
@@ -44,39 +73,69 @@ func New(
 
 // Home renders the login page or file browser based on connection status
 func (h *Handler) Home(w http.ResponseWriter, r *http.Request) {
-	// Check if already logged in
-	var session *models.Session
-	if cookie, err := r.Cookie(h.config.Security.SessionCookieName); err == nil {
-		if sess, err := h.sessionService.GetSession(cookie.Value); err == nil {
-			session = sess
-		}
-	}
-
 	// Parse query parameters
 	path := r.URL.Query().Get("path")
 	view := r.URL.Query().Get("view")
 	showHidden := r.URL.Query().Get("show_hidden") == "true"
 	filter := r.URL.Query().Get("filter")
-	errorMsg := r.URL.Query().Get("error")
-	successMsg := r.URL.Query().Get("success")
+	errorMsg, successMsg := h.takeFlashes(r)
+	connectionID := r.URL.Query().Get("conn")
+	newConnection := r.URL.Query().Get("new") == "true"
+
+	// Check which connection to show, if any is open
+	webSessionID, _ := middleware.GetWebSessionIDFromContext(r.Context())
+	connections := h.sessionService.Connections(webSessionID)
+
+	// Tell the user about connections an administrator closed
+	if notices := h.sessionService.TakeNotices(webSessionID); len(notices) > 0 {
+		if errorMsg != "" {
+			notices = append(notices, errorMsg)
+		}
+		errorMsg = strings.Join(notices, ". ")
+	}
+
+	var session *models.Session
+	if len(connections) > 0 && !newConnection {
+		sess, err := h.sessionService.GetConnection(webSessionID, connectionID)
+		if err != nil && connectionID != "" {
+			// Fall back to another connection if the requested one is gone
+			if errorMsg == "" {
+				errorMsg = services.UserError(err, models.ErrConnectionNotFound).Error()
+			}
+			path = ""
+			sess, err = h.sessionService.GetConnection(webSessionID, "")
+		}
+		if err == nil {
+			session = sess
+		} else if errorMsg == "" {
+			errorMsg = services.UserError(err, models.ErrConnectionNotFound).Error()
+		}
+	}
 
 	if view == "" {
 		view = h.config.UI.DefaultView
 	}
 
-	// Get login history
-	loginHistory := h.loginHistoryService.GetHistory()
+	// Get login history and hosts imported from ssh_config
+	loginHistory := h.loginHistoryService.GetHistory(h.currentUser(r).Username)
+	importedHosts := h.sshConfigService.Hosts(h.currentUser(r).Username)
 
 	data := &models.PageData{
-		Connected:    session != nil,
-		Path:         path,
-		View:         view,
-		ShowHidden:   showHidden,
-		Filter:       filter,
-		LoginHistory: loginHistory,
-		Error:        errorMsg,
-		Success:      successMsg,
-		Theme:        h.config.UI.DefaultTheme,
+		Connected:       len(connections) > 0,
+		Path:            path,
+		View:            view,
+		ShowHidden:      showHidden,
+		Filter:          filter,
+		LoginHistory:    loginHistory,
+		ImportedHosts:   importedHosts,
+		ConnectionProxy: h.config.ConnectionProxyAllowed(),
+		Error:           errorMsg,
+		Success:         successMsg,
+		Theme:           h.config.UI.DefaultTheme,
+		Connections:     connections,
+		User:            h.currentUser(r),
+		CSRFToken:       h.csrfToken(r),
+		Permissions:     h.permissions(r),
 	}
 
 	if session != nil {
@@ -93,7 +152,7 @@ func (h *Handler) Home(w http.ResponseWriter, r *http.Request) {
 		// Get files
 		files, err := h.fileService.ListFiles(session.ID, path, showHidden, filter)
 		if err != nil {
-			data.Error = err.Error()
+			data.Error = services.UserError(err, models.ErrOperationFailed).Error()
 		} else {
 			data.Files = files
 		}
@@ -116,68 +175,466 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse form data
+	loginReq, err := loginRequestFromForm(r)
+	if err != nil {
+		h.redirectError(w, r, "/", err, models.ErrConnectionFailed)
+		return
+	}
+
+	// Hosts from the server's ssh_config may name an identity file. The
+	// server's key only ever goes to the host, port and user of that entry,
+	// whatever the form says.
+	if alias := r.FormValue("ssh_host"); alias != "" && loginReq.PrivateKey == "" {
+		host, key, err := h.sshConfigService.Identity(alias)
+		if err != nil {
+			h.redirectError(w, r, "/", err, models.ErrConnectionFailed)
+			return
+		}
+		if host != nil {
+			loginReq.Host = host.Host
+			loginReq.Port = host.Port
+			if host.Username != "" {
+				loginReq.Username = host.Username
+			}
+			loginReq.PrivateKey = key
+
+			// The entry alone can re-dial the connection after a restart
+			// unless the form added to it
+			if loginReq.Password == "" && loginReq.Passphrase == "" && len(loginReq.JumpHosts) == 0 && loginReq.Proxy == "" && loginReq.Options.IsDefault() {
+				loginReq.SSHHost = alias
+			}
+		}
+	}
+
+	h.connect(w, r, loginReq)
+}
+
+// ConfirmHostKey resumes a login once the user accepts or rejects the host
+// key of a server that is not yet in known_hosts
+func (h *Handler) ConfirmHostKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
 	if err := r.ParseForm(); err != nil {
-		http.Redirect(w, r, "/?error=Invalid form data", http.StatusFound)
+		h.redirectError(w, r, "/", models.ErrInvalidFormData, nil)
 		return
 	}
 
-	port, err := strconv.Atoi(r.FormValue("port"))
+	webSessionID, _ := middleware.GetWebSessionIDFromContext(r.Context())
+	loginReq, err := h.sessionService.ResumeLogin(webSessionID, r.FormValue("token"))
 	if err != nil {
-		port = 22 // Default SSH port
+		h.redirectError(w, r, "/", err, models.ErrConnectionFailed)
+		return
+	}
+
+	if r.FormValue("action") != "accept" {
+		h.redirectError(w, r, "/", models.ErrHostKeyRejected, nil)
+		return
+	}
+
+	loginReq.AcceptedHostKeys = append(loginReq.AcceptedHostKeys, r.FormValue("fingerprint"))
+	h.connect(w, r, loginReq)
+}
+
+// AnswerChallenge relays the user's answers to a keyboard-interactive
+// challenge and continues the pending SSH handshake
+func (h *Handler) AnswerChallenge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		h.redirectError(w, r, "/", models.ErrInvalidFormData, nil)
+		return
+	}
+
+	webSessionID, _ := middleware.GetWebSessionIDFromContext(r.Context())
+	flow, err := h.sessionService.GetLoginFlow(webSessionID, r.FormValue("flow_id"))
+	if err != nil {
+		h.redirectError(w, r, "/", err, models.ErrConnectionFailed)
+		return
+	}
+
+	if err := flow.Answer(r.Form["answer"]); err != nil {
+		h.redirectError(w, r, "/", err, models.ErrConnectionFailed)
+		return
 	}
 
-	loginReq := &models.LoginRequest{
-		Host:     r.FormValue("host"),
-		Port:     port,
-		Username: r.FormValue("username"),
-		Password: r.FormValue("password"),
+	h.advanceLogin(w, r, flow)
+}
+
+// connect starts the SSH handshake for a login request
+func (h *Handler) connect(w http.ResponseWriter, r *http.Request, loginReq *models.LoginRequest) {
+	if user := h.currentUser(r); user != nil {
+		loginReq.AppUser, loginReq.Role = user.Username, user.Role
+	}
+
+	if err := h.loginLimiter.Check(middleware.ClientIP(r), loginReq); err != nil {
+		h.redirectError(w, r, "/", err, models.ErrConnectionFailed)
+		return
 	}
 
-	// Create session
-	session, err := h.sessionService.CreateSession(loginReq)
+	webSessionID, _ := middleware.GetWebSessionIDFromContext(r.Context())
+	if err := h.sessionService.CheckConnectionLimit(webSessionID); err != nil {
+		h.redirectError(w, r, "/", err, models.ErrConnectionFailed)
+		return
+	}
+
+	flow, err := h.sessionService.BeginLogin(webSessionID, loginReq)
 	if err != nil {
+		h.redirectError(w, r, "/", err, models.ErrConnectionFailed)
+		return
+	}
+
+	h.advanceLogin(w, r, flow)
+}
+
+// advanceLogin waits for the next step of a login flow: it either renders a
+// challenge for the user to answer, asks the user to confirm an unknown host
+// key, or completes the login and sets the session cookie
+func (h *Handler) advanceLogin(w http.ResponseWriter, r *http.Request, flow *services.LoginFlow) {
+	challenge, session, err := flow.Wait()
+	if challenge != nil {
+		data := &models.PageData{
+			Theme:           h.config.UI.DefaultTheme,
+			Challenge:       challenge,
+			ConnectionProxy: h.config.ConnectionProxyAllowed(),
+			User:            h.currentUser(r),
+			CSRFToken:       h.csrfToken(r),
+			Permissions:     h.permissions(r),
+		}
+		h.templates.ExecuteTemplate(w, "index.html", data)
+		return
+	}
+
+	h.sessionService.EndLogin(flow)
+	loginReq := flow.Request
+
+	if err != nil {
+		var hostKeyErr *models.HostKeyError
+		if errors.As(err, &hostKeyErr) && !hostKeyErr.Changed && h.config.Security.HostKeyMode == config.HostKeyModePrompt {
+			h.promptHostKey(w, r, loginReq, hostKeyErr)
+			return
+		}
+
 		// Record failed login
-		h.loginHistoryService.AddLogin(loginReq.Host, loginReq.Port, loginReq.Username, false)
-		http.Redirect(w, r, fmt.Sprintf("/?error=%s", err.Error()), http.StatusFound)
+		h.loginHistoryService.AddLogin(loginReq, false)
+		if services.IsAuthFailure(err) {
+			h.loginLimiter.Fail(middleware.ClientIP(r), loginReq)
+		}
+		h.redirectError(w, r, "/", err, models.ErrConnectionFailed)
 		return
 	}
 
 	// Record successful login
-	h.loginHistoryService.AddLogin(loginReq.Host, loginReq.Port, loginReq.Username, true)
-
-	// Set session cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     h.config.Security.SessionCookieName,
-		Value:    session.ID,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   h.config.Security.SessionCookieSecure,
-		SameSite: http.SameSiteStrictMode,
-	})
+	h.loginHistoryService.AddLogin(loginReq, true)
+	h.loginLimiter.Succeed(loginReq)
+
+	// Attach the connection to the signed-in user's browser session
+	webSessionID, _ := middleware.GetWebSessionIDFromContext(r.Context())
+	if err := h.sessionService.AddConnection(webSessionID, session); err != nil {
+		h.redirectError(w, r, "/", err, models.ErrConnectionFailed)
+		return
+	}
 
-	http.Redirect(w, r, "/", http.StatusFound)
+	http.Redirect(w, r, "/?conn="+session.ID, http.StatusFound)
 }
 
-// Logout handles user logout
-func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
-	// Get session ID from cookie
-	if cookie, err := r.Cookie(h.config.Security.SessionCookieName); err == nil {
-		// Delete session
-		h.sessionService.DeleteSession(cookie.Value)
-	}
-
-	// Clear cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     h.config.Security.SessionCookieName,
-		Value:    "",
-		Path:     "/",
-		Expires:  time.Unix(0, 0),
-		HttpOnly: true,
-		Secure:   h.config.Security.SessionCookieSecure,
+// currentUser returns the signed-in application user of a request
+func (h *Handler) currentUser(r *http.Request) *models.User {
+	user, _ := middleware.GetUserFromContext(r.Context())
+	return user
+}
+
+// permissions returns what the role of the signed-in user allows, so pages
+// can hide actions the user may not perform
+func (h *Handler) permissions(r *http.Request) map[string]bool {
+	user := h.currentUser(r)
+	if user == nil {
+		return nil
+	}
+	return h.config.Permissions(user.Role)
+}
+
+// csrfToken returns the token that forms and scripts on a page must send
+// back with state-changing requests
+func (h *Handler) csrfToken(r *http.Request) string {
+	token, _ := middleware.GetCSRFTokenFromContext(r.Context())
+	return token
+}
+
+// promptHostKey renders the login page asking the user to confirm the
+// fingerprint of an unknown host key
+func (h *Handler) promptHostKey(w http.ResponseWriter, r *http.Request, loginReq *models.LoginRequest, hostKeyErr *models.HostKeyError) {
+	webSessionID, _ := middleware.GetWebSessionIDFromContext(r.Context())
+	token, err := h.sessionService.HoldLogin(webSessionID, loginReq)
+	if err != nil {
+		h.redirectError(w, r, "/", err, models.ErrConnectionFailed)
+		return
+	}
+
+	data := &models.PageData{
+		LoginHistory:    h.loginHistoryService.GetHistory(loginReq.AppUser),
+		ConnectionProxy: h.config.ConnectionProxyAllowed(),
+		Theme:           h.config.UI.DefaultTheme,
+		User:            h.currentUser(r),
+		CSRFToken:       h.csrfToken(r),
+		Permissions:     h.permissions(r),
+		HostKeyPrompt: &models.HostKeyPrompt{
+			Token:       token,
+			Host:        hostKeyErr.Host,
+			KeyType:     hostKeyErr.KeyType,
+			Fingerprint: hostKeyErr.Fingerprint,
+		},
+	}
+
+	h.templates.ExecuteTemplate(w, "index.html", data)
+}
+
+// loginRequestFromForm builds a login request from the connection form
+// fields, which the login and profile forms share
+func loginRequestFromForm(r *http.Request) (*models.LoginRequest, error) {
+	// Parse form data (multipart when a key file is uploaded)
+	if err := r.ParseMultipartForm(maxPrivateKeySize); err != nil && err != http.ErrNotMultipart {
+		return nil, models.ErrInvalidFormData
+	}
+	if r.MultipartForm != nil {
+		// Make sure uploaded key material never lingers in temp files
+		defer r.MultipartForm.RemoveAll()
+	}
+
+	port, err := strconv.Atoi(r.FormValue("port"))
+	if err != nil {
+		port = 22 // Default SSH port
+	}
+
+	privateKey, err := readPrivateKey(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.LoginRequest{
+		Name:                strings.TrimSpace(r.FormValue("connection_name")),
+		Host:                strings.TrimSpace(r.FormValue("host")),
+		Port:                port,
+		Username:            r.FormValue("username"),
+		Password:            r.FormValue("password"),
+		PrivateKey:          privateKey,
+		Passphrase:          r.FormValue("passphrase"),
+		JumpHosts:           parseJumpHosts(r),
+		Proxy:               strings.TrimSpace(r.FormValue("proxy")),
+		DefaultPath:         strings.TrimSpace(r.FormValue("default_path")),
+		Options:             parseConnectionOptions(r),
+		KeyboardInteractive: r.FormValue("keyboard_interactive") == "true",
+	}, nil
+}
+
+// parseConnectionOptions reads the advanced SSH and SFTP settings of the
+// connection form. Algorithm lists are comma or space separated; empty
+// fields keep the server defaults.
+func parseConnectionOptions(r *http.Request) models.ConnectionOptions {
+	list := func(name string) []string {
+		return strings.FieldsFunc(r.FormValue(name), func(c rune) bool {
+			return c == ',' || c == ' ' || c == '\n' || c == '\r' || c == '\t'
+		})
+	}
+	toggle := func(name string) *bool {
+		switch r.FormValue(name) {
+		case "on":
+			enabled := true
+			return &enabled
+		case "off":
+			enabled := false
+			return &enabled
+		}
+		return nil
+	}
+
+	maxPacket, _ := strconv.Atoi(r.FormValue("sftp_max_packet"))
+	concurrentRequests, _ := strconv.Atoi(r.FormValue("sftp_concurrent_requests"))
+
+	return models.ConnectionOptions{
+		Ciphers:                      list("ssh_ciphers"),
+		KeyExchanges:                 list("ssh_key_exchanges"),
+		MACs:                         list("ssh_macs"),
+		HostKeyAlgorithms:            list("ssh_host_key_algorithms"),
+		MaxPacket:                    maxPacket,
+		MaxConcurrentRequestsPerFile: concurrentRequests,
+		UseConcurrentWrites:          toggle("sftp_concurrent_writes"),
+		UseConcurrentReads:           toggle("sftp_concurrent_reads"),
+	}
+}
+
+// readPrivateKey returns the private key pasted into the login form or, if
+// none was pasted, the contents of the uploaded key file
+func readPrivateKey(r *http.Request) (string, error) {
+	if key := r.FormValue("private_key"); key != "" {
+		return key, nil
+	}
+
+	file, _, err := r.FormFile("private_key_file")
+	if err != nil {
+		// No key file uploaded
+		return "", nil
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxPrivateKeySize+1))
+	if err != nil {
+		return "", models.NewValidationError("failed to read private key file")
+	}
+	if len(data) > maxPrivateKeySize {
+		return "", models.NewValidationError("private key file is too large")
+	}
+
+	return string(data), nil
+}
+
+// parseJumpHosts reads the jump host rows of the login form. Every row
+// submits the same set of fields, so the values line up by index.
+func parseJumpHosts(r *http.Request) []models.JumpHost {
+	hosts := r.Form["jump_host"]
+	field := func(name string, i int) string {
+		if values := r.Form[name]; i < len(values) {
+			return values[i]
+		}
+		return ""
+	}
+
+	var jumpHosts []models.JumpHost
+	for i, host := range hosts {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+
+		port, err := strconv.Atoi(strings.TrimSpace(field("jump_port", i)))
+		if err != nil {
+			port = 22
+		}
+
+		jumpHosts = append(jumpHosts, models.JumpHost{
+			Host:       host,
+			Port:       port,
+			Username:   strings.TrimSpace(field("jump_username", i)),
+			Password:   field("jump_password", i),
+			PrivateKey: field("jump_private_key", i),
+			Passphrase: field("jump_passphrase", i),
+		})
+	}
+
+	return jumpHosts
+}
+
+// ImportSSHConfig imports the Host blocks of an uploaded OpenSSH client
+// config file as connections on the login page
+func (h *Handler) ImportSSHConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	if err := r.ParseMultipartForm(maxPrivateKeySize); err != nil && err != http.ErrNotMultipart {
+		h.redirectError(w, r, "/", models.ErrInvalidFormData, nil)
+		return
+	}
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
+	}
+
+	var source io.Reader = strings.NewReader(r.FormValue("ssh_config"))
+	if file, _, err := r.FormFile("ssh_config_file"); err == nil {
+		defer file.Close()
+		source = file
+	}
+
+	count, err := h.sshConfigService.Import(h.currentUser(r).Username, source)
+	if err != nil {
+		h.redirectError(w, r, "/", err, models.ErrInvalidFormData)
+		return
+	}
+
+	h.redirectSuccess(w, r, "/", fmt.Sprintf("Imported %d hosts from ssh_config", count))
+}
+
+// Disconnect closes the connection named by the "conn" parameter, or all
+// connections of the browser session when none is given. The user stays
+// signed in.
+func (h *Handler) Disconnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	webSessionID, _ := middleware.GetWebSessionIDFromContext(r.Context())
+
+	if r.FormValue("conn") == "" {
+		h.sessionService.DisconnectAll(webSessionID)
+		h.redirectSuccess(w, r, "/", "Disconnected from all servers")
+		return
+	}
+
+	session, _ := middleware.GetSessionFromContext(r.Context())
+	h.sessionService.DeleteConnection(webSessionID, session.ID)
+	h.redirectSuccess(w, r, "/", fmt.Sprintf("Disconnected from %s", session.Name))
+}
+
+// Notices returns the notices waiting for the browser session, e.g. that
+// an administrator closed a connection. Pages poll it, so it must be routed
+// through PassiveUserAuth.
+func (h *Handler) Notices(w http.ResponseWriter, r *http.Request) {
+	webSessionID, _ := middleware.GetWebSessionIDFromContext(r.Context())
+	notices := h.sessionService.TakeNotices(webSessionID)
+	if notices == nil {
+		notices = []string{}
+	}
+
+	h.writeJSON(w, models.APIResponse{
+		Success: true,
+		Data:    notices,
 	})
+}
+
+// SessionStatus reports when the browser session, or the connection given
+// by conn, expires from inactivity. Pages poll it, so it must be routed
+// through PassiveUserAuth.
+func (h *Handler) SessionStatus(w http.ResponseWriter, r *http.Request) {
+	webSessionID, _ := middleware.GetWebSessionIDFromContext(r.Context())
+	expiry, err := h.sessionService.SessionExpiry(webSessionID, r.URL.Query().Get("conn"))
+	if err != nil {
+		h.writeJSONError(w, err.Error(), http.StatusNotFound)
+		return
+	}
 
-	http.Redirect(w, r, "/?success=Logged out successfully", http.StatusFound)
+	h.writeJSON(w, models.APIResponse{
+		Success: true,
+		Data:    expiry,
+	})
+}
+
+// ExtendSession keeps the browser session and the connection given by conn
+// alive without a file operation
+func (h *Handler) ExtendSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	webSessionID, _ := middleware.GetWebSessionIDFromContext(r.Context())
+	expiry, err := h.sessionService.ExtendSession(webSessionID, r.FormValue("conn"))
+	if err != nil {
+		h.writeJSONError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	h.writeJSON(w, models.APIResponse{
+		Success: true,
+		Message: "Session extended",
+		Data:    expiry,
+	})
 }
 
 // Files renders the file browser
@@ -201,18 +658,24 @@ func (h *Handler) Files(w http.ResponseWriter, r *http.Request) {
 
 	showHidden := r.URL.Query().Get("show_hidden") == "true"
 	filter := r.URL.Query().Get("filter")
+	webSessionID, _ := middleware.GetWebSessionIDFromContext(r.Context())
+	connections := h.sessionService.Connections(webSessionID)
 
 	// Get files
 	files, err := h.fileService.ListFiles(session.ID, path, showHidden, filter)
 	if err != nil {
 		data := &models.PageData{
 			Connected:   true,
-			Error:       err.Error(),
+			Error:       services.UserError(err, models.ErrOperationFailed).Error(),
 			Path:        path,
 			View:        view,
 			ShowHidden:  showHidden,
 			Filter:      filter,
 			SessionInfo: session,
+			Connections: connections,
+			User:        h.currentUser(r),
+			CSRFToken:   h.csrfToken(r),
+			Permissions: h.permissions(r),
 			Theme:       h.config.UI.DefaultTheme,
 		}
 		h.templates.ExecuteTemplate(w, "browser.html", data)
@@ -234,6 +697,10 @@ func (h *Handler) Files(w http.ResponseWriter, r *http.Request) {
 		FilteredFiles:   len(files),
 		ShowBulkActions: h.config.UI.EnableBatchOps,
 		SessionInfo:     session,
+		Connections:     connections,
+		User:            h.currentUser(r),
+		CSRFToken:       h.csrfToken(r),
+		Permissions:     h.permissions(r),
 		Theme:           h.config.UI.DefaultTheme,
 	}
 
@@ -272,7 +739,7 @@ func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
 	// Check if path is a directory
 	stat, err := session.SFTPClient.Stat(filePath)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to access path: %v", err), http.StatusInternalServerError)
+		http.Error(w, services.UserError(err, models.ErrOperationFailed).Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -285,7 +752,7 @@ func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
 		// Download directory as ZIP
 		err := h.fileService.DownloadMultiple(sessionID, []string{filePath}, w)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, services.UserError(err, models.ErrOperationFailed).Error(), http.StatusInternalServerError)
 			return
 		}
 		return
@@ -294,7 +761,7 @@ func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
 	// For regular files, use the existing file download logic
 	file, fileInfo, err := h.fileService.GetFile(sessionID, filePath)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, services.UserError(err, models.ErrOperationFailed).Error(), http.StatusInternalServerError)
 		return
 	}
 	defer file.Close()
@@ -327,7 +794,7 @@ func (h *Handler) Preview(w http.ResponseWriter, r *http.Request) {
 
 	content, language, err := h.fileService.PreviewFile(sessionID, filePath, h.config.UI.MaxPreviewSize)
 	if err != nil {
-		h.writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		h.writeJSONError(w, services.UserError(err, models.ErrOperationFailed).Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -365,15 +832,116 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.fileService.DeleteFile(sessionID, filePath); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, services.UserError(err, models.ErrOperationFailed).Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Redirect back to the current directory
 	currentPath := r.FormValue("current_path")
 	view := r.FormValue("view")
-	redirectURL := fmt.Sprintf("/files?path=%s&view=%s&success=File deleted successfully", currentPath, view)
-	http.Redirect(w, r, redirectURL, http.StatusFound)
+	redirectURL := fmt.Sprintf("/files?path=%s&view=%s&conn=%s", url.QueryEscape(currentPath), url.QueryEscape(view), url.QueryEscape(sessionID))
+	h.redirectSuccess(w, r, redirectURL, "File deleted successfully")
+}
+
+// Rename renames or moves one file or directory
+func (h *Handler) Rename(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID, ok := middleware.GetSessionIDFromContext(r.Context())
+	if !ok {
+		h.writeJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	result := models.MoveResult{
+		Source:      r.FormValue("file"),
+		Destination: r.FormValue("destination"),
+	}
+	if result.Source == "" || result.Destination == "" {
+		h.writeJSONError(w, "File and destination required", http.StatusBadRequest)
+		return
+	}
+
+	err := h.fileService.RenameFile(sessionID, result.Source, result.Destination, r.FormValue("overwrite") == "true")
+	if err != nil {
+		status := http.StatusInternalServerError
+		var validationErr models.ValidationError
+		switch {
+		case errors.Is(err, models.ErrFileExists), errors.Is(err, models.ErrDirectoryExists):
+			status = http.StatusConflict
+			result.Conflict = true
+			result.Replaceable = errors.Is(err, models.ErrFileExists)
+		case errors.As(err, &validationErr):
+			status = http.StatusBadRequest
+		}
+		result.Error = services.UserError(err, models.ErrOperationFailed).Error()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(models.APIResponse{
+			Success: false,
+			Error:   result.Error,
+			Data:    result,
+		})
+		return
+	}
+
+	result.Success = true
+	h.writeJSON(w, models.APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Renamed %s to %s", filepath.Base(result.Source), result.Destination),
+		Data:    result,
+	})
+}
+
+// Move moves several files and directories into a directory. The response
+// lists the outcome for each; items whose destination exists are marked as
+// conflicts, and files among them can be sent again with overwrite set.
+func (h *Handler) Move(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID, ok := middleware.GetSessionIDFromContext(r.Context())
+	if !ok {
+		h.writeJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		h.writeJSONError(w, models.ErrInvalidFormData.Error(), http.StatusBadRequest)
+		return
+	}
+
+	files := r.Form["files"]
+	destination := r.FormValue("destination")
+	if len(files) == 0 || destination == "" {
+		h.writeJSONError(w, "Files and destination required", http.StatusBadRequest)
+		return
+	}
+
+	results, err := h.fileService.MoveFiles(sessionID, files, destination, r.FormValue("overwrite") == "true")
+	if err != nil {
+		h.writeJSONError(w, services.UserError(err, models.ErrOperationFailed).Error(), http.StatusBadRequest)
+		return
+	}
+
+	moved := 0
+	for _, result := range results {
+		if result.Success {
+			moved++
+		}
+	}
+
+	h.writeJSON(w, models.APIResponse{
+		Success: moved == len(results),
+		Message: fmt.Sprintf("Moved %d of %d items to %s", moved, len(results), destination),
+		Data:    results,
+	})
 }
 
 // DownloadMultiple creates a ZIP archive of multiple files
@@ -409,7 +977,7 @@ func (h *Handler) DownloadMultiple(w http.ResponseWriter, r *http.Request) {
 	// Use file service to create ZIP archive
 	err = h.fileService.DownloadMultiple(session.ID, filePaths, w)
 	if err != nil {
-		h.writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		h.writeJSONError(w, services.UserError(err, models.ErrOperationFailed).Error(), http.StatusInternalServerError)
 		return
 	}
 }
@@ -456,7 +1024,7 @@ func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
 	// Upload file
 	err = h.fileService.UploadFile(sessionID, destPath, file, overwrite)
 	if err != nil {
-		h.writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		h.writeJSONError(w, services.UserError(err, models.ErrOperationFailed).Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"sftp-gui/internal/middleware"
+	"sftp-gui/internal/models"
+)
+
+// Profiles returns the saved connection profiles, without secrets
+func (h *Handler) Profiles(w http.ResponseWriter, r *http.Request) {
+	webSessionID, _ := middleware.GetWebSessionIDFromContext(r.Context())
+	unlocked := h.vaultService.IsUnlocked(webSessionID)
+
+	data := map[string]interface{}{
+		"exists":   h.vaultService.Exists(h.currentUser(r).Username),
+		"unlocked": unlocked,
+		"profiles": []models.ConnectionProfile{},
+	}
+
+	if unlocked {
+		profiles, err := h.vaultService.List(webSessionID)
+		if err != nil {
+			h.writeProfileError(w, err)
+			return
+		}
+		data["profiles"] = profiles
+	}
+
+	h.writeJSON(w, models.APIResponse{
+		Success: true,
+		Data:    data,
+	})
+}
+
+// UnlockProfiles unlocks the profile vault, creating it on first use
+func (h *Handler) UnlockProfiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	webSessionID, _ := middleware.GetWebSessionIDFromContext(r.Context())
+	username := h.currentUser(r).Username
+	clientIP := middleware.ClientIP(r)
+
+	if err := h.loginLimiter.CheckVault(clientIP, username); err != nil {
+		h.writeJSONError(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	created := !h.vaultService.Exists(username)
+	if err := h.vaultService.Unlock(webSessionID, username, r.FormValue("passphrase")); err != nil {
+		if errors.Is(err, models.ErrVaultPassphrase) {
+			h.loginLimiter.FailVault(clientIP, username)
+		}
+		h.writeProfileError(w, err)
+		return
+	}
+	h.loginLimiter.SucceedVault(username)
+
+	message := "Vault unlocked"
+	if created {
+		message = "Vault created"
+	}
+
+	h.writeJSON(w, models.APIResponse{
+		Success: true,
+		Message: message,
+	})
+}
+
+// LockProfiles locks the profile vault
+func (h *Handler) LockProfiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	webSessionID, _ := middleware.GetWebSessionIDFromContext(r.Context())
+	h.vaultService.Lock(webSessionID)
+
+	h.writeJSON(w, models.APIResponse{
+		Success: true,
+		Message: "Vault locked",
+	})
+}
+
+// SaveProfile creates or updates a connection profile from the connection
+// form. Secret fields left blank keep the values already stored.
+func (h *Handler) SaveProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	loginReq, err := loginRequestFromForm(r)
+	if err != nil {
+		h.writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if loginReq.Proxy != "" && !h.config.ConnectionProxyAllowed() {
+		h.writeJSONError(w, models.ErrProxyNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
+
+	webSessionID, _ := middleware.GetWebSessionIDFromContext(r.Context())
+	profile, err := h.vaultService.Save(webSessionID, &models.ConnectionProfile{
+		ID:          r.FormValue("profile_id"),
+		Name:        strings.TrimSpace(r.FormValue("profile_name")),
+		Host:        loginReq.Host,
+		Port:        loginReq.Port,
+		Username:    loginReq.Username,
+		Password:    loginReq.Password,
+		PrivateKey:  loginReq.PrivateKey,
+		Passphrase:  loginReq.Passphrase,
+		DefaultPath: loginReq.DefaultPath,
+		JumpHosts:   loginReq.JumpHosts,
+		Proxy:       loginReq.Proxy,
+		Options:     loginReq.Options,
+	})
+	if err != nil {
+		h.writeProfileError(w, err)
+		return
+	}
+
+	h.writeJSON(w, models.APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Profile %s saved", profile.Name),
+		Data:    profile,
+	})
+}
+
+// DeleteProfile removes a connection profile
+func (h *Handler) DeleteProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	webSessionID, _ := middleware.GetWebSessionIDFromContext(r.Context())
+	if err := h.vaultService.Delete(webSessionID, r.FormValue("id")); err != nil {
+		h.writeProfileError(w, err)
+		return
+	}
+
+	h.writeJSON(w, models.APIResponse{
+		Success: true,
+		Message: "Profile deleted",
+	})
+}
+
+// ConnectProfile starts a login with the credentials of a saved profile
+func (h *Handler) ConnectProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	webSessionID, _ := middleware.GetWebSessionIDFromContext(r.Context())
+	profile, err := h.vaultService.Get(webSessionID, r.FormValue("id"))
+	if err != nil {
+		h.redirectError(w, r, "/", err, models.ErrProfileNotFound)
+		return
+	}
+
+	h.connect(w, r, profile.LoginRequest())
+}
+
+// writeProfileError writes a vault error with a matching status code
+func (h *Handler) writeProfileError(w http.ResponseWriter, err error) {
+	var validationErr models.ValidationError
+	var authErr models.AuthError
+
+	switch {
+	case errors.As(err, &validationErr):
+		h.writeJSONError(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, models.ErrVaultLocked):
+		h.writeJSONError(w, err.Error(), http.StatusLocked)
+	case errors.As(err, &authErr):
+		h.writeJSONError(w, err.Error(), http.StatusUnauthorized)
+	default:
+		h.writeJSONError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -2,7 +2,13 @@ package middleware
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"runtime/debug"
 	"strings"
@@ -18,25 +24,106 @@ import (
 type contextKey string
 
 const (
-	SessionIDKey contextKey = "session_id"
-	SessionKey   contextKey = "session"
+	// SessionIDKey and SessionKey hold the SFTP connection a request acts on
+	SessionIDKey    contextKey = "session_id"
+	SessionKey      contextKey = "session"
+	WebSessionIDKey contextKey = "web_session_id"
+	UserKey         contextKey = "user"
+	CSRFTokenKey    contextKey = "csrf_token"
+	FlashKey        contextKey = "flash_key"
+	// ClientIPKey holds the client address resolved by RealIP
+	ClientIPKey contextKey = "client_ip"
+)
+
+const (
+	// CSRFHeader carries the CSRF token of fetch() calls
+	CSRFHeader = "X-CSRF-Token"
+	// CSRFField carries the CSRF token of form submissions
+	CSRFField = "csrf_token"
+	// csrfCookieName holds the token of visitors who have not signed in
+	csrfCookieName = "sftp_csrf"
+	// flashCookieName identifies the browser whose flash messages to show
+	flashCookieName = "sftp_flash"
 )
 
 // Middleware holds middleware dependencies
 type Middleware struct {
 	sessionService *services.SessionService
+	userService    *services.UserService
+	flashService   *services.FlashService
 	config         *config.Config
+	trustedProxies []*net.IPNet
 }
 
 // New creates a new middleware instance
-func New(sessionService *services.SessionService, cfg *config.Config) *Middleware {
+func New(sessionService *services.SessionService, userService *services.UserService, flashService *services.FlashService, cfg *config.Config) *Middleware {
+	// The configuration was validated on load
+	trustedProxies, _ := cfg.Server.TrustedProxyNets()
+
 	return &Middleware{
 		sessionService: sessionService,
+		userService:    userService,
+		flashService:   flashService,
 		config:         cfg,
+		trustedProxies: trustedProxies,
+	}
+}
+
+// RealIP resolves the address of the client once per request, so lockouts,
+// rate limits and session binding all see the same address
+func (m *Middleware) RealIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), ClientIPKey, m.clientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// clientIP returns the address of the peer, or the client named by the
+// forwarding headers when the peer is a trusted proxy. X-Forwarded-For is
+// read from the right, skipping trusted proxies, since clients can put
+// anything at its start.
+func (m *Middleware) clientIP(r *http.Request) string {
+	peer := remoteIP(r)
+	if !m.trustedProxy(peer) {
+		return peer
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			peer = hop
+			if !m.trustedProxy(hop) {
+				break
+			}
+		}
+		return peer
+	}
+
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(xri) != nil {
+		return xri
 	}
+	return peer
 }
 
-// Logger logs HTTP requests
+// trustedProxy reports whether an address belongs to a trusted proxy
+func (m *Middleware) trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range m.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Logger logs HTTP requests with the client address resolved by RealIP
 func (m *Middleware) Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -52,7 +139,7 @@ func (m *Middleware) Logger(next http.Handler) http.Handler {
 		duration := time.Since(start)
 		log.Printf("[%s] %s %s %d %v %s",
 			r.Method,
-			r.RemoteAddr,
+			ClientIP(r),
 			r.URL.Path,
 			lrw.statusCode,
 			duration,
@@ -111,46 +198,257 @@ func (m *Middleware) CORS(next http.Handler) http.Handler {
 	})
 }
 
-// SessionAuth validates session authentication
-func (m *Middleware) SessionAuth(next http.Handler) http.Handler {
+// UserAuth requires a signed-in application user. Pages redirect to the
+// sign-in form; API and non-GET requests get a JSON 401.
+func (m *Middleware) UserAuth(next http.Handler) http.Handler {
+	return m.userAuth(next, m.sessionService.GetWebSession)
+}
+
+// PassiveUserAuth is UserAuth for status requests that pages send on a
+// timer. They do not count as activity, so an idle session still expires.
+func (m *Middleware) PassiveUserAuth(next http.Handler) http.Handler {
+	return m.userAuth(next, m.sessionService.PeekWebSession)
+}
+
+// userAuth resolves the browser session with lookup and requires a
+// signed-in application user
+func (m *Middleware) userAuth(next http.Handler, lookup func(cookieValue, clientIP, userAgent string) (*models.WebSession, error)) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get session ID from cookie
 		cookie, err := r.Cookie(m.config.Security.SessionCookieName)
+		if err != nil || cookie.Value == "" {
+			m.unauthenticated(w, r)
+			return
+		}
+
+		webSession, err := lookup(cookie.Value, ClientIP(r), r.UserAgent())
 		if err != nil {
-			http.Redirect(w, r, "/", http.StatusFound)
+			m.clearSessionCookie(w)
+			m.unauthenticated(w, r)
 			return
 		}
 
-		sessionID := cookie.Value
-		if sessionID == "" {
-			http.Redirect(w, r, "/", http.StatusFound)
+		// Accounts may be disabled or deleted while signed in
+		user, err := m.userService.Get(webSession.Username)
+		if err != nil || user.Disabled {
+			m.sessionService.DeleteWebSession(webSession.ID)
+			m.clearSessionCookie(w)
+			m.unauthenticated(w, r)
+			return
+		}
+
+		// A new role gets a new session ID, so a cookie captured before
+		// the change does not carry the new privileges
+		if user.Role != webSession.Role {
+			if webSession, err = m.sessionService.RotateWebSession(webSession.ID, user.Role); err != nil {
+				m.clearSessionCookie(w)
+				m.unauthenticated(w, r)
+				return
+			}
+			m.setSessionCookie(w, m.sessionService.SessionCookie(webSession))
+		}
+
+		ctx := context.WithValue(r.Context(), WebSessionIDKey, webSession.ID)
+		ctx = context.WithValue(ctx, UserKey, user)
+		ctx = context.WithValue(ctx, CSRFTokenKey, webSession.CSRFToken)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Require restricts a handler to users whose role grants permission, as
+// configured in the policy. Refused requests get a JSON 403 naming the
+// missing permission. It must run after UserAuth.
+func (m *Middleware) Require(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := GetUserFromContext(r.Context())
+			if !ok {
+				m.unauthenticated(w, r)
+				return
+			}
+
+			if !m.config.Allows(user.Role, permission) {
+				log.Printf("Denied %s %s to %s (role %s lacks %s)", r.Method, r.URL.Path, user.Username, user.Role, permission)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(models.APIResponse{
+					Success: false,
+					Error:   fmt.Sprintf("%s: your role %s does not allow %s", models.ErrPermissionDenied, user.Role, permission),
+					Data: models.PermissionDenied{
+						Permission: permission,
+						Role:       user.Role,
+					},
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CSRF requires a synchronizer token on every request that is not GET, HEAD
+// or OPTIONS. Signed-in users have one token per browser session; before
+// sign-in a random token is kept in a cookie and must be echoed back. Forms
+// send the token in the csrf_token field, scripts in the X-CSRF-Token
+// header. Requests without a valid token get a JSON 403. It must run after
+// UserAuth where there is one.
+func (m *Middleware) CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.config.Security.CSRFEnabled {
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Validate session
-		session, err := m.sessionService.GetSession(sessionID)
+		token, ok := GetCSRFTokenFromContext(r.Context())
+		if !ok {
+			var err error
+			if token, err = m.visitorCSRFToken(w, r); err != nil {
+				writeJSONError(w, "failed to issue CSRF token", http.StatusInternalServerError)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), CSRFTokenKey, token))
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		submitted := r.Header.Get(CSRFHeader)
+		if submitted == "" {
+			submitted = r.FormValue(CSRFField)
+		}
+		if subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+			log.Printf("Rejected %s %s from %s: missing or invalid CSRF token", r.Method, r.URL.Path, ClientIP(r))
+			writeJSONError(w, "missing or invalid CSRF token, reload the page and try again", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// visitorCSRFToken returns the CSRF token from the cookie of a visitor who
+// has not signed in, setting a new one when there is none
+func (m *Middleware) visitorCSRFToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(csrfCookieName); err == nil && len(cookie.Value) == 64 {
+		return cookie.Value, nil
+	}
+
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(bytes)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   m.config.Security.SessionCookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token, nil
+}
+
+// Flash gives every browser a random flash cookie and stores its value in
+// the request context, keying the messages shown on the next page it loads
+func (m *Middleware) Flash(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := ""
+		if cookie, err := r.Cookie(flashCookieName); err == nil && len(cookie.Value) == 32 {
+			key = cookie.Value
+		} else {
+			bytes := make([]byte, 16)
+			if _, err := rand.Read(bytes); err == nil {
+				key = hex.EncodeToString(bytes)
+				http.SetCookie(w, &http.Cookie{
+					Name:     flashCookieName,
+					Value:    key,
+					Path:     "/",
+					HttpOnly: true,
+					Secure:   m.config.Security.SessionCookieSecure,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), FlashKey, key)))
+	})
+}
+
+// SessionAuth resolves the SFTP connection named by the "conn" parameter,
+// defaulting to the most recently used one. It must run after UserAuth.
+func (m *Middleware) SessionAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		webSessionID, ok := GetWebSessionIDFromContext(r.Context())
+		if !ok {
+			m.unauthenticated(w, r)
+			return
+		}
+
+		// A connection that expired or was closed leaves the others usable
+		session, err := m.sessionService.GetConnection(webSessionID, r.FormValue("conn"))
 		if err != nil {
-			// Clear invalid cookie
-			http.SetCookie(w, &http.Cookie{
-				Name:     m.config.Security.SessionCookieName,
-				Value:    "",
-				Path:     "/",
-				Expires:  time.Unix(0, 0),
-				HttpOnly: true,
-				Secure:   m.config.Security.SessionCookieSecure,
-			})
+			message := services.UserError(err, models.ErrConnectionLost).Error()
+			// fetch() calls from the browser page expect the JSON error shape
+			if wantsJSON(r) {
+				writeJSONError(w, message, http.StatusNotFound)
+				return
+			}
+			if key, ok := GetFlashKeyFromContext(r.Context()); ok {
+				m.flashService.Add(key, models.FlashError, message)
+			}
 			http.Redirect(w, r, "/", http.StatusFound)
 			return
 		}
 
 		// Add session to context
-		ctx := context.WithValue(r.Context(), SessionIDKey, sessionID)
+		ctx := context.WithValue(r.Context(), SessionIDKey, session.ID)
 		ctx = context.WithValue(ctx, SessionKey, session)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// unauthenticated sends a request without a valid sign-in to the sign-in
+// form, or answers with a JSON 401 for API calls
+func (m *Middleware) unauthenticated(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		writeJSONError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+// setSessionCookie sets the session cookie, e.g. after the session ID was
+// rotated
+func (m *Middleware) setSessionCookie(w http.ResponseWriter, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.config.Security.SessionCookieName,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   m.config.Security.SessionCookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// clearSessionCookie removes an invalid session cookie
+func (m *Middleware) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.config.Security.SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		HttpOnly: true,
+		Secure:   m.config.Security.SessionCookieSecure,
+	})
+}
+
 // SecurityHeaders adds security headers
 func (m *Middleware) SecurityHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -187,7 +485,7 @@ func (m *Middleware) RateLimit(next http.Handler) http.Handler {
 	var mutex sync.RWMutex
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		clientIP := getClientIP(r)
+		clientIP := ClientIP(r)
 		now := time.Now()
 		windowSize := time.Minute
 		maxRequests := 100 // requests per minute
@@ -226,6 +524,32 @@ func GetSessionFromContext(ctx context.Context) (*models.Session, bool) {
 	return session, ok
 }
 
+// GetUserFromContext extracts the signed-in application user from request
+// context
+func GetUserFromContext(ctx context.Context) (*models.User, bool) {
+	user, ok := ctx.Value(UserKey).(*models.User)
+	return user, ok
+}
+
+// GetCSRFTokenFromContext retrieves the CSRF token pages must send back
+func GetCSRFTokenFromContext(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(CSRFTokenKey).(string)
+	return token, ok
+}
+
+// GetFlashKeyFromContext retrieves the key of the browser's flash messages
+func GetFlashKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(FlashKey).(string)
+	return key, ok && key != ""
+}
+
+// GetWebSessionIDFromContext extracts the browser session ID from request
+// context
+func GetWebSessionIDFromContext(ctx context.Context) (string, bool) {
+	webSessionID, ok := ctx.Value(WebSessionIDKey).(string)
+	return webSessionID, ok
+}
+
 // GetSessionIDFromContext extracts session ID from request context
 func GetSessionIDFromContext(ctx context.Context) (string, bool) {
 	sessionID, ok := ctx.Value(SessionIDKey).(string)
@@ -243,24 +567,50 @@ func (lrw *loggingResponseWriter) WriteHeader(code int) {
 	lrw.ResponseWriter.WriteHeader(code)
 }
 
-// getClientIP extracts the client IP address from the request
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// Take the first IP in the list
-		ips := strings.Split(xff, ",")
-		return strings.TrimSpace(ips[0])
+// wantsJSON reports whether a request comes from script rather than page
+// navigation, so errors should be answered with JSON
+func wantsJSON(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/api/") ||
+		strings.Contains(r.Header.Get("Accept"), "application/json") ||
+		r.Header.Get("X-Requested-With") != "" {
+		return true
 	}
+	switch r.Header.Get("Sec-Fetch-Mode") {
+	case "navigate":
+		// Form submissions are navigations too
+		return false
+	case "cors", "same-origin":
+		// fetch() calls from the pages
+		return true
+	}
+	return r.Method != http.MethodGet
+}
 
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
+// writeJSONError writes a JSON error response
+func writeJSONError(w http.ResponseWriter, message string, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(models.APIResponse{
+		Success: false,
+		Error:   message,
+	})
+}
+
+// ClientIP returns the client address resolved by RealIP, or the address
+// of the peer for requests that did not pass through it. Forwarding headers
+// are never read here.
+func ClientIP(r *http.Request) string {
+	if clientIP, ok := r.Context().Value(ClientIPKey).(string); ok {
+		return clientIP
 	}
+	return remoteIP(r)
+}
 
-	// Fall back to RemoteAddr
-	ip := r.RemoteAddr
-	if colon := strings.LastIndex(ip, ":"); colon != -1 {
-		ip = ip[:colon]
+// remoteIP returns the address of the peer the request came from
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
-	return ip
+	return host
 }
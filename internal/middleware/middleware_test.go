@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"sftp-gui/internal/config"
+)
+
+func TestClientIP(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Server.TrustedProxies = []string{"10.0.0.0/8", "2001:db8::1"}
+	m := New(nil, nil, nil, cfg)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		want       string
+	}{
+		{name: "direct client", remoteAddr: "192.0.2.10:51000", want: "192.0.2.10"},
+		{name: "headers from an untrusted peer are ignored", remoteAddr: "192.0.2.10:51000", forwarded: "203.0.113.5", realIP: "203.0.113.6", want: "192.0.2.10"},
+		{name: "client behind a trusted proxy", remoteAddr: "10.0.0.2:51000", forwarded: "203.0.113.5", want: "203.0.113.5"},
+		{name: "trusted IPv6 proxy", remoteAddr: "[2001:db8::1]:51000", forwarded: "203.0.113.5", want: "203.0.113.5"},
+		{name: "chain of trusted proxies", remoteAddr: "10.0.0.2:51000", forwarded: "203.0.113.5, 10.1.1.1, 10.2.2.2", want: "203.0.113.5"},
+		{name: "spoofed start of the chain is skipped", remoteAddr: "10.0.0.2:51000", forwarded: "198.51.100.1, 203.0.113.5", want: "203.0.113.5"},
+		{name: "garbage stops at the last valid hop", remoteAddr: "10.0.0.2:51000", forwarded: "not-an-ip, 203.0.113.5", want: "203.0.113.5"},
+		{name: "only trusted proxies forwarded", remoteAddr: "10.0.0.2:51000", forwarded: "10.1.1.1", want: "10.1.1.1"},
+		{name: "invalid last hop keeps the peer", remoteAddr: "10.0.0.2:51000", forwarded: "203.0.113.5, bogus", want: "10.0.0.2"},
+		{name: "X-Real-IP from a trusted proxy", remoteAddr: "10.0.0.2:51000", realIP: "203.0.113.6", want: "203.0.113.6"},
+		{name: "invalid X-Real-IP keeps the peer", remoteAddr: "10.0.0.2:51000", realIP: "bogus", want: "10.0.0.2"},
+		{name: "X-Forwarded-For wins over X-Real-IP", remoteAddr: "10.0.0.2:51000", forwarded: "203.0.113.5", realIP: "203.0.113.6", want: "203.0.113.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			var got string
+			m.RealIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ClientIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPWithoutRealIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.2:51000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.5")
+
+	if got := ClientIP(req); got != "10.0.0.2" {
+		t.Errorf("ClientIP = %q, want the peer address", got)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	const sessionToken = "4f2a6c0e8b1d3f5a7c9e0b2d4f6a8c0e1b3d5f7a9c0e2b4d6f8a0c2e4b6d8f0a"
+	const visitorToken = "0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f9"
+
+	tests := []struct {
+		name     string
+		disabled bool
+		method   string
+		// session is the token of a signed-in browser session; visitors
+		// without one carry cookie instead
+		session string
+		cookie  string
+		header  string
+		field   string
+		want    int
+	}{
+		{name: "GET needs no token", method: http.MethodGet, session: sessionToken, want: http.StatusOK},
+		{name: "HEAD needs no token", method: http.MethodHead, session: sessionToken, want: http.StatusOK},
+		{name: "POST without a token", method: http.MethodPost, session: sessionToken, want: http.StatusForbidden},
+		{name: "POST with the header", method: http.MethodPost, session: sessionToken, header: sessionToken, want: http.StatusOK},
+		{name: "POST with the form field", method: http.MethodPost, session: sessionToken, field: sessionToken, want: http.StatusOK},
+		{name: "POST with a wrong token", method: http.MethodPost, session: sessionToken, header: visitorToken, want: http.StatusForbidden},
+		{name: "POST with a truncated token", method: http.MethodPost, session: sessionToken, header: sessionToken[:32], want: http.StatusForbidden},
+		{name: "DELETE is checked too", method: http.MethodDelete, session: sessionToken, want: http.StatusForbidden},
+		{name: "visitor with the cookie token", method: http.MethodPost, cookie: visitorToken, field: visitorToken, want: http.StatusOK},
+		{name: "visitor without a token", method: http.MethodPost, cookie: visitorToken, want: http.StatusForbidden},
+		{name: "visitor without a cookie", method: http.MethodPost, field: visitorToken, want: http.StatusForbidden},
+		{name: "visitor token is not a session token", method: http.MethodPost, session: sessionToken, cookie: visitorToken, field: visitorToken, want: http.StatusForbidden},
+		{name: "disabled", disabled: true, method: http.MethodPost, session: sessionToken, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Security.CSRFEnabled = !tt.disabled
+			m := New(nil, nil, nil, cfg)
+
+			form := url.Values{}
+			if tt.field != "" {
+				form.Set(CSRFField, tt.field)
+			}
+			req := httptest.NewRequest(tt.method, "/connect", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.header != "" {
+				req.Header.Set(CSRFHeader, tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.cookie})
+			}
+			if tt.session != "" {
+				req = req.WithContext(context.WithValue(req.Context(), CSRFTokenKey, tt.session))
+			}
+
+			recorder := httptest.NewRecorder()
+			m.CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})).ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.want {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFIssuesVisitorToken(t *testing.T) {
+	m := New(nil, nil, nil, config.DefaultConfig())
+
+	var token string
+	recorder := httptest.NewRecorder()
+	m.CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ = GetCSRFTokenFromContext(r.Context())
+	})).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/login", nil))
+
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookieName {
+		t.Fatalf("cookies = %v, want a %s cookie", cookies, csrfCookieName)
+	}
+	if len(token) != 64 || cookies[0].Value != token {
+		t.Errorf("token = %q, cookie = %q; want the same 64 hex characters", token, cookies[0].Value)
+	}
+	if !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Errorf("cookie is not HttpOnly and SameSite=Strict: %+v", cookies[0])
+	}
+}
@@ -1,7 +1,12 @@
 package models
 
 import (
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -11,6 +16,7 @@ import (
 // Session represents an active SFTP session
 type Session struct {
 	ID         string       `json:"id"`
+	Name       string       `json:"name"`
 	SSHClient  *ssh.Client  `json:"-"`
 	SFTPClient *sftp.Client `json:"-"`
 	CreatedAt  time.Time    `json:"created_at"`
@@ -19,16 +25,149 @@ type Session struct {
 	Username   string       `json:"username"`
 	Host       string       `json:"host"`
 	Port       int          `json:"port"`
+	AuthMethod string       `json:"auth_method"`
 	IsActive   bool         `json:"is_active"`
+
+	// JumpHosts lists the bastions the connection is tunnelled through
+	JumpHosts   []string      `json:"jump_hosts,omitempty"`
+	JumpClients []*ssh.Client `json:"-"`
+	// Proxy is the outbound proxy used, without credentials
+	Proxy string `json:"proxy,omitempty"`
+
+	// Algorithms were negotiated with the target server
+	Algorithms *NegotiatedAlgorithms `json:"algorithms,omitempty"`
+
+	// Credentials are held in memory only, to re-dial a dropped connection
+	Credentials *LoginRequest `json:"-"`
+	// Reconnects counts how often the connection was re-established
+	Reconnects int `json:"reconnects"`
+
+	// WebSessionID is the browser login that owns the connection and
+	// AppUser the application account signed in to it
+	WebSessionID string `json:"-"`
+	AppUser      string `json:"app_user"`
+
+	// ProfileID and SSHHost name the vault profile or server ssh_config
+	// entry the connection was opened with, if any. A connection restored
+	// after a restart is re-dialed from them.
+	ProfileID string `json:"-"`
+	SSHHost   string `json:"-"`
+
+	// Restored is set for connections restored from the session store
+	// after a restart until they are re-dialed. Those opened with other
+	// credentials need a fresh login.
+	Restored bool `json:"restored,omitempty"`
+
+	broken int32
+	// transferred counts the file data moved over the connection and
+	// operation describes the file operation in progress
+	transferred int64
+	operation   atomic.Pointer[string]
+}
+
+// WebSession is an application user's browser login, identified by the
+// session cookie. It owns the user's SFTP connections, which are tracked and
+// expire independently.
+type WebSession struct {
+	ID            string    `json:"id"`
+	Username      string    `json:"username"`
+	CreatedAt     time.Time `json:"created_at"`
+	LastAccess    time.Time `json:"last_access"`
+	ConnectionIDs []string  `json:"connection_ids"`
+
+	// Role is the user's role when the session ID was issued; the ID is
+	// rotated when it changes
+	Role string `json:"role"`
+	// ClientIP and UserAgent identify the client that signed in, which the
+	// session may be bound to
+	ClientIP  string `json:"client_ip"`
+	UserAgent string `json:"user_agent"`
+
+	// CSRFToken must accompany every state-changing request of the session
+	CSRFToken string `json:"-"`
+	// Notices wait to be shown to the user, e.g. that an administrator
+	// closed one of the connections
+	Notices []string `json:"-"`
+}
+
+// User is an application account. Signing in as a user is required before
+// any SFTP connection can be opened. The role decides which file operations
+// the user may perform.
+type User struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	Role         string    `json:"role"`
+	Disabled     bool      `json:"disabled"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	LastLogin    time.Time `json:"last_login"`
+
+	// Source is empty for local accounts and names the identity provider,
+	// e.g. "oidc", for accounts created by single sign-on
+	Source string `json:"source,omitempty"`
+	// Subject is the provider's permanent ID of an external account, for
+	// OIDC its issuer and sub claim. Later sign-ins must present the same
+	// subject, so a username that is reassigned at the provider does not
+	// take over the account.
+	Subject string `json:"subject,omitempty"`
 }
 
-// LoginHistory represents a login history entry
+// LoginHistory represents a login history entry. Each application user
+// has their own history.
 type LoginHistory struct {
-	Host     string    `json:"host"`
-	Port     int       `json:"port"`
-	Username string    `json:"username"`
-	LastUsed time.Time `json:"last_used"`
-	Success  bool      `json:"success"`
+	AppUser   string    `json:"app_user"`
+	Host      string    `json:"host"`
+	Port      int       `json:"port"`
+	Username  string    `json:"username"`
+	LastUsed  time.Time `json:"last_used"`
+	Success   bool      `json:"success"`
+	JumpHosts []string  `json:"jump_hosts,omitempty"`
+}
+
+// SSHConfigHost is a connection imported from a Host block of an OpenSSH
+// client config file
+type SSHConfigHost struct {
+	Alias        string   `json:"alias"`
+	Host         string   `json:"host"`
+	Port         int      `json:"port"`
+	Username     string   `json:"username,omitempty"`
+	IdentityFile string   `json:"identity_file,omitempty"`
+	JumpHosts    []string `json:"jump_hosts,omitempty"`
+	// Configured is set for hosts from the server's own config file, whose
+	// identity files can be read on the server
+	Configured bool `json:"configured"`
+}
+
+// ConnectionProfile is a named, saved connection kept in the encrypted vault
+type ConnectionProfile struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Host        string            `json:"host"`
+	Port        int               `json:"port"`
+	Username    string            `json:"username"`
+	AuthMethod  string            `json:"auth_method"`
+	Password    string            `json:"password,omitempty"`
+	PrivateKey  string            `json:"private_key,omitempty"`
+	Passphrase  string            `json:"passphrase,omitempty"`
+	DefaultPath string            `json:"default_path,omitempty"`
+	JumpHosts   []JumpHost        `json:"jump_hosts,omitempty"`
+	Proxy       string            `json:"proxy,omitempty"`
+	Options     ConnectionOptions `json:"options"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// MoveResult is the outcome of renaming or moving one file or directory
+type MoveResult struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Success     bool   `json:"success"`
+	// Conflict is set when the destination exists and was not replaced
+	Conflict bool `json:"conflict,omitempty"`
+	// Replaceable is set on conflicts that overwrite resolves; directories
+	// are never replaced
+	Replaceable bool   `json:"replaceable,omitempty"`
+	Error       string `json:"error,omitempty"`
 }
 
 // FileInfo represents file information for display
@@ -43,21 +182,102 @@ type FileInfo struct {
 
 // PageData represents data passed to templates
 type PageData struct {
-	Connected       bool           `json:"connected"`
-	Error           string         `json:"error"`
-	Success         string         `json:"success"`
-	Path            string         `json:"path"`
-	Files           []FileInfo     `json:"files"`
-	Breadcrumbs     []Breadcrumb   `json:"breadcrumbs"`
-	View            string         `json:"view"`
-	ShowHidden      bool           `json:"show_hidden"`
-	Filter          string         `json:"filter"`
-	TotalFiles      int            `json:"total_files"`
-	FilteredFiles   int            `json:"filtered_files"`
-	ShowBulkActions bool           `json:"show_bulk_actions"`
-	LoginHistory    []LoginHistory `json:"login_history"`
-	Theme           string         `json:"theme"`
-	SessionInfo     *Session       `json:"session_info"`
+	Connected       bool            `json:"connected"`
+	Error           string          `json:"error"`
+	Success         string          `json:"success"`
+	Path            string          `json:"path"`
+	Files           []FileInfo      `json:"files"`
+	Breadcrumbs     []Breadcrumb    `json:"breadcrumbs"`
+	View            string          `json:"view"`
+	ShowHidden      bool            `json:"show_hidden"`
+	Filter          string          `json:"filter"`
+	TotalFiles      int             `json:"total_files"`
+	FilteredFiles   int             `json:"filtered_files"`
+	ShowBulkActions bool            `json:"show_bulk_actions"`
+	LoginHistory    []LoginHistory  `json:"login_history"`
+	ImportedHosts   []SSHConfigHost `json:"imported_hosts,omitempty"`
+	ConnectionProxy bool            `json:"-"`
+	Theme           string          `json:"theme"`
+	SessionInfo     *Session        `json:"session_info"`
+	User            *User           `json:"user,omitempty"`
+	CSRFToken       string          `json:"-"`
+	Permissions     map[string]bool `json:"permissions,omitempty"`
+	Roles           []string        `json:"roles,omitempty"`
+	DefaultRole     string          `json:"default_role,omitempty"`
+	SingleSignOn    string          `json:"single_sign_on,omitempty"`
+	Connections     []*Session      `json:"connections,omitempty"`
+	HostKeyPrompt   *HostKeyPrompt  `json:"host_key_prompt,omitempty"`
+	Challenge       *LoginChallenge `json:"challenge,omitempty"`
+}
+
+// Flash is a message shown once on the next page a browser loads
+type Flash struct {
+	// Level is "error" or "success"
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+	Created time.Time `json:"created"`
+}
+
+// Flash levels
+const (
+	FlashError   = "error"
+	FlashSuccess = "success"
+)
+
+// ActiveSession describes an open SFTP connection on the admin dashboard
+type ActiveSession struct {
+	ID               string    `json:"id"`
+	Name             string    `json:"name"`
+	AppUser          string    `json:"app_user"`
+	ClientIP         string    `json:"client_ip"`
+	Host             string    `json:"host"`
+	Port             int       `json:"port"`
+	Username         string    `json:"username"`
+	JumpHosts        []string  `json:"jump_hosts,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	LastAccess       time.Time `json:"last_access"`
+	BytesTransferred int64     `json:"bytes_transferred"`
+	Operation        string    `json:"operation,omitempty"`
+	Reconnects       int       `json:"reconnects"`
+}
+
+// SessionOverview is the admin dashboard's view of the open connections
+type SessionOverview struct {
+	Stats    SessionStats    `json:"stats"`
+	Sessions []ActiveSession `json:"sessions"`
+}
+
+// SessionExpiry tells a page when its browser session or connection ends
+// unless it is used
+type SessionExpiry struct {
+	// ExpiresAt is the earliest of the idle expiry of the browser session,
+	// that of the connection and the end of the sign-in's lifetime
+	ExpiresAt        time.Time `json:"expires_at"`
+	RemainingSeconds int       `json:"remaining_seconds"`
+	// IdleTimeoutSeconds is how long sessions last without activity
+	IdleTimeoutSeconds int `json:"idle_timeout_seconds"`
+	// SignInExpiresAt is when the user must sign in again however active
+	SignInExpiresAt time.Time `json:"sign_in_expires_at"`
+	// Extendable is false once the sign-in's lifetime ends first, which
+	// activity cannot postpone
+	Extendable bool `json:"extendable"`
+}
+
+// PermissionDenied details a request refused by the role policy
+type PermissionDenied struct {
+	Permission string `json:"permission"`
+	Role       string `json:"role"`
+}
+
+// LoginLockout tracks failed SFTP logins from a client address or against
+// a target account
+type LoginLockout struct {
+	// Key is "ip:<address>" or "target:<user>@<host>:<port>"
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	Lockouts    int       `json:"lockouts"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
 }
 
 // Breadcrumb represents a breadcrumb navigation item
@@ -68,10 +288,153 @@ type Breadcrumb struct {
 
 // LoginRequest represents a login request
 type LoginRequest struct {
-	Host     string `json:"host" form:"host"`
-	Port     int    `json:"port" form:"port"`
-	Username string `json:"username" form:"username"`
-	Password string `json:"password" form:"password"`
+	// Name labels the connection in the browser; it defaults to user@host
+	Name string `json:"name" form:"connection_name"`
+
+	Host       string `json:"host" form:"host"`
+	Port       int    `json:"port" form:"port"`
+	Username   string `json:"username" form:"username"`
+	Password   string `json:"password" form:"password"`
+	PrivateKey string `json:"private_key" form:"private_key"`
+	Passphrase string `json:"passphrase" form:"passphrase"`
+
+	// JumpHosts is the ordered chain of bastions used to reach Host
+	JumpHosts []JumpHost `json:"jump_hosts" form:"-"`
+
+	// Proxy overrides the configured outbound proxy URL; ProxyDirect
+	// bypasses it
+	Proxy string `json:"proxy" form:"proxy"`
+
+	// DefaultPath is the directory the file browser opens in
+	DefaultPath string `json:"default_path" form:"default_path"`
+
+	// Options override the configured SSH algorithms and SFTP client
+	// settings for the target server
+	Options ConnectionOptions `json:"options" form:"-"`
+
+	// AcceptedHostKeys are SHA256 fingerprints of unknown host keys the
+	// user explicitly confirmed in the login flow
+	AcceptedHostKeys []string `json:"-" form:"-"`
+
+	// KeyboardInteractive allows credentials to be supplied by answering
+	// server challenges instead of up front
+	KeyboardInteractive bool `json:"-" form:"-"`
+
+	// AppUser and Role are the signed-in user opening the connection; the
+	// role decides which targets may be reached
+	AppUser string `json:"-" form:"-"`
+	Role    string `json:"-" form:"-"`
+
+	// ProfileID and SSHHost name the vault profile or server ssh_config
+	// entry that supplied the credentials, so the connection can be
+	// re-dialed after a restart
+	ProfileID string `json:"-" form:"-"`
+	SSHHost   string `json:"-" form:"-"`
+}
+
+// ConnectionOptions tunes the SSH algorithms and SFTP client of a
+// connection. Empty fields fall back to the server configuration.
+type ConnectionOptions struct {
+	Ciphers                      []string `json:"ciphers,omitempty"`
+	KeyExchanges                 []string `json:"key_exchanges,omitempty"`
+	MACs                         []string `json:"macs,omitempty"`
+	HostKeyAlgorithms            []string `json:"host_key_algorithms,omitempty"`
+	MaxPacket                    int      `json:"max_packet,omitempty"`
+	MaxConcurrentRequestsPerFile int      `json:"max_concurrent_requests_per_file,omitempty"`
+	UseConcurrentWrites          *bool    `json:"use_concurrent_writes,omitempty"`
+	UseConcurrentReads           *bool    `json:"use_concurrent_reads,omitempty"`
+}
+
+// NegotiatedAlgorithms are the algorithms agreed with the server during the
+// SSH key exchange
+type NegotiatedAlgorithms struct {
+	ServerVersion      string `json:"server_version"`
+	KeyExchange        string `json:"key_exchange"`
+	HostKey            string `json:"host_key"`
+	CipherClientServer string `json:"cipher_client_server"`
+	CipherServerClient string `json:"cipher_server_client"`
+	MACClientServer    string `json:"mac_client_server"`
+	MACServerClient    string `json:"mac_server_client"`
+}
+
+// DiagnosticsReport describes how far a connection to an SSH server gets
+// without logging in
+type DiagnosticsReport struct {
+	Host    string           `json:"host"`
+	Port    int              `json:"port"`
+	Proxy   string           `json:"proxy,omitempty"`
+	Success bool             `json:"success"`
+	Steps   []DiagnosticStep `json:"steps"`
+
+	Addresses     []string           `json:"addresses,omitempty"`
+	ServerVersion string             `json:"server_version,omitempty"`
+	HostKey       *DiagnosticKey     `json:"host_key,omitempty"`
+	Offered       *OfferedAlgorithms `json:"offered,omitempty"`
+	AuthMethods   []string           `json:"auth_methods,omitempty"`
+}
+
+// DiagnosticStep is the outcome of one stage of a diagnostics run
+type DiagnosticStep struct {
+	Name       string  `json:"name"`
+	OK         bool    `json:"ok"`
+	DurationMs float64 `json:"duration_ms"`
+	Detail     string  `json:"detail,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// DiagnosticKey is the host key presented by a server and whether it
+// matches known_hosts ("known", "unknown" or "changed")
+type DiagnosticKey struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	Status      string `json:"status"`
+}
+
+// OfferedAlgorithms are the algorithms a server proposes in its KEXINIT
+type OfferedAlgorithms struct {
+	KeyExchanges      []string `json:"key_exchanges"`
+	HostKeyAlgorithms []string `json:"host_key_algorithms"`
+	Ciphers           []string `json:"ciphers"`
+	MACs              []string `json:"macs"`
+}
+
+// LoginChallenge relays a keyboard-interactive challenge to the browser
+type LoginChallenge struct {
+	FlowID      string            `json:"flow_id"`
+	Host        string            `json:"host"`
+	Name        string            `json:"name"`
+	Instruction string            `json:"instruction"`
+	Prompts     []ChallengePrompt `json:"prompts"`
+}
+
+// ChallengePrompt is a single question of a keyboard-interactive challenge
+type ChallengePrompt struct {
+	Text string `json:"text"`
+	Echo bool   `json:"echo"`
+}
+
+// JumpHost is an intermediate SSH server a connection is tunnelled through.
+// Every hop authenticates with its own credentials.
+type JumpHost struct {
+	Host       string `json:"host"`
+	Port       int    `json:"port"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	PrivateKey string `json:"private_key"`
+	Passphrase string `json:"passphrase"`
+}
+
+// String returns the jump host as user@host:port
+func (j JumpHost) String() string {
+	return fmt.Sprintf("%s@%s", j.Username, net.JoinHostPort(j.Host, strconv.Itoa(j.Port)))
+}
+
+// HostKeyPrompt asks the user to confirm the host key of an unknown server
+type HostKeyPrompt struct {
+	Token       string `json:"token"`
+	Host        string `json:"host"`
+	KeyType     string `json:"key_type"`
+	Fingerprint string `json:"fingerprint"`
 }
 
 // FileListRequest represents a file list request
@@ -132,6 +495,66 @@ func (s *Session) UpdateAccess() {
 	s.LastAccess = time.Now()
 }
 
+// MarkBroken flags the session's SSH connection as dead
+func (s *Session) MarkBroken() {
+	atomic.StoreInt32(&s.broken, 1)
+}
+
+// IsBroken reports whether the SSH connection was found to be dead
+func (s *Session) IsBroken() bool {
+	return atomic.LoadInt32(&s.broken) == 1
+}
+
+// AddTransferred records file data read from or written to the server
+func (s *Session) AddTransferred(n int64) {
+	atomic.AddInt64(&s.transferred, n)
+}
+
+// Transferred returns the bytes of file data moved over the connection
+func (s *Session) Transferred() int64 {
+	return atomic.LoadInt64(&s.transferred)
+}
+
+// BeginOperation records the file operation in progress until the returned
+// function is called
+func (s *Session) BeginOperation(description string) func() {
+	current := &description
+	s.operation.Store(current)
+	return func() {
+		s.operation.CompareAndSwap(current, nil)
+	}
+}
+
+// Operation describes the file operation in progress, if any
+func (s *Session) Operation() string {
+	if current := s.operation.Load(); current != nil {
+		return *current
+	}
+	return ""
+}
+
+// Reconnected replaces the connections of a broken session with new ones
+// and closes the old ones
+func (s *Session) Reconnected(sshClient *ssh.Client, sftpClient *sftp.Client, jumpClients []*ssh.Client) {
+	oldSFTP, oldSSH, oldJumps := s.SFTPClient, s.SSHClient, s.JumpClients
+
+	s.SSHClient = sshClient
+	s.SFTPClient = sftpClient
+	s.JumpClients = jumpClients
+	s.Reconnects++
+	atomic.StoreInt32(&s.broken, 0)
+
+	if oldSFTP != nil {
+		oldSFTP.Close()
+	}
+	if oldSSH != nil {
+		oldSSH.Close()
+	}
+	for i := len(oldJumps) - 1; i >= 0; i-- {
+		oldJumps[i].Close()
+	}
+}
+
 // Close closes the session connections
 func (s *Session) Close() error {
 	s.IsActive = false
@@ -151,6 +574,14 @@ func (s *Session) Close() error {
 		s.SSHClient = nil
 	}
 
+	// Tear down the tunnel from the innermost bastion outwards
+	for i := len(s.JumpClients) - 1; i >= 0; i-- {
+		if closeErr := s.JumpClients[i].Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	s.JumpClients = nil
+
 	return err
 }
 
@@ -165,12 +596,132 @@ func (r *LoginRequest) Validate() error {
 	if r.Username == "" {
 		return ErrInvalidUsername
 	}
-	if r.Password == "" {
-		return ErrInvalidPassword
+	if r.Password == "" && r.PrivateKey == "" && !r.KeyboardInteractive {
+		return ErrMissingCredentials
+	}
+	for _, jump := range r.JumpHosts {
+		if err := jump.validate(r.KeyboardInteractive); err != nil {
+			return NewValidationError(fmt.Sprintf("jump host %s: %s", jump.Host, err.Error()))
+		}
+	}
+	if err := ValidateConnectionProxy(r.Proxy); err != nil {
+		return err
+	}
+	return r.Options.Validate()
+}
+
+// ProxyDirect as the proxy of a connection bypasses the configured proxy
+const ProxyDirect = "direct"
+
+// ValidateConnectionProxy checks a proxy entered for a single connection:
+// a socks5:// or http:// URL with a host and port and nothing else.
+// Credentials are refused because the URL is saved with profiles; a proxy
+// that needs them belongs in the server configuration.
+func ValidateConnectionProxy(proxy string) error {
+	if proxy == "" || proxy == ProxyDirect {
+		return nil
+	}
+
+	u, err := url.Parse(proxy)
+	if err != nil || (u.Scheme != "socks5" && u.Scheme != "http") || u.Opaque != "" ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return ErrConnectionProxy
+	}
+	if u.User != nil {
+		return ErrProxyCredentials
+	}
+	if port, err := strconv.Atoi(u.Port()); u.Hostname() == "" || err != nil || port < 1 || port > 65535 {
+		return ErrConnectionProxy
+	}
+	return nil
+}
+
+// Validate validates the numeric SFTP client settings. Algorithm names are
+// checked against the SSH library when connecting.
+func (o *ConnectionOptions) Validate() error {
+	if o.MaxPacket != 0 && (o.MaxPacket < 1<<10 || o.MaxPacket > 1<<18) {
+		return NewValidationError("max packet size must be between 1024 and 262144 bytes")
+	}
+	if o.MaxConcurrentRequestsPerFile < 0 || o.MaxConcurrentRequestsPerFile > 1024 {
+		return NewValidationError("concurrent requests per file must be between 1 and 1024")
+	}
+	return nil
+}
+
+// IsDefault reports whether no setting is overridden
+func (o *ConnectionOptions) IsDefault() bool {
+	return len(o.Ciphers) == 0 && len(o.KeyExchanges) == 0 && len(o.MACs) == 0 &&
+		len(o.HostKeyAlgorithms) == 0 && o.MaxPacket == 0 && o.MaxConcurrentRequestsPerFile == 0 &&
+		o.UseConcurrentWrites == nil && o.UseConcurrentReads == nil
+}
+
+// JumpHostStrings returns the jump host chain as user@host:port strings
+func (r *LoginRequest) JumpHostStrings() []string {
+	var chain []string
+	for _, jump := range r.JumpHosts {
+		chain = append(chain, jump.String())
+	}
+	return chain
+}
+
+// validate validates a jump host of a login request
+func (j *JumpHost) validate(interactive bool) error {
+	if j.Host == "" {
+		return ErrInvalidHost
+	}
+	if j.Port <= 0 || j.Port > 65535 {
+		return ErrInvalidPort
+	}
+	if j.Username == "" {
+		return ErrInvalidUsername
+	}
+	if j.Password == "" && j.PrivateKey == "" && !interactive {
+		return ErrMissingCredentials
 	}
 	return nil
 }
 
+// Validate validates a connection profile before it is saved
+func (p *ConnectionProfile) Validate() error {
+	if p.Name == "" {
+		return ErrInvalidProfileName
+	}
+	return p.LoginRequest().Validate()
+}
+
+// LoginRequest builds the login request for connecting with the profile
+func (p *ConnectionProfile) LoginRequest() *LoginRequest {
+	return &LoginRequest{
+		Name:        p.Name,
+		Host:        p.Host,
+		Port:        p.Port,
+		Username:    p.Username,
+		Password:    p.Password,
+		PrivateKey:  p.PrivateKey,
+		Passphrase:  p.Passphrase,
+		JumpHosts:   append([]JumpHost(nil), p.JumpHosts...),
+		Proxy:       p.Proxy,
+		DefaultPath: p.DefaultPath,
+		Options:     p.Options,
+		ProfileID:   p.ID,
+	}
+}
+
+// Redacted returns a copy of the profile without any secrets, for listing
+func (p ConnectionProfile) Redacted() ConnectionProfile {
+	p.Password = ""
+	p.PrivateKey = ""
+	p.Passphrase = ""
+
+	jumpHosts := make([]JumpHost, len(p.JumpHosts))
+	for i, jump := range p.JumpHosts {
+		jumpHosts[i] = JumpHost{Host: jump.Host, Port: jump.Port, Username: jump.Username}
+	}
+	p.JumpHosts = jumpHosts
+
+	return p
+}
+
 // Validate validates the file list request
 func (r *FileListRequest) Validate() error {
 	if r.Path == "" {
@@ -190,13 +741,73 @@ func (r *FileListRequest) Validate() error {
 
 // Custom errors
 var (
-	ErrInvalidHost     = NewValidationError("host is required")
-	ErrInvalidPort     = NewValidationError("port must be between 1 and 65535")
-	ErrInvalidUsername = NewValidationError("username is required")
-	ErrInvalidPassword = NewValidationError("password is required")
-	ErrSessionExpired  = NewSessionError("session has expired")
-	ErrSessionNotFound = NewSessionError("session not found")
-	ErrUnauthorized    = NewAuthError("unauthorized access")
+	ErrInvalidHost        = NewValidationError("host is required")
+	ErrInvalidPort        = NewValidationError("port must be between 1 and 65535")
+	ErrInvalidUsername    = NewValidationError("username is required")
+	ErrMissingCredentials = NewValidationError("password or private key is required")
+	ErrInvalidPrivateKey  = NewValidationError("private key could not be parsed")
+	ErrPassphraseRequired = NewValidationError("private key is encrypted, passphrase is required")
+	ErrInvalidPassphrase  = NewValidationError("private key passphrase is incorrect")
+	ErrSessionExpired     = NewSessionError("session has expired")
+	ErrSessionNotFound    = NewSessionError("session not found")
+	ErrSessionMoved       = NewSessionError("session was used from another network or browser, please sign in again")
+	ErrLoginExpired       = NewSessionError("login request has expired, please connect again")
+	ErrConnectionNotFound = NewSessionError("connection not found, it may have expired")
+	ErrTooManyConnections = NewSessionError("maximum number of connections for this session reached")
+	ErrChallengeTimeout   = NewAuthError("timed out waiting for challenge response")
+	ErrInvalidProfileName = NewValidationError("profile name is required")
+	ErrProfileNotFound    = NewValidationError("profile not found")
+	ErrVaultLocked        = NewAuthError("profile vault is locked")
+	ErrVaultPassphrase    = NewAuthError("incorrect vault passphrase")
+	ErrConnectionLost     = NewSessionError("connection to the server was lost and could not be re-established")
+	ErrLoginRequired      = NewSessionError("the web client was restarted, please connect to the server again")
+	ErrRestoreVaultLocked = NewSessionError("the web client was restarted, unlock your profile vault to reconnect")
+	ErrNoChallenge        = NewAuthError("no challenge is waiting for a response")
+	ErrUnauthorized       = NewAuthError("unauthorized access")
+	ErrInvalidCredentials = NewAuthError("invalid username or password")
+	ErrUserNotFound       = NewValidationError("user not found")
+	ErrWeakPassword       = NewValidationError("password must be at least 8 characters")
+	ErrLastAdmin          = NewValidationError("at least one enabled admin account is required")
+	ErrUnknownRole        = NewValidationError("unknown role")
+	ErrPermissionDenied   = NewAuthError("permission denied")
+	ErrExternalAccount    = NewValidationError("accounts from an identity provider have no local password")
+	ErrAccountConflict    = NewAuthError("an account with this username already exists for another sign-in method")
+	ErrSubjectMismatch    = NewAuthError("this username belongs to another account at the identity provider")
+	ErrSSOFailed          = NewAuthError("single sign-on failed")
+	ErrTargetNotAllowed   = NewAuthError("connections to this server are not allowed")
+	ErrLockoutNotFound    = NewValidationError("lockout not found")
+	ErrTooManySessions    = NewSessionError("maximum number of sessions reached")
+	ErrUserSessionQuota   = NewSessionError("you have reached your limit of open connections, close one first")
+	ErrHostSessionQuota   = NewSessionError("too many connections to this server are open, try again later")
+	ErrInvalidFormData    = NewValidationError("invalid form data")
+	ErrInvalidProxy       = NewValidationError("invalid proxy URL")
+	ErrConnectionProxy    = NewValidationError("proxy must be socks5://host:port, http://host:port or direct")
+	ErrProxyCredentials   = NewValidationError("proxy credentials cannot be entered here, ask an administrator to configure the proxy")
+	ErrProxyRefused       = NewSessionError("the proxy refused the connection")
+	ErrProxyNotAllowed    = NewAuthError("connections may not choose their own proxy on this server")
+	ErrHostKeyRejected    = NewSessionError("host key rejected, connection cancelled")
+	ErrSignInFailed       = NewAuthError("sign-in failed, please try again")
+	ErrSignInMismatch     = NewAuthError("sign-in request does not match this browser, please try again")
+	ErrFileExists         = NewValidationError("file already exists")
+	ErrIsDirectory        = NewValidationError("path is a directory")
+	ErrPreviewTooLarge    = NewValidationError("file too large for preview")
+	ErrSamePath           = NewValidationError("the destination is the same as the source")
+	ErrMoveIntoItself     = NewValidationError("a directory cannot be moved into itself")
+	ErrDirectoryExists    = NewValidationError("a directory with that name already exists")
+	ErrNotDirectory       = NewValidationError("the destination is not a directory")
+
+	// Messages shown in place of SSH and SFTP errors, which are too
+	// technical for the page
+	ErrSSHAuthFailed     = NewAuthError("the server rejected the username or credentials")
+	ErrHostNotFound      = NewSessionError("the server name could not be resolved, check the host")
+	ErrConnectionRefused = NewSessionError("the connection was refused, check the host and port")
+	ErrConnectTimeout    = NewSessionError("the server did not respond in time")
+	ErrNoCommonAlgorithm = NewSessionError("the server offers no algorithm this client accepts, check the advanced settings")
+	ErrSFTPUnavailable   = NewSessionError("the server does not provide SFTP")
+	ErrConnectionFailed  = NewSessionError("could not connect to the server")
+	ErrRemotePermission  = NewValidationError("permission denied on the server")
+	ErrRemoteNotFound    = NewValidationError("no such file or directory")
+	ErrOperationFailed   = NewSessionError("the operation failed on the server")
 )
 
 // Error types
@@ -235,3 +846,34 @@ func (e AuthError) Error() string {
 func NewAuthError(message string) AuthError {
 	return AuthError{Message: message}
 }
+
+// LockoutError refuses a login while too many recent attempts failed
+type LockoutError struct {
+	// Subject is what is locked, e.g. "your address" or "test@example.com:22"
+	Subject string
+	Until   time.Time
+}
+
+func (e *LockoutError) Error() string {
+	wait := time.Until(e.Until).Round(time.Second)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return fmt.Sprintf("too many failed logins for %s, try again in %s", e.Subject, wait)
+}
+
+// HostKeyError reports a host key that is unknown or does not match the
+// key recorded in known_hosts
+type HostKeyError struct {
+	Host        string
+	KeyType     string
+	Fingerprint string
+	Changed     bool
+}
+
+func (e *HostKeyError) Error() string {
+	if e.Changed {
+		return fmt.Sprintf("host key for %s has changed (%s %s), connection blocked: possible man-in-the-middle attack", e.Host, e.KeyType, e.Fingerprint)
+	}
+	return fmt.Sprintf("host key for %s is not trusted (%s %s)", e.Host, e.KeyType, e.Fingerprint)
+}
@@ -0,0 +1,383 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"sftp-gui/internal/models"
+)
+
+// Algorithms implemented by golang.org/x/crypto/ssh on the client side.
+// Legacy entries such as CBC ciphers and SHA-1 key exchanges are supported
+// but only used when configured explicitly.
+var (
+	supportedCiphers = []string{
+		"aes128-gcm@openssh.com", "aes256-gcm@openssh.com",
+		"chacha20-poly1305@openssh.com",
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		"aes128-cbc", "3des-cbc",
+		"arcfour256", "arcfour128", "arcfour",
+	}
+	supportedKeyExchanges = []string{
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512",
+		"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1",
+		"diffie-hellman-group-exchange-sha256", "diffie-hellman-group-exchange-sha1",
+	}
+	supportedMACs = []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+		"hmac-sha2-256", "hmac-sha2-512", "hmac-sha1", "hmac-sha1-96",
+	}
+	supportedHostKeyAlgorithms = []string{
+		ssh.KeyAlgoED25519, ssh.KeyAlgoSKED25519,
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521, ssh.KeyAlgoSKECDSA256,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+		ssh.CertAlgoED25519v01, ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+		ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01, ssh.CertAlgoDSAv01,
+	}
+)
+
+// defaultConnectionOptions returns the configured algorithms and SFTP
+// client settings
+func (s *SessionService) defaultConnectionOptions() models.ConnectionOptions {
+	cfg := s.config.SSH
+	return models.ConnectionOptions{
+		Ciphers:                      cfg.Ciphers,
+		KeyExchanges:                 cfg.KeyExchanges,
+		MACs:                         cfg.MACs,
+		HostKeyAlgorithms:            cfg.HostKeyAlgorithms,
+		MaxPacket:                    cfg.SFTP.MaxPacket,
+		MaxConcurrentRequestsPerFile: cfg.SFTP.MaxConcurrentRequestsPerFile,
+		UseConcurrentWrites:          &cfg.SFTP.UseConcurrentWrites,
+		UseConcurrentReads:           &cfg.SFTP.UseConcurrentReads,
+	}
+}
+
+// connectionOptions merges the per-connection overrides of a login request
+// over the configured defaults
+func (s *SessionService) connectionOptions(req *models.LoginRequest) models.ConnectionOptions {
+	options := s.defaultConnectionOptions()
+
+	override := req.Options
+	if len(override.Ciphers) > 0 {
+		options.Ciphers = override.Ciphers
+	}
+	if len(override.KeyExchanges) > 0 {
+		options.KeyExchanges = override.KeyExchanges
+	}
+	if len(override.MACs) > 0 {
+		options.MACs = override.MACs
+	}
+	if len(override.HostKeyAlgorithms) > 0 {
+		options.HostKeyAlgorithms = override.HostKeyAlgorithms
+	}
+	if override.MaxPacket > 0 {
+		options.MaxPacket = override.MaxPacket
+	}
+	if override.MaxConcurrentRequestsPerFile > 0 {
+		options.MaxConcurrentRequestsPerFile = override.MaxConcurrentRequestsPerFile
+	}
+	if override.UseConcurrentWrites != nil {
+		options.UseConcurrentWrites = override.UseConcurrentWrites
+	}
+	if override.UseConcurrentReads != nil {
+		options.UseConcurrentReads = override.UseConcurrentReads
+	}
+
+	return options
+}
+
+// ValidateAlgorithms checks that every configured algorithm is implemented,
+// so a typo fails loudly instead of silently dropping the algorithm
+func ValidateAlgorithms(options models.ConnectionOptions) error {
+	lists := []struct {
+		kind      string
+		names     []string
+		supported []string
+	}{
+		{"cipher", options.Ciphers, supportedCiphers},
+		{"key exchange", options.KeyExchanges, supportedKeyExchanges},
+		{"MAC", options.MACs, supportedMACs},
+		{"host key algorithm", options.HostKeyAlgorithms, supportedHostKeyAlgorithms},
+	}
+
+	for _, list := range lists {
+		for _, name := range list.names {
+			if !containsString(list.supported, name) {
+				return models.NewValidationError(fmt.Sprintf("unsupported %s %q (supported: %s)",
+					list.kind, name, strings.Join(list.supported, ", ")))
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyAlgorithms restricts an SSH client config to the chosen algorithms.
+// Host key algorithms are intersected with those of the recorded known_hosts
+// keys so that the server still presents a key that can be verified.
+func applyAlgorithms(sshConfig *ssh.ClientConfig, options models.ConnectionOptions) {
+	sshConfig.Ciphers = options.Ciphers
+	sshConfig.KeyExchanges = options.KeyExchanges
+	sshConfig.MACs = options.MACs
+
+	if len(options.HostKeyAlgorithms) == 0 {
+		return
+	}
+	if len(sshConfig.HostKeyAlgorithms) == 0 {
+		sshConfig.HostKeyAlgorithms = options.HostKeyAlgorithms
+		return
+	}
+
+	var algorithms []string
+	for _, algorithm := range options.HostKeyAlgorithms {
+		if containsString(sshConfig.HostKeyAlgorithms, algorithm) {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	if len(algorithms) > 0 {
+		sshConfig.HostKeyAlgorithms = algorithms
+	}
+}
+
+// sftpClientOptions converts connection options to pkg/sftp client options
+func sftpClientOptions(options models.ConnectionOptions) []sftp.ClientOption {
+	var clientOptions []sftp.ClientOption
+
+	if options.MaxPacket > 0 {
+		// Packets above the 32 KiB every server must accept are opt-in
+		if options.MaxPacket > 1<<15 {
+			clientOptions = append(clientOptions, sftp.MaxPacketUnchecked(options.MaxPacket))
+		} else {
+			clientOptions = append(clientOptions, sftp.MaxPacketChecked(options.MaxPacket))
+		}
+	}
+	if options.MaxConcurrentRequestsPerFile > 0 {
+		clientOptions = append(clientOptions, sftp.MaxConcurrentRequestsPerFile(options.MaxConcurrentRequestsPerFile))
+	}
+	if options.UseConcurrentWrites != nil {
+		clientOptions = append(clientOptions, sftp.UseConcurrentWrites(*options.UseConcurrentWrites))
+	}
+	if options.UseConcurrentReads != nil {
+		clientOptions = append(clientOptions, sftp.UseConcurrentReads(*options.UseConcurrentReads))
+	}
+
+	return clientOptions
+}
+
+// containsString reports whether list contains value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// maxKexInitCapture bounds how many bytes are buffered while looking for
+// the version banner and the first KEXINIT packet
+const maxKexInitCapture = 64 << 10
+
+// msgKexInit is the SSH_MSG_KEXINIT message number
+const msgKexInit = 20
+
+// kexInit is the algorithm proposal of one side of an SSH connection
+type kexInit struct {
+	version           string
+	kexAlgorithms     []string
+	hostKeyAlgorithms []string
+	ciphersClient     []string
+	ciphersServer     []string
+	macsClient        []string
+	macsServer        []string
+}
+
+// kexRecorder wraps a connection and records the version banner and the
+// initial KEXINIT packet in each direction. Both are sent in the clear, so
+// the negotiated algorithms can be derived from them.
+type kexRecorder struct {
+	net.Conn
+
+	mutex    sync.Mutex
+	received kexCapture
+	sent     kexCapture
+}
+
+// kexCapture buffers the start of one direction of the stream
+type kexCapture struct {
+	data []byte
+	done bool
+	init *kexInit
+}
+
+// newKexRecorder wraps conn to record its key exchange
+func newKexRecorder(conn net.Conn) *kexRecorder {
+	return &kexRecorder{Conn: conn}
+}
+
+// Read implements net.Conn
+func (k *kexRecorder) Read(p []byte) (int, error) {
+	n, err := k.Conn.Read(p)
+	if n > 0 {
+		k.mutex.Lock()
+		k.received.record(p[:n])
+		k.mutex.Unlock()
+	}
+	return n, err
+}
+
+// Write implements net.Conn
+func (k *kexRecorder) Write(p []byte) (int, error) {
+	k.mutex.Lock()
+	k.sent.record(p)
+	k.mutex.Unlock()
+	return k.Conn.Write(p)
+}
+
+// record appends data until the KEXINIT packet has been parsed
+func (c *kexCapture) record(p []byte) {
+	if c.done {
+		return
+	}
+
+	c.data = append(c.data, p...)
+	init, complete := parseKexInit(c.data)
+	if complete || len(c.data) > maxKexInitCapture {
+		c.init = init
+		c.done = true
+		c.data = nil
+	}
+}
+
+// Negotiated returns the algorithms both sides agreed on, following the
+// selection rules of RFC 4253: the first client algorithm the server also
+// supports wins
+func (k *kexRecorder) Negotiated() *models.NegotiatedAlgorithms {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	client, server := k.sent.init, k.received.init
+	if client == nil || server == nil {
+		return nil
+	}
+
+	negotiated := &models.NegotiatedAlgorithms{
+		ServerVersion:      server.version,
+		KeyExchange:        firstCommon(client.kexAlgorithms, server.kexAlgorithms),
+		HostKey:            firstCommon(client.hostKeyAlgorithms, server.hostKeyAlgorithms),
+		CipherClientServer: firstCommon(client.ciphersClient, server.ciphersClient),
+		CipherServerClient: firstCommon(client.ciphersServer, server.ciphersServer),
+		MACClientServer:    firstCommon(client.macsClient, server.macsClient),
+		MACServerClient:    firstCommon(client.macsServer, server.macsServer),
+	}
+
+	// AEAD ciphers authenticate the data themselves
+	if isAEADCipher(negotiated.CipherClientServer) {
+		negotiated.MACClientServer = "implicit"
+	}
+	if isAEADCipher(negotiated.CipherServerClient) {
+		negotiated.MACServerClient = "implicit"
+	}
+
+	return negotiated
+}
+
+// ServerKexInit returns the server's algorithm proposal, if it was seen
+func (k *kexRecorder) ServerKexInit() *kexInit {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return k.received.init
+}
+
+// parseKexInit parses the version banner and the first binary packet of a
+// stream. It reports whether enough data was available; the result is nil
+// if the data is not a valid KEXINIT.
+func parseKexInit(data []byte) (*kexInit, bool) {
+	// Servers may send other lines before the version banner
+	var version string
+	for {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			return nil, false
+		}
+		line := strings.TrimRight(string(data[:end]), "\r")
+		data = data[end+1:]
+		if strings.HasPrefix(line, "SSH-") {
+			version = line
+			break
+		}
+	}
+
+	if len(data) < 5 {
+		return nil, false
+	}
+	length := binary.BigEndian.Uint32(data)
+	if length > maxKexInitCapture {
+		return nil, true
+	}
+	if uint32(len(data)-4) < length {
+		return nil, false
+	}
+
+	padding := int(data[4])
+	payloadLength := int(length) - 1 - padding
+	if payloadLength < 17 {
+		return nil, true
+	}
+	payload := data[5 : 5+payloadLength]
+	if payload[0] != msgKexInit {
+		return nil, true
+	}
+
+	// Skip the message number and the 16 byte cookie
+	payload = payload[17:]
+	var lists [8][]string
+	for i := range lists {
+		if len(payload) < 4 {
+			return nil, true
+		}
+		size := binary.BigEndian.Uint32(payload)
+		if uint32(len(payload)-4) < size {
+			return nil, true
+		}
+		if size > 0 {
+			lists[i] = strings.Split(string(payload[4:4+size]), ",")
+		}
+		payload = payload[4+size:]
+	}
+
+	return &kexInit{
+		version:           version,
+		kexAlgorithms:     lists[0],
+		hostKeyAlgorithms: lists[1],
+		ciphersClient:     lists[2],
+		ciphersServer:     lists[3],
+		macsClient:        lists[4],
+		macsServer:        lists[5],
+	}, true
+}
+
+// firstCommon returns the first client algorithm the server also offers
+func firstCommon(client, server []string) string {
+	for _, algorithm := range client {
+		if containsString(server, algorithm) {
+			return algorithm
+		}
+	}
+	return ""
+}
+
+// isAEADCipher reports whether a cipher includes its own authentication
+func isAEADCipher(cipher string) bool {
+	return strings.HasSuffix(cipher, "-gcm@openssh.com") || cipher == "chacha20-poly1305@openssh.com"
+}
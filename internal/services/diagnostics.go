@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+// diagnosticsUser is offered to the server when no username is given. Most
+// servers list the same authentication methods for every user.
+const diagnosticsUser = "diagnostics"
+
+// errDiagnosticsAuth aborts authentication once a method has been recorded
+var errDiagnosticsAuth = errors.New("diagnostics do not log in")
+
+// DiagnosticsService checks how far a connection to an SSH server gets,
+// stopping before any credentials are sent
+type DiagnosticsService struct {
+	config   *config.Config
+	hostKeys *HostKeyService
+}
+
+// NewDiagnosticsService creates a new diagnostics service
+func NewDiagnosticsService(cfg *config.Config, hostKeys *HostKeyService) *DiagnosticsService {
+	return &DiagnosticsService{
+		config:   cfg,
+		hostKeys: hostKeys,
+	}
+}
+
+// Run resolves the host, connects to it and runs the SSH handshake up to
+// authentication. Steps after the first failing one are skipped. proxy
+// overrides the configured outbound proxy when set. The host and proxy must
+// be allowed for the role of appUser, like for any connection.
+func (d *DiagnosticsService) Run(host string, port int, username, proxy, appUser, role string) *models.DiagnosticsReport {
+	if username == "" {
+		username = diagnosticsUser
+	}
+
+	report := &models.DiagnosticsReport{
+		Host:  host,
+		Port:  port,
+		Steps: []models.DiagnosticStep{},
+	}
+	timeout := d.config.SSH.DialTimeout
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+	// Target policy, checked before anything else touches the host or
+	// the proxy
+	dialAddr, err := newTargetGuard(d.config, appUser, role).dialAddress(host, port, false)
+	if err == nil {
+		proxy, err = outboundProxy(d.config, proxy)
+		report.Proxy = RedactProxyURL(proxy)
+	}
+	if d.config.Policy.Targets.Enabled() {
+		step := models.DiagnosticStep{Name: "Target policy", OK: err == nil}
+		if err != nil {
+			step.Error = err.Error()
+			report.Steps = append(report.Steps, step)
+			return report
+		}
+		step.Detail = fmt.Sprintf("allowed, connecting to %s", dialAddr)
+		report.Steps = append(report.Steps, step)
+	}
+
+	// DNS resolution. A proxy may resolve names the server cannot, so a
+	// failed lookup is only fatal for direct connections.
+	if !d.resolve(report, host, timeout) && (proxy == "" || proxy == ProxyDirect) {
+		return report
+	}
+
+	// TCP connect
+	dialer, err := newDialer(proxy, timeout)
+	if err != nil {
+		report.Steps = append(report.Steps, models.DiagnosticStep{Name: "TCP connect", Error: err.Error()})
+		return report
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", dialAddr)
+	step := models.DiagnosticStep{Name: "TCP connect", DurationMs: milliseconds(time.Since(start))}
+	if err != nil {
+		step.Error = err.Error()
+		report.Steps = append(report.Steps, step)
+		return report
+	}
+	defer conn.Close()
+
+	step.OK = true
+	step.Detail = fmt.Sprintf("connected to %s", conn.RemoteAddr())
+	report.Steps = append(report.Steps, step)
+
+	// SSH handshake and authentication methods
+	d.handshake(report, conn, addr, username, timeout)
+
+	return report
+}
+
+// resolve records the DNS step and reports whether it succeeded
+func (d *DiagnosticsService) resolve(report *models.DiagnosticsReport, host string, timeout time.Duration) bool {
+	step := models.DiagnosticStep{Name: "DNS resolution"}
+
+	if ip := net.ParseIP(host); ip != nil {
+		step.OK = true
+		step.Detail = "literal IP address, no lookup needed"
+		report.Addresses = []string{ip.String()}
+		report.Steps = append(report.Steps, step)
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	step.DurationMs = milliseconds(time.Since(start))
+	if err != nil {
+		step.Error = err.Error()
+		report.Steps = append(report.Steps, step)
+		return false
+	}
+
+	for _, addr := range addrs {
+		report.Addresses = append(report.Addresses, addr.IP.String())
+	}
+	step.OK = true
+	step.Detail = strings.Join(report.Addresses, ", ")
+	report.Steps = append(report.Steps, step)
+	return true
+}
+
+// handshake runs the key exchange on conn and asks the server which
+// authentication methods it accepts. Every auth callback records its method
+// and fails, so no credentials are ever sent.
+func (d *DiagnosticsService) handshake(report *models.DiagnosticsReport, conn net.Conn, addr, username string, timeout time.Duration) {
+	var (
+		mutex   sync.Mutex
+		methods []string
+		hostKey ssh.PublicKey
+		remote  net.Addr
+	)
+	record := func(method string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !containsString(methods, method) {
+			methods = append(methods, method)
+		}
+		return errDiagnosticsAuth
+	}
+
+	sshConfig := &ssh.ClientConfig{
+		User: username,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				return nil, record("publickey")
+			}),
+			ssh.PasswordCallback(func() (string, error) {
+				return "", record("password")
+			}),
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				return nil, record("keyboard-interactive")
+			}),
+		},
+		HostKeyCallback: func(hostname string, addr net.Addr, key ssh.PublicKey) error {
+			hostKey, remote = key, addr
+			return nil
+		},
+		// Ask for a key type recorded in known_hosts so the status check
+		// compares like with like
+		HostKeyAlgorithms: d.hostKeys.KnownKeyAlgorithms(addr),
+		Timeout:           timeout,
+	}
+	applyAlgorithms(sshConfig, models.ConnectionOptions{
+		Ciphers:           d.config.SSH.Ciphers,
+		KeyExchanges:      d.config.SSH.KeyExchanges,
+		MACs:              d.config.SSH.MACs,
+		HostKeyAlgorithms: d.config.SSH.HostKeyAlgorithms,
+	})
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	recorder := newKexRecorder(conn)
+
+	start := time.Now()
+	clientConn, chans, reqs, err := ssh.NewClientConn(recorder, addr, sshConfig)
+	elapsed := milliseconds(time.Since(start))
+
+	step := models.DiagnosticStep{Name: "SSH handshake", DurationMs: elapsed}
+	if init := recorder.ServerKexInit(); init != nil {
+		report.ServerVersion = init.version
+		report.Offered = &models.OfferedAlgorithms{
+			KeyExchanges:      init.kexAlgorithms,
+			HostKeyAlgorithms: init.hostKeyAlgorithms,
+			Ciphers:           init.ciphersServer,
+			MACs:              init.macsServer,
+		}
+	}
+	if hostKey == nil {
+		step.Error = "no SSH host key received"
+		if err != nil {
+			step.Error = err.Error()
+		}
+		report.Steps = append(report.Steps, step)
+		return
+	}
+
+	report.HostKey = &models.DiagnosticKey{
+		Type:        hostKey.Type(),
+		Fingerprint: ssh.FingerprintSHA256(hostKey),
+		Status:      d.hostKeys.Status(addr, remote, hostKey),
+	}
+	step.OK = true
+	step.Detail = report.ServerVersion
+	report.Steps = append(report.Steps, step)
+
+	auth := models.DiagnosticStep{Name: "Authentication methods", OK: true}
+	if err == nil {
+		// The server let us in without credentials
+		ssh.NewClient(clientConn, chans, reqs).Close()
+		methods = []string{"none"}
+	} else if len(methods) == 0 {
+		auth.OK = false
+		auth.Error = err.Error()
+	}
+	report.AuthMethods = methods
+	auth.Detail = strings.Join(methods, ", ")
+	report.Steps = append(report.Steps, auth)
+	report.Success = auth.OK
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/sftp"
+
+	"sftp-gui/internal/models"
+)
+
+// UserError returns the message to show users for an error. Errors from
+// models are already worded for users and are returned unchanged. SSH,
+// SFTP and network errors are mapped to a typed message; anything else is
+// replaced by fallback. The original error is logged whenever it is
+// replaced, so the details stay available to administrators.
+func UserError(err, fallback error) error {
+	if err == nil || isUserFacing(err) {
+		return err
+	}
+
+	mapped := classifyError(err)
+	if mapped == nil {
+		mapped = fallback
+	}
+	fmt.Printf("Showing %q for error: %v\n", mapped, err)
+	return mapped
+}
+
+// isUserFacing reports whether an error carries a message written for users
+func isUserFacing(err error) bool {
+	var validationErr models.ValidationError
+	var sessionErr models.SessionError
+	var authErr models.AuthError
+	var hostKeyErr *models.HostKeyError
+	var lockoutErr *models.LockoutError
+
+	return errors.As(err, &validationErr) ||
+		errors.As(err, &sessionErr) ||
+		errors.As(err, &authErr) ||
+		errors.As(err, &hostKeyErr) ||
+		errors.As(err, &lockoutErr)
+}
+
+// classifyError maps SSH, SFTP and network errors to a typed message, or
+// returns nil when the error is not recognised. The SSH package reports
+// most failures as plain strings, so those are matched by their text.
+func classifyError(err error) error {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	message := err.Error()
+
+	switch {
+	case IsAuthFailure(err):
+		return models.ErrSSHAuthFailed
+	case errors.As(err, &dnsErr):
+		return models.ErrHostNotFound
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return models.ErrConnectionRefused
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return models.ErrConnectTimeout
+	case strings.Contains(message, "no common algorithm"):
+		return models.ErrNoCommonAlgorithm
+	case strings.Contains(message, "subsystem request failed"):
+		return models.ErrSFTPUnavailable
+	case errors.Is(err, sftp.ErrSSHFxConnectionLost), errors.Is(err, sftp.ErrSSHFxNoConnection):
+		return models.ErrConnectionLost
+	case errors.Is(err, os.ErrPermission):
+		return models.ErrRemotePermission
+	case errors.Is(err, os.ErrNotExist):
+		return models.ErrRemoteNotFound
+	}
+	return nil
+}
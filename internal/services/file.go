@@ -3,6 +3,9 @@ package services
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"path"
@@ -94,7 +97,7 @@ func (f *FileService) GetFile(sessionID, filePath string) (io.ReadCloser, *model
 	}
 
 	if stat.IsDir() {
-		return nil, nil, fmt.Errorf("path is a directory")
+		return nil, nil, models.ErrIsDirectory
 	}
 
 	// Open file
@@ -102,6 +105,11 @@ func (f *FileService) GetFile(sessionID, filePath string) (io.ReadCloser, *model
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to open file: %w", err)
 	}
+	download := &transferReader{
+		ReadCloser: file,
+		session:    session,
+		end:        session.BeginOperation("download " + filePath),
+	}
 
 	fileInfo := &models.FileInfo{
 		Name:    stat.Name(),
@@ -112,7 +120,7 @@ func (f *FileService) GetFile(sessionID, filePath string) (io.ReadCloser, *model
 		Path:    filePath,
 	}
 
-	return file, fileInfo, nil
+	return download, fileInfo, nil
 }
 
 // GetMultipleFiles creates a ZIP archive of multiple files
@@ -151,8 +159,9 @@ func (f *FileService) GetMultipleFiles(sessionID string, filePaths []string) (io
 		}
 
 		// Copy file content
-		_, err = io.Copy(zipFile, srcFile)
+		written, err := io.Copy(zipFile, srcFile)
 		srcFile.Close()
+		session.AddTransferred(written)
 
 		if err != nil {
 			continue // Skip files with copy errors
@@ -169,6 +178,7 @@ func (f *FileService) DeleteFile(sessionID, filePath string) error {
 	if err != nil {
 		return err
 	}
+	defer session.BeginOperation("delete " + filePath)()
 
 	// Check if it's a directory
 	stat, err := session.SFTPClient.Stat(filePath)
@@ -200,6 +210,122 @@ func (f *FileService) DeleteMultipleFiles(sessionID string, filePaths []string)
 	return deleted, failed
 }
 
+// posixRenameExtension replaces existing files atomically, which the
+// plain SFTP rename refuses to do
+const posixRenameExtension = "posix-rename@openssh.com"
+
+// RenameFile renames or moves a file or directory. An existing file at the
+// destination is only replaced when overwrite is set; directories are
+// never replaced. Without posix-rename the file being replaced is set
+// aside first and put back if the rename fails, so it is never lost.
+func (f *FileService) RenameFile(sessionID, oldPath, newPath string, overwrite bool) error {
+	session, err := f.sessionService.GetSession(sessionID)
+	if err != nil {
+		return err
+	}
+
+	oldPath, newPath = path.Clean(oldPath), path.Clean(newPath)
+	if oldPath == newPath {
+		return models.ErrSamePath
+	}
+	if strings.HasPrefix(newPath, strings.TrimSuffix(oldPath, "/")+"/") {
+		return models.ErrMoveIntoItself
+	}
+
+	defer session.BeginOperation(fmt.Sprintf("move %s to %s", oldPath, newPath))()
+
+	if _, err := session.SFTPClient.Lstat(oldPath); err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	_, posixRename := session.SFTPClient.HasExtension(posixRenameExtension)
+	var setAside string
+	if existing, err := session.SFTPClient.Lstat(newPath); err == nil {
+		switch {
+		case existing.IsDir():
+			return models.ErrDirectoryExists
+		case !overwrite:
+			return models.ErrFileExists
+		case !posixRename:
+			if setAside, err = setAsidePath(newPath); err != nil {
+				return err
+			}
+			if err := session.SFTPClient.Rename(newPath, setAside); err != nil {
+				return fmt.Errorf("failed to replace file: %w", err)
+			}
+		}
+	}
+
+	if posixRename {
+		err = session.SFTPClient.PosixRename(oldPath, newPath)
+	} else {
+		err = session.SFTPClient.Rename(oldPath, newPath)
+	}
+	if err != nil {
+		if setAside != "" {
+			if restoreErr := session.SFTPClient.Rename(setAside, newPath); restoreErr != nil {
+				fmt.Printf("Failed to restore %s from %s: %v\n", newPath, setAside, restoreErr)
+				return fmt.Errorf("failed to rename file, the replaced file was kept as %s: %w", setAside, err)
+			}
+		}
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+
+	if setAside != "" {
+		if err := session.SFTPClient.Remove(setAside); err != nil {
+			fmt.Printf("Failed to remove replaced file %s: %v\n", setAside, err)
+		}
+	}
+
+	return nil
+}
+
+// setAsidePath returns an unused name next to a file to move it to while
+// it is being replaced
+func setAsidePath(filePath string) (string, error) {
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate temporary name: %w", err)
+	}
+	dir, name := path.Split(filePath)
+	return path.Join(dir, fmt.Sprintf(".%s.replaced-%s", name, hex.EncodeToString(suffix))), nil
+}
+
+// MoveFiles moves files and directories into a directory, keeping their
+// names, and reports the outcome for each
+func (f *FileService) MoveFiles(sessionID string, filePaths []string, destDir string, overwrite bool) ([]models.MoveResult, error) {
+	session, err := f.sessionService.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := session.SFTPClient.Stat(destDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat destination: %w", err)
+	}
+	if !stat.IsDir() {
+		return nil, models.ErrNotDirectory
+	}
+
+	results := make([]models.MoveResult, 0, len(filePaths))
+	for _, filePath := range filePaths {
+		result := models.MoveResult{
+			Source:      filePath,
+			Destination: path.Join(destDir, path.Base(filePath)),
+		}
+		if err := f.RenameFile(sessionID, filePath, result.Destination, overwrite); err != nil {
+			result.Replaceable = errors.Is(err, models.ErrFileExists)
+			result.Conflict = result.Replaceable || errors.Is(err, models.ErrDirectoryExists)
+			result.Error = UserError(err, models.ErrOperationFailed).Error()
+		} else {
+			result.Success = true
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
 // PreviewFile gets file content for preview
 func (f *FileService) PreviewFile(sessionID, filePath string, maxSize int64) (string, string, error) {
 	session, err := f.sessionService.GetSession(sessionID)
@@ -218,7 +344,7 @@ func (f *FileService) PreviewFile(sessionID, filePath string, maxSize int64) (st
 	}
 
 	if stat.Size() > maxSize {
-		return "", "", fmt.Errorf("file too large for preview")
+		return "", "", models.ErrPreviewTooLarge
 	}
 
 	// Open and read file
@@ -229,6 +355,7 @@ func (f *FileService) PreviewFile(sessionID, filePath string, maxSize int64) (st
 	defer file.Close()
 
 	content, err := io.ReadAll(file)
+	session.AddTransferred(int64(len(content)))
 	if err != nil {
 		return "", "", fmt.Errorf("failed to read file: %w", err)
 	}
@@ -249,10 +376,12 @@ func (f *FileService) UploadFile(sessionID, destPath string, src io.Reader, over
 	// Check if file exists
 	if !overwrite {
 		if _, err := session.SFTPClient.Stat(destPath); err == nil {
-			return fmt.Errorf("file already exists")
+			return models.ErrFileExists
 		}
 	}
 
+	defer session.BeginOperation("upload " + destPath)()
+
 	// Create destination file
 	dstFile, err := session.SFTPClient.Create(destPath)
 	if err != nil {
@@ -261,7 +390,7 @@ func (f *FileService) UploadFile(sessionID, destPath string, src io.Reader, over
 	defer dstFile.Close()
 
 	// Copy content
-	_, err = io.Copy(dstFile, src)
+	_, err = io.Copy(dstFile, &countingReader{Reader: src, session: session})
 	if err != nil {
 		return fmt.Errorf("failed to upload file: %w", err)
 	}
@@ -338,6 +467,7 @@ func (f *FileService) DownloadMultiple(sessionID string, filePaths []string, w i
 	if err != nil {
 		return fmt.Errorf("session not found: %w", err)
 	}
+	defer session.BeginOperation(fmt.Sprintf("download %d items as ZIP", len(filePaths)))()
 
 	// Create ZIP writer
 	zipWriter := zip.NewWriter(w)
@@ -390,7 +520,8 @@ func (f *FileService) addFileToZip(session *models.Session, zipWriter *zip.Write
 	}
 
 	// Copy file content to ZIP
-	_, err = io.Copy(zipFile, file)
+	written, err := io.Copy(zipFile, file)
+	session.AddTransferred(written)
 	if err != nil {
 		return fmt.Errorf("failed to copy file %s to zip: %w", filePath, err)
 	}
@@ -438,3 +569,53 @@ func (f *FileService) addDirectoryToZip(session *models.Session, zipWriter *zip.
 
 	return nil
 }
+
+// transferReader streams a download, counting the bytes read towards the
+// session's transfers, and ends the download's operation when closed
+type transferReader struct {
+	io.ReadCloser
+	session *models.Session
+	end     func()
+}
+
+func (t *transferReader) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	t.session.AddTransferred(int64(n))
+	return n, err
+}
+
+// WriteTo keeps the concurrent reads of SFTP files available to io.Copy
+func (t *transferReader) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(&countingWriter{Writer: w, session: t.session}, t.ReadCloser)
+}
+
+func (t *transferReader) Close() error {
+	t.end()
+	return t.ReadCloser.Close()
+}
+
+// countingReader counts the bytes read through it towards a session's
+// transfers
+type countingReader struct {
+	io.Reader
+	session *models.Session
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.session.AddTransferred(int64(n))
+	return n, err
+}
+
+// countingWriter counts the bytes written through it towards a session's
+// transfers
+type countingWriter struct {
+	io.Writer
+	session *models.Session
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.Writer.Write(p)
+	c.session.AddTransferred(int64(n))
+	return n, err
+}
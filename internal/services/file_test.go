@@ -0,0 +1,204 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+// failingRename refuses plain renames of one path, as a server does when
+// the move is not permitted
+type failingRename struct {
+	sftp.FileCmder
+	path string
+}
+
+func (f failingRename) Filecmd(r *sftp.Request) error {
+	if r.Method == "Rename" && r.Filepath == f.path {
+		return os.ErrPermission
+	}
+	return f.FileCmder.Filecmd(r)
+}
+
+// startTestSFTP serves an in-memory file system and returns a client
+// connected to it. Without posixRename the server does not offer the
+// extension, like older OpenSSH releases and many appliances.
+func startTestSFTP(t *testing.T, posixRename bool, failRenameOf string) *sftp.Client {
+	t.Helper()
+
+	handlers := sftp.InMemHandler()
+	if failRenameOf != "" {
+		handlers.FileCmd = failingRename{FileCmder: handlers.FileCmd, path: failRenameOf}
+	}
+
+	serverConn, clientConn := net.Pipe()
+	server := sftp.NewRequestServer(serverConn, handlers)
+	go server.Serve()
+
+	if !posixRename {
+		if err := sftp.SetSFTPExtensions("statvfs@openssh.com"); err != nil {
+			t.Fatalf("SetSFTPExtensions: %v", err)
+		}
+		// The extensions are only sent when the client connects
+		defer sftp.SetSFTPExtensions("hardlink@openssh.com", posixRenameExtension, "statvfs@openssh.com")
+	}
+
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatalf("NewClientPipe: %v", err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client
+}
+
+// writeTestFile creates a file with content on the server
+func writeTestFile(t *testing.T, client *sftp.Client, filePath, content string) {
+	t.Helper()
+
+	file, err := client.Create(filePath)
+	if err != nil {
+		t.Fatalf("Create %s: %v", filePath, err)
+	}
+	defer file.Close()
+	if _, err := file.Write([]byte(content)); err != nil {
+		t.Fatalf("Write %s: %v", filePath, err)
+	}
+}
+
+// readTestFile returns the content of a file on the server
+func readTestFile(t *testing.T, client *sftp.Client, filePath string) string {
+	t.Helper()
+
+	file, err := client.Open(filePath)
+	if err != nil {
+		t.Fatalf("Open %s: %v", filePath, err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("Read %s: %v", filePath, err)
+	}
+	return string(data)
+}
+
+func TestRenameFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		from, to    string
+		overwrite   bool
+		posixRename bool
+		// failRename makes the server refuse to move from
+		failRename bool
+		wantErr    error
+	}{
+		{name: "free name", from: "/report.txt", to: "/renamed.txt", posixRename: true},
+		{name: "free name without posix-rename", from: "/report.txt", to: "/renamed.txt"},
+		{name: "same path", from: "/report.txt", to: "/./report.txt", wantErr: models.ErrSamePath},
+		{name: "into itself", from: "/docs", to: "/docs/archive/docs", wantErr: models.ErrMoveIntoItself},
+		{name: "missing source", from: "/missing.txt", to: "/renamed.txt", wantErr: os.ErrNotExist},
+		{name: "existing file without overwrite", from: "/report.txt", to: "/existing.txt", posixRename: true, wantErr: models.ErrFileExists},
+		{name: "existing directory", from: "/report.txt", to: "/docs", overwrite: true, posixRename: true, wantErr: models.ErrDirectoryExists},
+		{name: "overwrite with posix-rename", from: "/report.txt", to: "/existing.txt", overwrite: true, posixRename: true},
+		{name: "overwrite sets the file aside", from: "/report.txt", to: "/existing.txt", overwrite: true},
+		{name: "failed overwrite restores the file", from: "/report.txt", to: "/existing.txt", overwrite: true, failRename: true, wantErr: errAny},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failRenameOf := ""
+			if tt.failRename {
+				failRenameOf = tt.from
+			}
+			client := startTestSFTP(t, tt.posixRename, failRenameOf)
+			writeTestFile(t, client, "/report.txt", "new")
+			writeTestFile(t, client, "/existing.txt", "old")
+			if err := client.Mkdir("/docs"); err != nil {
+				t.Fatalf("Mkdir: %v", err)
+			}
+
+			sessions := newTestSessionService(t, config.DefaultConfig())
+			sessions.store.SaveSession(&models.Session{ID: "conn-1", SFTPClient: client, LastAccess: time.Now(), IsActive: true})
+			files := NewFileService(sessions)
+
+			err := files.RenameFile("conn-1", tt.from, tt.to, tt.overwrite)
+			switch {
+			case tt.wantErr == errAny:
+				if err == nil {
+					t.Fatal("RenameFile succeeded, want an error")
+				}
+			case !errors.Is(err, tt.wantErr):
+				t.Fatalf("RenameFile error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr == nil {
+				if got := readTestFile(t, client, tt.to); got != "new" {
+					t.Errorf("%s = %q, want the moved file", tt.to, got)
+				}
+				if _, err := client.Lstat(tt.from); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("%s still exists after the move", tt.from)
+				}
+			} else if got := readTestFile(t, client, "/existing.txt"); got != "old" {
+				t.Errorf("/existing.txt = %q, want it untouched", got)
+			}
+
+			entries, err := client.ReadDir("/")
+			if err != nil {
+				t.Fatalf("ReadDir: %v", err)
+			}
+			for _, entry := range entries {
+				if strings.HasPrefix(entry.Name(), ".") {
+					t.Errorf("set-aside file %s was left behind", entry.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestSetAsidePath(t *testing.T) {
+	tests := []struct {
+		filePath string
+		wantDir  string
+		wantName *regexp.Regexp
+	}{
+		{filePath: "/srv/data/report.txt", wantDir: "/srv/data", wantName: regexp.MustCompile(`^\.report\.txt\.replaced-[0-9a-f]{12}$`)},
+		{filePath: "/report.txt", wantDir: "/", wantName: regexp.MustCompile(`^\.report\.txt\.replaced-[0-9a-f]{12}$`)},
+		{filePath: "relative/.env", wantDir: "relative", wantName: regexp.MustCompile(`^\.\.env\.replaced-[0-9a-f]{12}$`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filePath, func(t *testing.T) {
+			got, err := setAsidePath(tt.filePath)
+			if err != nil {
+				t.Fatalf("setAsidePath: %v", err)
+			}
+			if dir := path.Dir(got); dir != tt.wantDir {
+				t.Errorf("setAsidePath = %q, want it in %q", got, tt.wantDir)
+			}
+			if !tt.wantName.MatchString(path.Base(got)) {
+				t.Errorf("setAsidePath = %q, want a name matching %s", got, tt.wantName)
+			}
+
+			again, err := setAsidePath(tt.filePath)
+			if err != nil {
+				t.Fatalf("setAsidePath: %v", err)
+			}
+			if again == got {
+				t.Errorf("setAsidePath returned %q twice", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"sftp-gui/internal/models"
+)
+
+// flashLifetime is how long a flash message waits for the browser to load
+// the page that shows it
+const flashLifetime = 5 * time.Minute
+
+// maxFlashes bounds the messages kept for one browser
+const maxFlashes = 10
+
+// FlashService keeps messages for the next page a browser loads, so that
+// redirects need not carry them in the URL. Messages are keyed by the
+// random ID in the browser's flash cookie and shown only once.
+type FlashService struct {
+	mutex   sync.Mutex
+	flashes map[string][]models.Flash
+}
+
+// NewFlashService creates a new flash message store
+func NewFlashService() *FlashService {
+	return &FlashService{
+		flashes: make(map[string][]models.Flash),
+	}
+}
+
+// Add queues a message for the browser with the given key
+func (f *FlashService) Add(key, level, message string) {
+	if key == "" || message == "" {
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.prune()
+	flashes := append(f.flashes[key], models.Flash{
+		Level:   level,
+		Message: message,
+		Created: time.Now(),
+	})
+	if len(flashes) > maxFlashes {
+		flashes = flashes[len(flashes)-maxFlashes:]
+	}
+	f.flashes[key] = flashes
+}
+
+// Pop returns the messages queued for a browser, oldest first, and forgets
+// them
+func (f *FlashService) Pop(key string) []models.Flash {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	flashes := f.flashes[key]
+	delete(f.flashes, key)
+
+	var current []models.Flash
+	for _, flash := range flashes {
+		if time.Since(flash.Created) < flashLifetime {
+			current = append(current, flash)
+		}
+	}
+	return current
+}
+
+// prune forgets messages that were never shown. The caller must hold the
+// mutex.
+func (f *FlashService) prune() {
+	for key, flashes := range f.flashes {
+		if time.Since(flashes[len(flashes)-1].Created) >= flashLifetime {
+			delete(f.flashes, key)
+		}
+	}
+}
@@ -12,7 +12,8 @@ import (
 	"sftp-gui/internal/models"
 )
 
-// LoginHistoryService manages login history
+// LoginHistoryService manages login history. Entries belong to the
+// application user who connected, and each user sees only their own.
 type LoginHistoryService struct {
 	history []models.LoginHistory
 	mutex   sync.RWMutex
@@ -34,21 +35,26 @@ func NewLoginHistoryService(cfg *config.Config) *LoginHistoryService {
 	return service
 }
 
-// AddLogin adds a login attempt to history
-func (l *LoginHistoryService) AddLogin(host string, port int, username string, success bool) {
-	if !l.config.Session.SaveHistory {
+// AddLogin adds a login attempt to the history of the application user
+// who made it
+func (l *LoginHistoryService) AddLogin(req *models.LoginRequest, success bool) {
+	if !l.config.Session.SaveHistory || req.AppUser == "" {
 		return
 	}
 
+	appUser, host, port, username := req.AppUser, req.Host, req.Port, req.Username
+	jumpHosts := req.JumpHostStrings()
+
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
 	// Check if this combination already exists
 	for i, entry := range l.history {
-		if entry.Host == host && entry.Port == port && entry.Username == username {
+		if entry.AppUser == appUser && entry.Host == host && entry.Port == port && entry.Username == username {
 			// Update existing entry
 			l.history[i].LastUsed = time.Now()
 			l.history[i].Success = success
+			l.history[i].JumpHosts = jumpHosts
 
 			// Move to front (most recent)
 			entry := l.history[i]
@@ -62,43 +68,48 @@ func (l *LoginHistoryService) AddLogin(host string, port int, username string, s
 
 	// Add new entry
 	newEntry := models.LoginHistory{
-		Host:     host,
-		Port:     port,
-		Username: username,
-		LastUsed: time.Now(),
-		Success:  success,
+		AppUser:   appUser,
+		Host:      host,
+		Port:      port,
+		Username:  username,
+		LastUsed:  time.Now(),
+		Success:   success,
+		JumpHosts: jumpHosts,
 	}
 
 	l.history = append([]models.LoginHistory{newEntry}, l.history...)
 
 	// Limit history size
-	if len(l.history) > l.config.Session.MaxHistory {
-		l.history = l.history[:l.config.Session.MaxHistory]
-	}
+	l.history = limitHistory(l.history, l.config.Session.MaxHistory)
 
 	l.saveHistory()
 }
 
-// GetHistory returns the login history
-func (l *LoginHistoryService) GetHistory() []models.LoginHistory {
+// GetHistory returns the login history of an application user
+func (l *LoginHistoryService) GetHistory(appUser string) []models.LoginHistory {
 	l.mutex.RLock()
 	defer l.mutex.RUnlock()
 
 	// Return a copy to prevent external modification
-	history := make([]models.LoginHistory, len(l.history))
-	copy(history, l.history)
+	history := make([]models.LoginHistory, 0)
+	for _, entry := range l.history {
+		if entry.AppUser == appUser {
+			history = append(history, entry)
+		}
+	}
 
 	return history
 }
 
-// GetSuccessfulHistory returns only successful login attempts
-func (l *LoginHistoryService) GetSuccessfulHistory() []models.LoginHistory {
+// GetSuccessfulHistory returns only the successful login attempts of an
+// application user
+func (l *LoginHistoryService) GetSuccessfulHistory(appUser string) []models.LoginHistory {
 	l.mutex.RLock()
 	defer l.mutex.RUnlock()
 
 	var successful []models.LoginHistory
 	for _, entry := range l.history {
-		if entry.Success {
+		if entry.AppUser == appUser && entry.Success {
 			successful = append(successful, entry)
 		}
 	}
@@ -106,22 +117,29 @@ func (l *LoginHistoryService) GetSuccessfulHistory() []models.LoginHistory {
 	return successful
 }
 
-// ClearHistory clears all login history
-func (l *LoginHistoryService) ClearHistory() error {
+// ClearHistory clears the login history of an application user
+func (l *LoginHistoryService) ClearHistory(appUser string) error {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
-	l.history = make([]models.LoginHistory, 0)
+	kept := make([]models.LoginHistory, 0, len(l.history))
+	for _, entry := range l.history {
+		if entry.AppUser != appUser {
+			kept = append(kept, entry)
+		}
+	}
+	l.history = kept
 	return l.saveHistory()
 }
 
-// RemoveEntry removes a specific entry from history
-func (l *LoginHistoryService) RemoveEntry(host string, port int, username string) {
+// RemoveEntry removes a specific entry from the history of an application
+// user
+func (l *LoginHistoryService) RemoveEntry(appUser, host string, port int, username string) {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
 
 	for i, entry := range l.history {
-		if entry.Host == host && entry.Port == port && entry.Username == username {
+		if entry.AppUser == appUser && entry.Host == host && entry.Port == port && entry.Username == username {
 			l.history = append(l.history[:i], l.history[i+1:]...)
 			l.saveHistory()
 			return
@@ -143,25 +161,44 @@ func (l *LoginHistoryService) loadHistory() error {
 		return fmt.Errorf("failed to read history file: %w", err)
 	}
 
-	var history []models.LoginHistory
-	if err := json.Unmarshal(data, &history); err != nil {
+	var stored []models.LoginHistory
+	if err := json.Unmarshal(data, &stored); err != nil {
 		return fmt.Errorf("failed to parse history file: %w", err)
 	}
 
+	// Entries recorded before history was kept per user cannot be shown
+	// to anyone
+	history := make([]models.LoginHistory, 0, len(stored))
+	for _, entry := range stored {
+		if entry.AppUser != "" {
+			history = append(history, entry)
+		}
+	}
+
 	// Sort by last used (most recent first)
 	sort.Slice(history, func(i, j int) bool {
 		return history[i].LastUsed.After(history[j].LastUsed)
 	})
 
 	// Limit to max history size
-	if len(history) > l.config.Session.MaxHistory {
-		history = history[:l.config.Session.MaxHistory]
-	}
-
-	l.history = history
+	l.history = limitHistory(history, l.config.Session.MaxHistory)
 	return nil
 }
 
+// limitHistory keeps the max most recent entries of each user in a history
+// sorted by last use
+func limitHistory(history []models.LoginHistory, max int) []models.LoginHistory {
+	counts := make(map[string]int)
+	kept := history[:0]
+	for _, entry := range history {
+		if counts[entry.AppUser] < max {
+			counts[entry.AppUser]++
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
 // saveHistory saves history to file
 func (l *LoginHistoryService) saveHistory() error {
 	if l.config.Session.HistoryFile == "" {
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+// HostKeyService verifies SSH host keys against a known_hosts file
+type HostKeyService struct {
+	config   *config.Config
+	mutex    sync.Mutex
+	probeKey ssh.PublicKey
+}
+
+// NewHostKeyService creates a new host key service
+func NewHostKeyService(cfg *config.Config) *HostKeyService {
+	service := &HostKeyService{
+		config: cfg,
+	}
+
+	// The probe key is never presented by a real server; checking it against
+	// the database reveals which keys are recorded for a host.
+	if pub, _, err := ed25519.GenerateKey(rand.Reader); err == nil {
+		service.probeKey, _ = ssh.NewPublicKey(pub)
+	}
+
+	return service
+}
+
+// Callback returns a host key callback enforcing the configured mode.
+// accepted holds fingerprints of unknown keys the user confirmed in prompt
+// mode; it is ignored in the other modes.
+func (h *HostKeyService) Callback(accepted []string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		check, err := h.load()
+		if err != nil {
+			return err
+		}
+
+		err = check(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+
+		hostKeyErr := &models.HostKeyError{
+			Host:        hostname,
+			KeyType:     key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(key),
+			Changed:     len(keyErr.Want) > 0,
+		}
+
+		// A key that differs from the recorded one is never accepted
+		if hostKeyErr.Changed {
+			return hostKeyErr
+		}
+
+		switch h.config.Security.HostKeyMode {
+		case config.HostKeyModeTOFU:
+			return h.Add(hostname, key)
+		case config.HostKeyModePrompt:
+			for _, fingerprint := range accepted {
+				if fingerprint == hostKeyErr.Fingerprint {
+					return h.Add(hostname, key)
+				}
+			}
+		}
+
+		return hostKeyErr
+	}
+}
+
+// Status reports whether a host key is "known", "unknown" or "changed"
+// compared to known_hosts, without recording it
+func (h *HostKeyService) Status(address string, remote net.Addr, key ssh.PublicKey) string {
+	check, err := h.load()
+	if err != nil {
+		return "unknown"
+	}
+
+	var keyErr *knownhosts.KeyError
+	switch err := check(address, remote, key); {
+	case err == nil:
+		return "known"
+	case errors.As(err, &keyErr) && len(keyErr.Want) > 0:
+		return "changed"
+	default:
+		return "unknown"
+	}
+}
+
+// KnownKeyAlgorithms returns the host key algorithms matching the keys
+// recorded for an address, so the server is asked for a key we can verify.
+// It returns nil for unknown hosts.
+func (h *HostKeyService) KnownKeyAlgorithms(address string) []string {
+	if h.probeKey == nil {
+		return nil
+	}
+
+	check, err := h.load()
+	if err != nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if err := check(address, &net.TCPAddr{IP: net.IPv4zero}, h.probeKey); !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		switch known.Key.Type() {
+		case ssh.KeyAlgoRSA:
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algorithms = append(algorithms, known.Key.Type())
+		}
+	}
+
+	return algorithms
+}
+
+// Add records a host key in the known_hosts file
+func (h *HostKeyService) Add(address string, key ssh.PublicKey) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	file, err := os.OpenFile(h.config.Security.KnownHostsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts file: %w", err)
+	}
+	defer file.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(address)}, key)
+	if _, err := fmt.Fprintln(file, line); err != nil {
+		return fmt.Errorf("failed to write known_hosts file: %w", err)
+	}
+
+	return nil
+}
+
+// load parses the known_hosts file, creating it if it does not exist yet
+func (h *HostKeyService) load() (ssh.HostKeyCallback, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	path := h.config.Security.KnownHostsFile
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if dir := filepath.Dir(path); dir != "." {
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				return nil, fmt.Errorf("failed to create known_hosts directory: %w", err)
+			}
+		}
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			return nil, fmt.Errorf("failed to create known_hosts file: %w", err)
+		}
+	}
+
+	check, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts file: %w", err)
+	}
+
+	return check, nil
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("NewPublicKey: %v", err)
+	}
+	return key
+}
+
+// newTestHostKeyService returns a service in mode whose known_hosts file
+// records known for server.example:2222
+func newTestHostKeyService(t *testing.T, mode string, known ssh.PublicKey) *HostKeyService {
+	t.Helper()
+
+	cfg := config.DefaultConfig()
+	cfg.Security.HostKeyMode = mode
+	cfg.Security.KnownHostsFile = filepath.Join(t.TempDir(), "known_hosts")
+
+	hostKeys := NewHostKeyService(cfg)
+	if err := hostKeys.Add("server.example:2222", known); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	return hostKeys
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	const address = "server.example:2222"
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 2222}
+	known, other := newHostKey(t), newHostKey(t)
+
+	tests := []struct {
+		name        string
+		mode        string
+		hostname    string
+		key         ssh.PublicKey
+		accept      bool
+		wantErr     bool
+		wantChanged bool
+		wantKnown   bool
+	}{
+		{name: "strict known key", mode: config.HostKeyModeStrict, hostname: address, key: known, wantKnown: true},
+		{name: "strict unknown host", mode: config.HostKeyModeStrict, hostname: "new.example:22", key: other, wantErr: true},
+		{name: "strict ignores accepted keys", mode: config.HostKeyModeStrict, hostname: "new.example:22", key: other, accept: true, wantErr: true},
+		{name: "strict changed key", mode: config.HostKeyModeStrict, hostname: address, key: other, wantErr: true, wantChanged: true},
+		{name: "tofu records an unknown host", mode: config.HostKeyModeTOFU, hostname: "new.example:22", key: other, wantKnown: true},
+		{name: "tofu changed key", mode: config.HostKeyModeTOFU, hostname: address, key: other, wantErr: true, wantChanged: true},
+		{name: "prompt unknown host", mode: config.HostKeyModePrompt, hostname: "new.example:22", key: other, wantErr: true},
+		{name: "prompt records an accepted key", mode: config.HostKeyModePrompt, hostname: "new.example:22", key: other, accept: true, wantKnown: true},
+		{name: "prompt never accepts a changed key", mode: config.HostKeyModePrompt, hostname: address, key: other, accept: true, wantErr: true, wantChanged: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostKeys := newTestHostKeyService(t, tt.mode, known)
+
+			var accepted []string
+			if tt.accept {
+				accepted = []string{ssh.FingerprintSHA256(tt.key)}
+			}
+
+			err := hostKeys.Callback(accepted)(tt.hostname, remote, tt.key)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("Callback: %v", err)
+				}
+			} else {
+				var hostKeyErr *models.HostKeyError
+				if !errors.As(err, &hostKeyErr) {
+					t.Fatalf("error = %v, want a host key error", err)
+				}
+				if hostKeyErr.Changed != tt.wantChanged {
+					t.Errorf("Changed = %v, want %v", hostKeyErr.Changed, tt.wantChanged)
+				}
+				if hostKeyErr.Fingerprint != ssh.FingerprintSHA256(tt.key) {
+					t.Errorf("Fingerprint = %q, want the presented key's", hostKeyErr.Fingerprint)
+				}
+			}
+
+			status := hostKeys.Status(tt.hostname, remote, tt.key)
+			if got := status == "known"; got != tt.wantKnown {
+				t.Errorf("status afterwards = %q, want known: %v", status, tt.wantKnown)
+			}
+		})
+	}
+}
+
+func TestHostKeyStatus(t *testing.T) {
+	known, other := newHostKey(t), newHostKey(t)
+	hostKeys := newTestHostKeyService(t, config.HostKeyModeStrict, known)
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 2222}
+
+	tests := []struct {
+		name          string
+		address       string
+		key           ssh.PublicKey
+		want          string
+		wantAlgorithm []string
+	}{
+		{name: "recorded key", address: "server.example:2222", key: known, want: "known", wantAlgorithm: []string{ssh.KeyAlgoED25519}},
+		{name: "different key", address: "server.example:2222", key: other, want: "changed", wantAlgorithm: []string{ssh.KeyAlgoED25519}},
+		{name: "other port", address: "server.example:22", key: known, want: "unknown"},
+		{name: "unknown host", address: "new.example:2222", key: known, want: "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hostKeys.Status(tt.address, remote, tt.key); got != tt.want {
+				t.Errorf("Status = %q, want %q", got, tt.want)
+			}
+
+			algorithms := hostKeys.KnownKeyAlgorithms(tt.address)
+			if len(algorithms) != len(tt.wantAlgorithm) || (len(algorithms) > 0 && algorithms[0] != tt.wantAlgorithm[0]) {
+				t.Errorf("KnownKeyAlgorithms = %v, want %v", algorithms, tt.wantAlgorithm)
+			}
+		})
+	}
+}
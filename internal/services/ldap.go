@@ -0,0 +1,188 @@
+package services
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+// LDAPSource marks accounts created through LDAP sign-in
+const LDAPSource = "ldap"
+
+// LDAPIdentity is the account a successful LDAP bind maps to
+type LDAPIdentity struct {
+	Username string
+	Role     string
+	DN       string
+	Groups   []string
+}
+
+// LDAPService checks passwords by binding to an LDAP directory and maps
+// the user's groups to roles
+type LDAPService struct {
+	config *config.Config
+}
+
+// NewLDAPService creates a new LDAP service
+func NewLDAPService(cfg *config.Config) *LDAPService {
+	return &LDAPService{config: cfg}
+}
+
+// Enabled reports whether LDAP sign-in is configured
+func (l *LDAPService) Enabled() bool {
+	return l.config.Security.LDAP.Enabled()
+}
+
+// Authenticate binds as the user with the given password. With a service
+// account the user's DN is searched first; otherwise it is built from the
+// DN template. Wrong credentials yield ErrInvalidCredentials.
+func (l *LDAPService) Authenticate(username, password string) (*LDAPIdentity, error) {
+	cfg := l.config.Security.LDAP
+	if username == "" || password == "" {
+		return nil, models.ErrInvalidCredentials
+	}
+
+	conn, err := dialLDAP(cfg.URL, cfg.StartTLS, &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var userDN string
+	var entry *ldapEntry
+	if cfg.BindDN != "" {
+		if err := conn.Bind(cfg.BindDN, cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("LDAP service account bind failed: %w", err)
+		}
+
+		filter := strings.ReplaceAll(cfg.UserFilter, "{username}", escapeLDAPFilterValue(username))
+		entries, err := conn.Search(cfg.BaseDN, ldapScopeSubtree, filter, l.userAttributes())
+		if err != nil {
+			return nil, fmt.Errorf("LDAP user search failed: %w", err)
+		}
+		if len(entries) != 1 {
+			// Unknown or ambiguous names must not reveal which
+			return nil, models.ErrInvalidCredentials
+		}
+		entry = entries[0]
+		userDN = entry.DN
+	} else {
+		userDN = strings.ReplaceAll(cfg.UserDNTemplate, "{username}", escapeLDAPDNValue(username))
+	}
+
+	if err := conn.Bind(userDN, password); err != nil {
+		var resultErr *ldapResultError
+		if errors.As(err, &resultErr) && resultErr.Code == ldapResultBadCreds {
+			return nil, models.ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("LDAP bind failed: %w", err)
+	}
+
+	// Read the user's own entry for its groups after a direct bind
+	if entry == nil && cfg.GroupAttribute != "" {
+		if entries, err := conn.Search(userDN, ldapScopeBaseObject, "(objectClass=*)", l.userAttributes()); err == nil && len(entries) == 1 {
+			entry = entries[0]
+		}
+	}
+
+	groups, err := l.groups(conn, userDN, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	// Directories match names without regard to case, so every spelling
+	// of a name signs in to the same account
+	return &LDAPIdentity{
+		Username: strings.ToLower(username),
+		Role:     l.role(groups),
+		DN:       userDN,
+		Groups:   groups,
+	}, nil
+}
+
+// groups collects the DNs of the user's groups from the group attribute
+// and the optional group search
+func (l *LDAPService) groups(conn *ldapConn, userDN string, entry *ldapEntry) ([]string, error) {
+	cfg := l.config.Security.LDAP
+
+	var groups []string
+	if entry != nil && cfg.GroupAttribute != "" {
+		groups = append(groups, entry.Values(cfg.GroupAttribute)...)
+	}
+
+	if cfg.GroupFilter != "" {
+		filter := strings.ReplaceAll(cfg.GroupFilter, "{dn}", escapeLDAPFilterValue(userDN))
+		entries, err := conn.Search(cfg.GroupBaseDN, ldapScopeSubtree, filter, []string{"cn"})
+		if err != nil {
+			return nil, fmt.Errorf("LDAP group search failed: %w", err)
+		}
+		for _, group := range entries {
+			if !containsString(groups, group.DN) {
+				groups = append(groups, group.DN)
+			}
+		}
+	}
+
+	return groups, nil
+}
+
+// role maps the user's group DNs to a role. Only full DNs match, so a group
+// of the same name elsewhere in the directory grants nothing. DNs are
+// compared without regard to case or spacing, as LDAP does.
+func (l *LDAPService) role(groups []string) string {
+	mapping := make(map[string]string, len(l.config.Security.LDAP.GroupMapping))
+	for group, role := range l.config.Security.LDAP.GroupMapping {
+		mapping[normalizeDN(group)] = role
+	}
+
+	names := make([]string, 0, len(groups))
+	for _, dn := range groups {
+		names = append(names, normalizeDN(dn))
+	}
+
+	return mapRole(l.config, names, mapping)
+}
+
+// userAttributes lists the attributes read from user entries
+func (l *LDAPService) userAttributes() []string {
+	if attr := l.config.Security.LDAP.GroupAttribute; attr != "" {
+		return []string{attr}
+	}
+	return []string{"1.1"} // no attributes
+}
+
+// normalizeDN returns a DN for comparison: lowercased, and without the
+// spaces around separators that some directories write and others do not.
+// Escaped characters stay escaped.
+func normalizeDN(dn string) string {
+	trim := func(part string) string {
+		part = strings.TrimLeft(part, " ")
+		for strings.HasSuffix(part, " ") && !strings.HasSuffix(part, `\ `) {
+			part = part[:len(part)-1]
+		}
+		return part
+	}
+
+	var normalized strings.Builder
+	start := 0
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			i++
+		case ',', '+', '=':
+			normalized.WriteString(trim(dn[start:i]))
+			normalized.WriteByte(dn[i])
+			start = i + 1
+		}
+	}
+	normalized.WriteString(trim(dn[start:]))
+
+	return strings.ToLower(normalized.String())
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+// fakeDirectory is an LDAP directory of people that answers binds,
+// equality searches for uid and base object reads
+type fakeDirectory struct {
+	passwords map[string]string
+	entries   map[string]map[string][]string
+
+	mutex   sync.Mutex
+	filters []string
+}
+
+const (
+	testBindDN   = "cn=service,dc=example,dc=com"
+	testPeopleDN = "ou=people,dc=example,dc=com"
+	testAliceDN  = "uid=alice,ou=people,dc=example,dc=com"
+)
+
+func newFakeDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		passwords: map[string]string{
+			testBindDN:  "service-password",
+			testAliceDN: "alice-password",
+		},
+		entries: map[string]map[string][]string{
+			testAliceDN: {"memberOf": {"cn=SFTP-Admins,ou=groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"}},
+		},
+	}
+}
+
+func (d *fakeDirectory) handle(id int, op berPart) []byte {
+	fields, err := splitBER(op.content)
+	if err != nil {
+		return nil
+	}
+
+	switch op.tag {
+	case ldapBindRequest:
+		dn, password := string(fields[1].content), string(fields[2].content)
+		if want, ok := d.passwords[dn]; !ok || want != password {
+			return ldapMessage(id, ldapResultOp(ldapBindResponse, ldapResultBadCreds, "invalid credentials"))
+		}
+		return ldapMessage(id, ldapResultOp(ldapBindResponse, ldapResultSuccess, ""))
+
+	case ldapSearchRequest:
+		base, scope, filter := string(fields[0].content), parseBERInt(fields[1].content), fields[6]
+
+		var response []byte
+		switch {
+		case scope == ldapScopeBaseObject && filter.tag == ldapFilterPresent:
+			if attributes, ok := d.entries[base]; ok {
+				response = ldapMessage(id, ldapEntryOp(base, attributes))
+			}
+		case filter.tag == ldapFilterEquality:
+			assertion, _ := splitBER(filter.content)
+			attr, value := string(assertion[0].content), string(assertion[1].content)
+			d.mutex.Lock()
+			d.filters = append(d.filters, attr+"="+value)
+			d.mutex.Unlock()
+
+			for dn, attributes := range d.entries {
+				if strings.EqualFold(attr, "uid") && strings.EqualFold(dn, "uid="+value+","+base) {
+					response = append(response, ldapMessage(id, ldapEntryOp(dn, attributes))...)
+				}
+			}
+		default:
+			return ldapMessage(id, ldapResultOp(ldapSearchDone, 53, "unwilling to perform"))
+		}
+		return append(response, ldapMessage(id, ldapResultOp(ldapSearchDone, ldapResultSuccess, ""))...)
+	}
+
+	return nil
+}
+
+// newTestLDAPService returns a service for the directory; without a
+// service account users bind directly with a DN built from their name
+func newTestLDAPService(t *testing.T, directory *fakeDirectory, serviceAccount bool) *LDAPService {
+	t.Helper()
+
+	cfg := config.DefaultConfig()
+	cfg.Security.LDAP = config.LDAPConfig{
+		URL:            startFakeLDAP(t, directory.handle),
+		Timeout:        2 * time.Second,
+		GroupAttribute: "memberOf",
+		GroupMapping:   map[string]string{"cn=sftp-admins,ou=groups,dc=example,dc=com": config.RoleAdmin},
+	}
+	if serviceAccount {
+		cfg.Security.LDAP.BindDN = testBindDN
+		cfg.Security.LDAP.BindPassword = "service-password"
+		cfg.Security.LDAP.BaseDN = testPeopleDN
+		cfg.Security.LDAP.UserFilter = "(uid={username})"
+	} else {
+		cfg.Security.LDAP.UserDNTemplate = "uid={username}," + testPeopleDN
+	}
+	return NewLDAPService(cfg)
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	for _, serviceAccount := range []bool{true, false} {
+		name := "direct bind"
+		if serviceAccount {
+			name = "service account"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			l := newTestLDAPService(t, newFakeDirectory(), serviceAccount)
+
+			identity, err := l.Authenticate("alice", "alice-password")
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if identity.Username != "alice" || identity.DN != testAliceDN {
+				t.Errorf("identity = %+v", identity)
+			}
+			if identity.Role != config.RoleAdmin {
+				t.Errorf("role = %q, want %q from the group mapping", identity.Role, config.RoleAdmin)
+			}
+
+			if _, err := l.Authenticate("alice", "wrong-password"); !errors.Is(err, models.ErrInvalidCredentials) {
+				t.Errorf("wrong password: error = %v, want ErrInvalidCredentials", err)
+			}
+			if _, err := l.Authenticate("mallory", "alice-password"); !errors.Is(err, models.ErrInvalidCredentials) {
+				t.Errorf("unknown user: error = %v, want ErrInvalidCredentials", err)
+			}
+			if _, err := l.Authenticate("alice", ""); !errors.Is(err, models.ErrInvalidCredentials) {
+				t.Errorf("empty password: error = %v, want ErrInvalidCredentials", err)
+			}
+		})
+	}
+}
+
+func TestLDAPAuthenticateLowercasesUsername(t *testing.T) {
+	l := newTestLDAPService(t, newFakeDirectory(), true)
+
+	identity, err := l.Authenticate("ALice", "alice-password")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if identity.Username != "alice" {
+		t.Errorf("username = %q, want alice", identity.Username)
+	}
+}
+
+func TestLDAPAuthenticateEscapesUsername(t *testing.T) {
+	directory := newFakeDirectory()
+	l := newTestLDAPService(t, directory, true)
+
+	for _, username := range []string{"*", "alice)(uid=*", "al*", `alice\`} {
+		if _, err := l.Authenticate(username, "alice-password"); !errors.Is(err, models.ErrInvalidCredentials) {
+			t.Errorf("Authenticate(%q): error = %v, want ErrInvalidCredentials", username, err)
+		}
+	}
+
+	// Each name reached the directory as a literal value, never as a
+	// wildcard or an extra filter term
+	want := []string{"uid=*", "uid=alice)(uid=*", "uid=al*", `uid=alice\`}
+	if strings.Join(directory.filters, "\n") != strings.Join(want, "\n") {
+		t.Errorf("directory searched for %q, want %q", directory.filters, want)
+	}
+}
+
+func TestLDAPAuthenticateServiceAccountFailure(t *testing.T) {
+	directory := newFakeDirectory()
+	directory.passwords[testBindDN] = "rotated"
+	l := newTestLDAPService(t, directory, true)
+
+	_, err := l.Authenticate("alice", "alice-password")
+	if err == nil || errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("error = %v, want a service account error", err)
+	}
+}
+
+func TestLDAPRole(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Security.LDAP.GroupMapping = map[string]string{
+		"CN=SFTP Admins,OU=Groups,DC=example,DC=com":  config.RoleAdmin,
+		"cn=editors, ou=groups, dc=example, dc=com":   config.RoleEditor,
+		`cn=Smith\, John,ou=groups,dc=example,dc=com`: config.RoleUploader,
+	}
+	l := NewLDAPService(cfg)
+
+	tests := []struct {
+		name   string
+		groups []string
+		want   string
+	}{
+		{name: "no groups", want: config.RoleViewer},
+		{name: "full DN", groups: []string{"CN=SFTP Admins,OU=Groups,DC=example,DC=com"}, want: config.RoleAdmin},
+		{name: "case and spacing", groups: []string{"cn=sftp admins, ou=groups,dc=EXAMPLE,dc=com"}, want: config.RoleAdmin},
+		{name: "spacing in the mapping", groups: []string{"cn=Editors,ou=Groups,dc=example,dc=com"}, want: config.RoleEditor},
+		{name: "same name in another OU", groups: []string{"cn=SFTP Admins,ou=Guests,dc=example,dc=com"}, want: config.RoleViewer},
+		{name: "common name alone", groups: []string{"SFTP Admins"}, want: config.RoleViewer},
+		{name: "escaped comma", groups: []string{`CN=Smith\, John,OU=Groups,DC=example,DC=com`}, want: config.RoleUploader},
+		{name: "escaped comma split", groups: []string{"cn=Smith,ou=groups,dc=example,dc=com"}, want: config.RoleViewer},
+		{name: "first ranked role", groups: []string{"cn=editors,ou=groups,dc=example,dc=com", "cn=sftp admins,ou=groups,dc=example,dc=com"}, want: config.RoleAdmin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := l.role(tt.groups); got != tt.want {
+				t.Errorf("role(%q) = %q, want %q", tt.groups, got, tt.want)
+			}
+		})
+	}
+}
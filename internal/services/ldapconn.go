@@ -0,0 +1,600 @@
+package services
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file implements the small part of LDAPv3 (RFC 4511) needed to sign
+// users in: simple bind, StartTLS and search, encoded with BER. A full
+// client library would add a dependency, and with it the ASN.1 decoder it
+// pulls in, for three operations. Only the client side is needed, every
+// response is read with a size limit and decoded into bounded, tagged
+// parts, and the tests run it against malformed and hostile responses.
+
+// BER tags used by the LDAP messages below
+const (
+	berBoolean     = 0x01
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+
+	ldapBindRequest      = 0x60
+	ldapBindResponse     = 0x61
+	ldapUnbindRequest    = 0x42
+	ldapSearchRequest    = 0x63
+	ldapSearchEntry      = 0x64
+	ldapSearchDone       = 0x65
+	ldapSearchReference  = 0x73
+	ldapExtendedRequest  = 0x77
+	ldapExtendedResponse = 0x78
+
+	ldapAuthSimple     = 0x80
+	ldapExtendedName   = 0x80
+	ldapFilterAnd      = 0xa0
+	ldapFilterOr       = 0xa1
+	ldapFilterNot      = 0xa2
+	ldapFilterEquality = 0xa3
+	ldapFilterPresent  = 0x87
+)
+
+// LDAP protocol values
+const (
+	ldapResultSuccess  = 0
+	ldapResultBadCreds = 49
+
+	ldapScopeBaseObject = 0
+	ldapScopeSubtree    = 2
+
+	ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+	// ldapMaxMessageLength bounds the size of a response we read
+	ldapMaxMessageLength = 1 << 20
+)
+
+// ldapResultError is a non-success LDAP result code
+type ldapResultError struct {
+	Code    int
+	Message string
+}
+
+func (e *ldapResultError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("LDAP result %d: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("LDAP result %d", e.Code)
+}
+
+// ldapEntry is a search result
+type ldapEntry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Values returns the values of an attribute, matching its name without
+// regard to case
+func (e *ldapEntry) Values(name string) []string {
+	for attr, values := range e.Attributes {
+		if strings.EqualFold(attr, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// ldapConn is a synchronous LDAP client connection
+type ldapConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	messageID int
+	timeout   time.Duration
+}
+
+// dialLDAP connects to an ldap:// or ldaps:// URL, upgrading plain
+// connections with StartTLS when requested
+func dialLDAP(rawURL string, startTLS bool, tlsConfig *tls.Config, timeout time.Duration) (*ldapConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL: %w", err)
+	}
+
+	host := u.Host
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, ldapTLSConfig(tlsConfig, u.Hostname()))
+	default:
+		return nil, fmt.Errorf("unsupported LDAP URL scheme: %s", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %w", err)
+	}
+
+	l := &ldapConn{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}
+	if startTLS && u.Scheme == "ldap" {
+		if err := l.startTLS(ldapTLSConfig(tlsConfig, u.Hostname())); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// ldapTLSConfig returns a TLS config that verifies the server name
+func ldapTLSConfig(base *tls.Config, serverName string) *tls.Config {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if base != nil {
+		config = base.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = serverName
+	}
+	return config
+}
+
+// Close unbinds and closes the connection
+func (l *ldapConn) Close() error {
+	l.send(berElement(ldapUnbindRequest))
+	return l.conn.Close()
+}
+
+// Bind performs a simple bind. Empty passwords are refused because servers
+// treat them as an unauthenticated bind that always succeeds.
+func (l *ldapConn) Bind(dn, password string) error {
+	if password == "" {
+		return &ldapResultError{Code: ldapResultBadCreds, Message: "empty password"}
+	}
+
+	response, err := l.roundTrip(berElement(ldapBindRequest,
+		berInt(berInteger, 3),
+		berString(berOctetString, dn),
+		berString(ldapAuthSimple, password),
+	), ldapBindResponse)
+	if err != nil {
+		return err
+	}
+	return ldapResult(response)
+}
+
+// Search runs a search and collects its entries
+func (l *ldapConn) Search(baseDN string, scope int, filter string, attributes []string) ([]*ldapEntry, error) {
+	encodedFilter, err := compileLDAPFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make([][]byte, len(attributes))
+	for i, attr := range attributes {
+		attrs[i] = berString(berOctetString, attr)
+	}
+
+	id, err := l.send(berElement(ldapSearchRequest,
+		berString(berOctetString, baseDN),
+		berInt(berEnumerated, scope),
+		berInt(berEnumerated, 0), // never dereference aliases
+		berInt(berInteger, 100),  // size limit
+		berInt(berInteger, int(l.timeout/time.Second)),
+		[]byte{berBoolean, 1, 0}, // types only: false
+		encodedFilter,
+		berElement(berSequence, attrs...),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*ldapEntry
+	for {
+		tag, content, err := l.receive(id)
+		if err != nil {
+			return nil, err
+		}
+
+		switch tag {
+		case ldapSearchEntry:
+			entry, err := parseLDAPEntry(content)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case ldapSearchReference:
+			// Referrals to other servers are not followed
+		case ldapSearchDone:
+			if err := ldapResult(content); err != nil {
+				return nil, err
+			}
+			return entries, nil
+		default:
+			return nil, fmt.Errorf("unexpected LDAP response tag 0x%02x", tag)
+		}
+	}
+}
+
+// startTLS upgrades the connection with the StartTLS extended operation
+func (l *ldapConn) startTLS(config *tls.Config) error {
+	response, err := l.roundTrip(berElement(ldapExtendedRequest,
+		berString(ldapExtendedName, ldapStartTLSOID),
+	), ldapExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if err := ldapResult(response); err != nil {
+		return fmt.Errorf("StartTLS refused: %w", err)
+	}
+
+	tlsConn := tls.Client(l.conn, config)
+	tlsConn.SetDeadline(time.Now().Add(l.timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("StartTLS handshake failed: %w", err)
+	}
+	l.conn = tlsConn
+	l.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// roundTrip sends a request and reads its single response
+func (l *ldapConn) roundTrip(request []byte, responseTag byte) ([]byte, error) {
+	id, err := l.send(request)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, content, err := l.receive(id)
+	if err != nil {
+		return nil, err
+	}
+	if tag != responseTag {
+		return nil, fmt.Errorf("unexpected LDAP response tag 0x%02x", tag)
+	}
+	return content, nil
+}
+
+// send wraps a protocol operation in an LDAPMessage and writes it
+func (l *ldapConn) send(op []byte) (int, error) {
+	l.messageID++
+	message := berElement(berSequence, berInt(berInteger, l.messageID), op)
+
+	l.conn.SetDeadline(time.Now().Add(l.timeout))
+	if _, err := l.conn.Write(message); err != nil {
+		return 0, fmt.Errorf("LDAP write failed: %w", err)
+	}
+	return l.messageID, nil
+}
+
+// receive reads the next LDAPMessage and returns its protocol operation
+func (l *ldapConn) receive(id int) (byte, []byte, error) {
+	l.conn.SetDeadline(time.Now().Add(l.timeout))
+
+	tag, message, err := readBER(l.reader)
+	if err != nil {
+		return 0, nil, fmt.Errorf("LDAP read failed: %w", err)
+	}
+	if tag != berSequence {
+		return 0, nil, errors.New("malformed LDAP message")
+	}
+
+	parts, err := splitBER(message)
+	if err != nil || len(parts) < 2 {
+		return 0, nil, errors.New("malformed LDAP message")
+	}
+	if messageID := parseBERInt(parts[0].content); messageID != id {
+		return 0, nil, fmt.Errorf("unexpected LDAP message ID %d", messageID)
+	}
+
+	return parts[1].tag, parts[1].content, nil
+}
+
+// ldapResult converts an LDAPResult to an error
+func ldapResult(content []byte) error {
+	parts, err := splitBER(content)
+	if err != nil || len(parts) < 3 {
+		return errors.New("malformed LDAP result")
+	}
+	if code := parseBERInt(parts[0].content); code != ldapResultSuccess {
+		return &ldapResultError{Code: code, Message: string(parts[2].content)}
+	}
+	return nil
+}
+
+// parseLDAPEntry decodes a SearchResultEntry
+func parseLDAPEntry(content []byte) (*ldapEntry, error) {
+	parts, err := splitBER(content)
+	if err != nil || len(parts) != 2 {
+		return nil, errors.New("malformed LDAP search entry")
+	}
+
+	entry := &ldapEntry{DN: string(parts[0].content), Attributes: make(map[string][]string)}
+	attributes, err := splitBER(parts[1].content)
+	if err != nil {
+		return nil, errors.New("malformed LDAP search entry")
+	}
+	for _, attribute := range attributes {
+		fields, err := splitBER(attribute.content)
+		if err != nil || len(fields) != 2 {
+			return nil, errors.New("malformed LDAP attribute")
+		}
+		values, err := splitBER(fields[1].content)
+		if err != nil {
+			return nil, errors.New("malformed LDAP attribute")
+		}
+		name := string(fields[0].content)
+		for _, value := range values {
+			entry.Attributes[name] = append(entry.Attributes[name], string(value.content))
+		}
+	}
+
+	return entry, nil
+}
+
+// compileLDAPFilter encodes an RFC 4515 string filter. Equality, presence
+// and the &, | and ! operators are supported.
+func compileLDAPFilter(filter string) ([]byte, error) {
+	encoded, rest, err := parseLDAPFilter(strings.TrimSpace(filter))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP filter %q: %w", filter, err)
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("invalid LDAP filter %q: trailing characters", filter)
+	}
+	return encoded, nil
+}
+
+// parseLDAPFilter parses one parenthesized filter and returns the rest
+func parseLDAPFilter(filter string) ([]byte, string, error) {
+	if !strings.HasPrefix(filter, "(") {
+		return nil, "", errors.New("expected (")
+	}
+	filter = filter[1:]
+
+	switch {
+	case strings.HasPrefix(filter, "&"), strings.HasPrefix(filter, "|"):
+		tag := byte(ldapFilterAnd)
+		if filter[0] == '|' {
+			tag = ldapFilterOr
+		}
+		filter = filter[1:]
+		var children [][]byte
+		for strings.HasPrefix(filter, "(") {
+			child, rest, err := parseLDAPFilter(filter)
+			if err != nil {
+				return nil, "", err
+			}
+			children = append(children, child)
+			filter = rest
+		}
+		if len(children) == 0 || !strings.HasPrefix(filter, ")") {
+			return nil, "", errors.New("expected ) after filter list")
+		}
+		return berElement(tag, children...), filter[1:], nil
+	case strings.HasPrefix(filter, "!"):
+		child, rest, err := parseLDAPFilter(filter[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", errors.New("expected ) after negated filter")
+		}
+		return berElement(ldapFilterNot, child), rest[1:], nil
+	}
+
+	end := strings.IndexByte(filter, ')')
+	if end < 0 {
+		return nil, "", errors.New("expected )")
+	}
+	item, rest := filter[:end], filter[end+1:]
+
+	eq := strings.IndexByte(item, '=')
+	if eq < 1 {
+		return nil, "", errors.New("expected attribute=value")
+	}
+	attr, value := item[:eq], item[eq+1:]
+	if strings.ContainsAny(attr, "~<>:") {
+		return nil, "", errors.New("only equality and presence filters are supported")
+	}
+	if value == "*" {
+		return berString(ldapFilterPresent, attr), rest, nil
+	}
+	if strings.Contains(value, "*") {
+		return nil, "", errors.New("substring filters are not supported")
+	}
+
+	unescaped, err := unescapeLDAPFilterValue(value)
+	if err != nil {
+		return nil, "", err
+	}
+	return berElement(ldapFilterEquality,
+		berString(berOctetString, attr),
+		berString(berOctetString, unescaped),
+	), rest, nil
+}
+
+// unescapeLDAPFilterValue decodes \XX escapes in a filter value
+func unescapeLDAPFilterValue(value string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", errors.New("truncated escape")
+		}
+		n, err := strconv.ParseUint(value[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", errors.New("invalid escape")
+		}
+		b.WriteByte(byte(n))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// escapeLDAPFilterValue escapes user input for use in a search filter
+func escapeLDAPFilterValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// escapeLDAPDNValue escapes user input for use as an RDN value (RFC 4514)
+func escapeLDAPDNValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case strings.IndexByte(`,+"\<>;=`, c) >= 0,
+			c == '#' && i == 0,
+			c == ' ' && (i == 0 || i == len(value)-1):
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// berPart is a decoded BER element
+type berPart struct {
+	tag     byte
+	content []byte
+}
+
+// readBER reads one BER element from a stream
+func readBER(r *bufio.Reader) (byte, []byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length := int(first)
+	if first&0x80 != 0 {
+		count := int(first & 0x7f)
+		if count == 0 || count > 4 {
+			return 0, nil, errors.New("unsupported BER length")
+		}
+		length = 0
+		for i := 0; i < count; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length < 0 || length > ldapMaxMessageLength {
+		return 0, nil, errors.New("LDAP message too large")
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, nil, err
+	}
+	return tag, content, nil
+}
+
+// splitBER decodes the consecutive elements inside a constructed element
+func splitBER(data []byte) ([]berPart, error) {
+	var parts []berPart
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, errors.New("truncated BER element")
+		}
+		tag, length, offset := data[0], int(data[1]), 2
+		if data[1]&0x80 != 0 {
+			count := int(data[1] & 0x7f)
+			if count == 0 || count > 4 || len(data) < 2+count {
+				return nil, errors.New("invalid BER length")
+			}
+			length = 0
+			for _, b := range data[2 : 2+count] {
+				length = length<<8 | int(b)
+			}
+			offset += count
+		}
+		if length < 0 || len(data) < offset+length {
+			return nil, errors.New("truncated BER element")
+		}
+		parts = append(parts, berPart{tag: tag, content: data[offset : offset+length]})
+		data = data[offset+length:]
+	}
+	return parts, nil
+}
+
+// berElement encodes a constructed or empty element from encoded children
+func berElement(tag byte, children ...[]byte) []byte {
+	var content []byte
+	for _, child := range children {
+		content = append(content, child...)
+	}
+	return append(berHeader(tag, len(content)), content...)
+}
+
+// berString encodes a primitive string element
+func berString(tag byte, value string) []byte {
+	return append(berHeader(tag, len(value)), value...)
+}
+
+// berInt encodes a non-negative INTEGER or ENUMERATED
+func berInt(tag byte, value int) []byte {
+	content := []byte{byte(value)}
+	for v := value >> 8; v > 0; v >>= 8 {
+		content = append([]byte{byte(v)}, content...)
+	}
+	if content[0]&0x80 != 0 {
+		content = append([]byte{0}, content...)
+	}
+	return append(berHeader(tag, len(content)), content...)
+}
+
+// berHeader encodes a tag and definite length
+func berHeader(tag byte, length int) []byte {
+	if length < 0x80 {
+		return []byte{tag, byte(length)}
+	}
+	var lengthBytes []byte
+	for l := length; l > 0; l >>= 8 {
+		lengthBytes = append([]byte{byte(l)}, lengthBytes...)
+	}
+	return append([]byte{tag, 0x80 | byte(len(lengthBytes))}, lengthBytes...)
+}
+
+// parseBERInt decodes a non-negative INTEGER or ENUMERATED
+func parseBERInt(content []byte) int {
+	value := 0
+	for _, b := range content {
+		value = value<<8 | int(b)
+	}
+	return value
+}
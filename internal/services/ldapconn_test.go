@@ -0,0 +1,434 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ldapHandler answers one request of a fake LDAP server with the raw bytes
+// to write back
+type ldapHandler func(id int, op berPart) []byte
+
+// startFakeLDAP serves LDAP on a local port, passing every request except
+// unbind to the handler, and returns its ldap:// URL
+func startFakeLDAP(t *testing.T, handler ldapHandler) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeLDAP(conn, handler)
+		}
+	}()
+
+	return "ldap://" + listener.Addr().String()
+}
+
+func serveFakeLDAP(conn net.Conn, handler ldapHandler) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		_, message, err := readBER(reader)
+		if err != nil {
+			return
+		}
+		parts, err := splitBER(message)
+		if err != nil || len(parts) < 2 || parts[1].tag == ldapUnbindRequest {
+			return
+		}
+
+		response := handler(parseBERInt(parts[0].content), parts[1])
+		if response == nil {
+			return
+		}
+		if _, err := conn.Write(response); err != nil {
+			return
+		}
+	}
+}
+
+// ldapMessage wraps a protocol operation in an LDAPMessage
+func ldapMessage(id int, op []byte) []byte {
+	return berElement(berSequence, berInt(berInteger, id), op)
+}
+
+// ldapResultOp encodes an LDAPResult with the given tag and code
+func ldapResultOp(tag byte, code int, message string) []byte {
+	return berElement(tag,
+		berInt(berEnumerated, code),
+		berString(berOctetString, ""),
+		berString(berOctetString, message),
+	)
+}
+
+// ldapEntryOp encodes a SearchResultEntry
+func ldapEntryOp(dn string, attributes map[string][]string) []byte {
+	var attrs [][]byte
+	for name, values := range attributes {
+		encoded := make([][]byte, len(values))
+		for i, value := range values {
+			encoded[i] = berString(berOctetString, value)
+		}
+		attrs = append(attrs, berElement(berSequence,
+			berString(berOctetString, name),
+			berElement(0x31, encoded...),
+		))
+	}
+	return berElement(ldapSearchEntry, berString(berOctetString, dn), berElement(berSequence, attrs...))
+}
+
+func dialFakeLDAP(t *testing.T, handler ldapHandler) *ldapConn {
+	t.Helper()
+
+	conn, err := dialLDAP(startFakeLDAP(t, handler), false, nil, 2*time.Second)
+	if err != nil {
+		t.Fatalf("dialLDAP: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestLDAPBind(t *testing.T) {
+	var gotDN, gotPassword string
+	conn := dialFakeLDAP(t, func(id int, op berPart) []byte {
+		fields, err := splitBER(op.content)
+		if op.tag != ldapBindRequest || err != nil || len(fields) != 3 {
+			return ldapMessage(id, ldapResultOp(ldapBindResponse, 2, "protocol error"))
+		}
+		gotDN, gotPassword = string(fields[1].content), string(fields[2].content)
+		if parseBERInt(fields[0].content) != 3 || fields[2].tag != ldapAuthSimple || gotPassword != "secret" {
+			return ldapMessage(id, ldapResultOp(ldapBindResponse, ldapResultBadCreds, "invalid credentials"))
+		}
+		return ldapMessage(id, ldapResultOp(ldapBindResponse, ldapResultSuccess, ""))
+	})
+
+	if err := conn.Bind("uid=alice,dc=example,dc=com", "secret"); err != nil {
+		t.Fatalf("Bind: %v", err)
+	}
+	if gotDN != "uid=alice,dc=example,dc=com" || gotPassword != "secret" {
+		t.Errorf("server received %q / %q", gotDN, gotPassword)
+	}
+
+	var resultErr *ldapResultError
+	if err := conn.Bind("uid=alice,dc=example,dc=com", "wrong"); !errors.As(err, &resultErr) || resultErr.Code != ldapResultBadCreds {
+		t.Errorf("Bind with a wrong password: error = %v, want result 49", err)
+	}
+}
+
+func TestLDAPBindRefusesEmptyPassword(t *testing.T) {
+	requests := 0
+	conn := dialFakeLDAP(t, func(id int, op berPart) []byte {
+		requests++
+		return ldapMessage(id, ldapResultOp(ldapBindResponse, ldapResultSuccess, ""))
+	})
+
+	var resultErr *ldapResultError
+	if err := conn.Bind("uid=alice,dc=example,dc=com", ""); !errors.As(err, &resultErr) || resultErr.Code != ldapResultBadCreds {
+		t.Errorf("error = %v, want result 49", err)
+	}
+	if requests != 0 {
+		t.Error("an unauthenticated bind was sent to the server")
+	}
+}
+
+func TestLDAPSearch(t *testing.T) {
+	var gotBase string
+	var gotFilter []byte
+	conn := dialFakeLDAP(t, func(id int, op berPart) []byte {
+		fields, err := splitBER(op.content)
+		if op.tag != ldapSearchRequest || err != nil || len(fields) != 8 {
+			return ldapMessage(id, ldapResultOp(ldapSearchDone, 2, "protocol error"))
+		}
+		gotBase = string(fields[0].content)
+		gotFilter = berElement(fields[6].tag, fields[6].content)
+
+		var response []byte
+		response = append(response, ldapMessage(id, ldapEntryOp("uid=alice,ou=people,dc=example,dc=com", map[string][]string{
+			"memberOf": {"cn=admins,ou=groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"},
+		}))...)
+		response = append(response, ldapMessage(id, berString(ldapSearchReference, "ldap://other.example.com/"))...)
+		response = append(response, ldapMessage(id, ldapEntryOp("uid=bob,ou=people,dc=example,dc=com", nil))...)
+		response = append(response, ldapMessage(id, ldapResultOp(ldapSearchDone, ldapResultSuccess, ""))...)
+		return response
+	})
+
+	entries, err := conn.Search("ou=people,dc=example,dc=com", ldapScopeSubtree, "(uid=alice)", []string{"memberOf"})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if gotBase != "ou=people,dc=example,dc=com" {
+		t.Errorf("base DN = %q", gotBase)
+	}
+	want, _ := compileLDAPFilter("(uid=alice)")
+	if !bytes.Equal(gotFilter, want) {
+		t.Errorf("filter = %x, want %x", gotFilter, want)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if entries[0].DN != "uid=alice,ou=people,dc=example,dc=com" {
+		t.Errorf("DN = %q", entries[0].DN)
+	}
+	if groups := entries[0].Values("MEMBEROF"); len(groups) != 2 || groups[0] != "cn=admins,ou=groups,dc=example,dc=com" {
+		t.Errorf("memberOf = %q", groups)
+	}
+	if values := entries[1].Values("memberOf"); values != nil {
+		t.Errorf("entry without attributes has memberOf %q", values)
+	}
+}
+
+func TestLDAPSearchFailure(t *testing.T) {
+	conn := dialFakeLDAP(t, func(id int, op berPart) []byte {
+		return ldapMessage(id, ldapResultOp(ldapSearchDone, 32, "no such object"))
+	})
+
+	_, err := conn.Search("ou=missing,dc=example,dc=com", ldapScopeSubtree, "(uid=alice)", nil)
+	var resultErr *ldapResultError
+	if !errors.As(err, &resultErr) || resultErr.Code != 32 || resultErr.Message != "no such object" {
+		t.Errorf("error = %v, want result 32", err)
+	}
+}
+
+func TestLDAPMalformedResponses(t *testing.T) {
+	validBind := func(id int) []byte {
+		return ldapMessage(id, ldapResultOp(ldapBindResponse, ldapResultSuccess, ""))
+	}
+
+	tests := []struct {
+		name     string
+		response func(id int) []byte
+	}{
+		{"connection closed", func(id int) []byte { return nil }},
+		{"truncated message", func(id int) []byte { m := validBind(id); return m[:len(m)-3] }},
+		{"truncated length", func(id int) []byte { return []byte{berSequence, 0x82, 0x01} }},
+		{"not a sequence", func(id int) []byte { return berString(berOctetString, "hello") }},
+		{"indefinite length", func(id int) []byte { return []byte{berSequence, 0x80, 0x00, 0x00} }},
+		{"oversized message", func(id int) []byte { return []byte{berSequence, 0x84, 0x7f, 0xff, 0xff, 0xff} }},
+		{"other message ID", func(id int) []byte { return validBind(id + 1) }},
+		{"missing operation", func(id int) []byte { return berElement(berSequence, berInt(berInteger, id)) }},
+		{"operation past its end", func(id int) []byte {
+			return berElement(berSequence, berInt(berInteger, id), []byte{ldapBindResponse, 0x10, berEnumerated, 0x01, 0x00})
+		}},
+		{"length bytes past the end", func(id int) []byte {
+			return berElement(berSequence, berInt(berInteger, id), []byte{ldapBindResponse, 0x84, 0x00})
+		}},
+		{"short result", func(id int) []byte {
+			return ldapMessage(id, berElement(ldapBindResponse, berInt(berEnumerated, 0)))
+		}},
+		{"unexpected operation", func(id int) []byte {
+			return ldapMessage(id, ldapResultOp(ldapSearchDone, ldapResultSuccess, ""))
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Truncated responses fail when the read deadline passes
+			conn, err := dialLDAP(startFakeLDAP(t, func(id int, op berPart) []byte {
+				return test.response(id)
+			}), false, nil, 500*time.Millisecond)
+			if err != nil {
+				t.Fatalf("dialLDAP: %v", err)
+			}
+			defer conn.Close()
+
+			if err := conn.Bind("uid=alice,dc=example,dc=com", "secret"); err == nil {
+				t.Error("Bind accepted a malformed response")
+			}
+		})
+	}
+}
+
+func TestLDAPMalformedSearchEntries(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry []byte
+	}{
+		{"without attributes", berElement(ldapSearchEntry, berString(berOctetString, "uid=alice"))},
+		{"attribute without values", berElement(ldapSearchEntry,
+			berString(berOctetString, "uid=alice"),
+			berElement(berSequence, berElement(berSequence, berString(berOctetString, "memberOf"))),
+		)},
+		{"truncated value set", berElement(ldapSearchEntry,
+			berString(berOctetString, "uid=alice"),
+			berElement(berSequence, berElement(berSequence,
+				berString(berOctetString, "memberOf"),
+				[]byte{0x31, 0x05, berOctetString, 0x07, 'a'},
+			)),
+		)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := dialFakeLDAP(t, func(id int, op berPart) []byte {
+				return append(ldapMessage(id, test.entry), ldapMessage(id, ldapResultOp(ldapSearchDone, ldapResultSuccess, ""))...)
+			})
+
+			if _, err := conn.Search("dc=example,dc=com", ldapScopeSubtree, "(uid=alice)", nil); err == nil {
+				t.Error("Search accepted a malformed entry")
+			}
+		})
+	}
+}
+
+func TestLDAPSearchConnectionClosed(t *testing.T) {
+	conn := dialFakeLDAP(t, func(id int, op berPart) []byte {
+		// One entry, then the server goes away before SearchResultDone
+		entry := ldapMessage(id, ldapEntryOp("uid=alice,dc=example,dc=com", nil))
+		done := ldapMessage(id, ldapResultOp(ldapSearchDone, ldapResultSuccess, ""))
+		return append(entry, done[:2]...)
+	})
+
+	if _, err := conn.Search("dc=example,dc=com", ldapScopeSubtree, "(uid=alice)", nil); err == nil {
+		t.Error("Search succeeded without SearchResultDone")
+	}
+}
+
+func TestCompileLDAPFilter(t *testing.T) {
+	equality := func(attr, value string) []byte {
+		return berElement(ldapFilterEquality, berString(berOctetString, attr), berString(berOctetString, value))
+	}
+
+	tests := []struct {
+		filter string
+		want   []byte
+	}{
+		{"(uid=alice)", equality("uid", "alice")},
+		{"  (uid=alice)  ", equality("uid", "alice")},
+		{"(objectClass=*)", berString(ldapFilterPresent, "objectClass")},
+		{`(cn=a\2ab\28c\29\5c\00)`, equality("cn", "a*b(c)\\\x00")},
+		{"(&(objectClass=person)(uid=alice))", berElement(ldapFilterAnd,
+			equality("objectClass", "person"),
+			equality("uid", "alice"),
+		)},
+		{"(|(uid=alice)(mail=alice@example.com))", berElement(ldapFilterOr,
+			equality("uid", "alice"),
+			equality("mail", "alice@example.com"),
+		)},
+		{"(&(uid=alice)(!(disabled=TRUE)))", berElement(ldapFilterAnd,
+			equality("uid", "alice"),
+			berElement(ldapFilterNot, equality("disabled", "TRUE")),
+		)},
+	}
+
+	for _, test := range tests {
+		got, err := compileLDAPFilter(test.filter)
+		if err != nil {
+			t.Errorf("compileLDAPFilter(%q): %v", test.filter, err)
+			continue
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("compileLDAPFilter(%q) = %x, want %x", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestCompileLDAPFilterErrors(t *testing.T) {
+	for _, filter := range []string{
+		"",
+		"uid=alice",
+		"(uid=alice",
+		"(uid=alice))",
+		"(uid=alice)(cn=bob)",
+		"(=alice)",
+		"(uid)",
+		"(uid=ali*)",
+		"(uid~=alice)",
+		"(uid>=alice)",
+		"(uid:dn:=alice)",
+		`(uid=alice\2)`,
+		`(uid=alice\zz)`,
+		"(&)",
+		"(&(uid=alice)",
+		"(!(uid=alice)",
+		"(!uid=alice)",
+	} {
+		if encoded, err := compileLDAPFilter(filter); err == nil {
+			t.Errorf("compileLDAPFilter(%q) = %x, want an error", filter, encoded)
+		}
+	}
+}
+
+func TestEscapeLDAPFilterValue(t *testing.T) {
+	tests := map[string]string{
+		"alice":        "alice",
+		"*":            `\2a`,
+		"alice)(uid=*": `alice\29\28uid=\2a`,
+		`back\slash`:   `back\5cslash`,
+		"nul\x00byte":  `nul\00byte`,
+		"älice":        "älice",
+	}
+
+	for value, want := range tests {
+		escaped := escapeLDAPFilterValue(value)
+		if escaped != want {
+			t.Errorf("escapeLDAPFilterValue(%q) = %q, want %q", value, escaped, want)
+		}
+
+		// Whatever the input, the filter matches it literally
+		got, err := compileLDAPFilter("(uid=" + escaped + ")")
+		if err != nil {
+			t.Errorf("filter for %q does not compile: %v", value, err)
+			continue
+		}
+		if want := berElement(ldapFilterEquality, berString(berOctetString, "uid"), berString(berOctetString, value)); !bytes.Equal(got, want) {
+			t.Errorf("filter for %q = %x, want an equality match", value, got)
+		}
+	}
+}
+
+func TestEscapeLDAPDNValue(t *testing.T) {
+	tests := map[string]string{
+		"alice":           "alice",
+		"alice,ou=admins": `alice\,ou\=admins`,
+		`a+b"c\d<e>f;g`:   `a\+b\"c\\d\<e\>f\;g`,
+		"#hash":           `\#hash`,
+		"mid#hash":        "mid#hash",
+		" padded ":        `\ padded\ `,
+		"inner space":     "inner space",
+		"nul\x00":         `nul\00`,
+	}
+
+	for value, want := range tests {
+		if got := escapeLDAPDNValue(value); got != want {
+			t.Errorf("escapeLDAPDNValue(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestBERRoundTrip(t *testing.T) {
+	for _, value := range []int{0, 1, 127, 128, 255, 256, 65535, 1 << 24} {
+		parts, err := splitBER(berInt(berInteger, value))
+		if err != nil || len(parts) != 1 || parseBERInt(parts[0].content) != value {
+			t.Errorf("berInt(%d) does not decode: %v %v", value, parts, err)
+		}
+	}
+
+	for _, length := range []int{0, 127, 128, 255, 256, 70000} {
+		value := strings.Repeat("x", length)
+		parts, err := splitBER(berString(berOctetString, value))
+		if err != nil || len(parts) != 1 || string(parts[0].content) != value {
+			t.Errorf("berString of %d bytes does not decode: %v", length, err)
+		}
+
+		tag, content, err := readBER(bufio.NewReader(bytes.NewReader(berString(berOctetString, value))))
+		if err != nil || tag != berOctetString || string(content) != value {
+			t.Errorf("readBER of %d bytes: %v", length, err)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+// baseLockout is the first lockout after MaxLoginAttempts failures. Each
+// further lockout within MaxLockout doubles it, up to MaxLockout.
+const baseLockout = time.Minute
+
+// LoginLimiter slows down password guessing against SSH servers and profile
+// vaults by locking out client addresses, target accounts and vaults after
+// repeated failed logins
+type LoginLimiter struct {
+	config  *config.Config
+	mutex   sync.Mutex
+	entries map[string]*models.LoginLockout
+}
+
+// NewLoginLimiter creates a new login limiter
+func NewLoginLimiter(cfg *config.Config) *LoginLimiter {
+	return &LoginLimiter{
+		config:  cfg,
+		entries: make(map[string]*models.LoginLockout),
+	}
+}
+
+// Check refuses a login while the client address or the target account is
+// locked out
+func (l *LoginLimiter) Check(clientIP string, req *models.LoginRequest) error {
+	return l.check(clientIP, targetKey(req), targetName(req))
+}
+
+// Fail records a failed login. Reaching MaxLoginAttempts locks the address
+// or account out; failures are forgotten after MaxLockout without any.
+func (l *LoginLimiter) Fail(clientIP string, req *models.LoginRequest) {
+	l.failBoth(clientIP, targetKey(req))
+}
+
+// Succeed forgets the failures of a target account after a good login.
+// The client address keeps its failures, so logging in to a valid account
+// cannot be used to keep guessing others.
+func (l *LoginLimiter) Succeed(req *models.LoginRequest) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.entries, targetKey(req))
+}
+
+// CheckVault refuses to unlock a user's profile vault while the client
+// address or the vault is locked out. Every attempt derives a key with
+// Argon2id, so guesses are expensive for the server as well.
+func (l *LoginLimiter) CheckVault(clientIP, username string) error {
+	return l.check(clientIP, vaultLockoutKey(username), "your profile vault")
+}
+
+// FailVault records a wrong vault passphrase like a failed login
+func (l *LoginLimiter) FailVault(clientIP, username string) {
+	l.failBoth(clientIP, vaultLockoutKey(username))
+}
+
+// SucceedVault forgets the failures of a vault after it was unlocked
+func (l *LoginLimiter) SucceedVault(username string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.entries, vaultLockoutKey(username))
+}
+
+// check refuses an attempt while the client address or key is locked out;
+// subject names the key to the user
+func (l *LoginLimiter) check(clientIP, key, subject string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if entry, exists := l.entries[ipKey(clientIP)]; exists && entry.LockedUntil.After(now) {
+		return &models.LockoutError{Subject: "your address", Until: entry.LockedUntil}
+	}
+	if entry, exists := l.entries[key]; exists && entry.LockedUntil.After(now) {
+		return &models.LockoutError{Subject: subject, Until: entry.LockedUntil}
+	}
+	return nil
+}
+
+// failBoth counts a failure for the client address and a key
+func (l *LoginLimiter) failBoth(clientIP, key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.removeExpired()
+	for _, key := range []string{ipKey(clientIP), key} {
+		l.fail(key)
+	}
+}
+
+// List returns the tracked addresses and accounts, most recent failure first
+func (l *LoginLimiter) List() []models.LoginLockout {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.removeExpired()
+	lockouts := make([]models.LoginLockout, 0, len(l.entries))
+	for _, entry := range l.entries {
+		lockouts = append(lockouts, *entry)
+	}
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].LastFailure.After(lockouts[j].LastFailure)
+	})
+
+	return lockouts
+}
+
+// Clear forgets the failures and lockouts of an address or account
+func (l *LoginLimiter) Clear(key string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, exists := l.entries[key]; !exists {
+		return models.ErrLockoutNotFound
+	}
+	delete(l.entries, key)
+	return nil
+}
+
+// fail counts a failure for one key. The caller must hold the mutex.
+func (l *LoginLimiter) fail(key string) {
+	entry, exists := l.entries[key]
+	if !exists {
+		entry = &models.LoginLockout{Key: key}
+		l.entries[key] = entry
+	}
+
+	now := time.Now()
+	entry.Failures++
+	entry.LastFailure = now
+	if entry.Failures < l.config.Security.MaxLoginAttempts {
+		return
+	}
+
+	// Double step by step so the lockout never overflows, however many
+	// lockouts the key has collected
+	maxLockout := l.config.Security.MaxLockout
+	lockout := min(baseLockout, maxLockout)
+	for i := 0; i < entry.Lockouts && lockout < maxLockout; i++ {
+		if lockout > maxLockout/2 {
+			lockout = maxLockout
+			break
+		}
+		lockout *= 2
+	}
+	entry.Lockouts++
+	entry.Failures = 0
+	entry.LockedUntil = now.Add(lockout)
+	fmt.Printf("Locked out %s for %s after %d failed logins\n", key, lockout, l.config.Security.MaxLoginAttempts)
+}
+
+// removeExpired forgets keys without failures or lockouts for MaxLockout.
+// The caller must hold the mutex.
+func (l *LoginLimiter) removeExpired() {
+	now := time.Now()
+	for key, entry := range l.entries {
+		if entry.LockedUntil.Before(now) && now.Sub(entry.LastFailure) > l.config.Security.MaxLockout {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// ipKey is the lockout key of a client address
+func ipKey(clientIP string) string {
+	return "ip:" + clientIP
+}
+
+// targetKey is the lockout key of the account a login request targets
+func targetKey(req *models.LoginRequest) string {
+	return "target:" + targetName(req)
+}
+
+// vaultLockoutKey is the lockout key of a user's profile vault
+func vaultLockoutKey(username string) string {
+	return "vault:" + username
+}
+
+// targetName is the user@host:port a login request targets
+func targetName(req *models.LoginRequest) string {
+	return req.Username + "@" + net.JoinHostPort(strings.ToLower(req.Host), strconv.Itoa(req.Port))
+}
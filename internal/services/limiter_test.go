@@ -0,0 +1,196 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+func newTestLoginLimiter() *LoginLimiter {
+	cfg := config.DefaultConfig()
+	cfg.Security.MaxLoginAttempts = 3
+	cfg.Security.MaxLockout = 10 * time.Minute
+	return NewLoginLimiter(cfg)
+}
+
+func loginTo(username, host string) *models.LoginRequest {
+	return &models.LoginRequest{Host: host, Port: 22, Username: username}
+}
+
+func TestLoginLimiterLockout(t *testing.T) {
+	alice := loginTo("alice", "server.example")
+	bob := loginTo("bob", "server.example")
+	carol := loginTo("carol", "server.example")
+
+	tests := []struct {
+		name        string
+		steps       func(l *LoginLimiter)
+		check       func(l *LoginLimiter) error
+		wantSubject string
+	}{
+		{
+			name: "failures below the limit",
+			steps: func(l *LoginLimiter) {
+				l.Fail("192.0.2.1", alice)
+				l.Fail("192.0.2.1", alice)
+			},
+			check: func(l *LoginLimiter) error { return l.Check("192.0.2.1", alice) },
+		},
+		{
+			name: "address locked out for every account",
+			steps: func(l *LoginLimiter) {
+				l.Fail("192.0.2.1", alice)
+				l.Fail("192.0.2.1", bob)
+				l.Fail("192.0.2.1", carol)
+			},
+			check:       func(l *LoginLimiter) error { return l.Check("192.0.2.1", loginTo("dave", "server.example")) },
+			wantSubject: "your address",
+		},
+		{
+			name: "account locked out from every address",
+			steps: func(l *LoginLimiter) {
+				l.Fail("192.0.2.1", alice)
+				l.Fail("192.0.2.2", alice)
+				l.Fail("192.0.2.3", alice)
+			},
+			check:       func(l *LoginLimiter) error { return l.Check("192.0.2.4", alice) },
+			wantSubject: "alice@server.example:22",
+		},
+		{
+			name: "host names are compared without case",
+			steps: func(l *LoginLimiter) {
+				l.Fail("192.0.2.1", loginTo("alice", "SERVER.example"))
+				l.Fail("192.0.2.2", loginTo("alice", "Server.Example"))
+				l.Fail("192.0.2.3", alice)
+			},
+			check:       func(l *LoginLimiter) error { return l.Check("192.0.2.4", alice) },
+			wantSubject: "alice@server.example:22",
+		},
+		{
+			name: "other port is another account",
+			steps: func(l *LoginLimiter) {
+				l.Fail("192.0.2.1", alice)
+				l.Fail("192.0.2.2", alice)
+				l.Fail("192.0.2.3", alice)
+			},
+			check: func(l *LoginLimiter) error {
+				return l.Check("192.0.2.4", &models.LoginRequest{Host: "server.example", Port: 2222, Username: "alice"})
+			},
+		},
+		{
+			name: "success forgets the account's failures",
+			steps: func(l *LoginLimiter) {
+				l.Fail("192.0.2.1", alice)
+				l.Fail("192.0.2.2", alice)
+				l.Succeed(alice)
+				l.Fail("192.0.2.3", alice)
+			},
+			check: func(l *LoginLimiter) error { return l.Check("192.0.2.4", alice) },
+		},
+		{
+			name: "success keeps the address's failures",
+			steps: func(l *LoginLimiter) {
+				l.Fail("192.0.2.1", alice)
+				l.Fail("192.0.2.1", bob)
+				l.Succeed(bob)
+				l.Fail("192.0.2.1", carol)
+			},
+			check:       func(l *LoginLimiter) error { return l.Check("192.0.2.1", bob) },
+			wantSubject: "your address",
+		},
+		{
+			name: "vault locked out after wrong passphrases",
+			steps: func(l *LoginLimiter) {
+				l.FailVault("192.0.2.1", "alice")
+				l.FailVault("192.0.2.2", "alice")
+				l.FailVault("192.0.2.3", "alice")
+			},
+			check:       func(l *LoginLimiter) error { return l.CheckVault("192.0.2.4", "alice") },
+			wantSubject: "your profile vault",
+		},
+		{
+			name: "vault failures do not lock the SFTP account of the same name",
+			steps: func(l *LoginLimiter) {
+				l.FailVault("192.0.2.1", "alice")
+				l.FailVault("192.0.2.2", "alice")
+				l.FailVault("192.0.2.3", "alice")
+			},
+			check: func(l *LoginLimiter) error { return l.Check("192.0.2.4", alice) },
+		},
+		{
+			name: "vault failures count for the address",
+			steps: func(l *LoginLimiter) {
+				l.FailVault("192.0.2.1", "alice")
+				l.Fail("192.0.2.1", bob)
+				l.Fail("192.0.2.1", carol)
+			},
+			check:       func(l *LoginLimiter) error { return l.CheckVault("192.0.2.1", "alice") },
+			wantSubject: "your address",
+		},
+		{
+			name: "cleared lockout",
+			steps: func(l *LoginLimiter) {
+				l.Fail("192.0.2.1", alice)
+				l.Fail("192.0.2.2", alice)
+				l.Fail("192.0.2.3", alice)
+				if err := l.Clear(targetKey(alice)); err != nil {
+					t.Fatalf("Clear: %v", err)
+				}
+			},
+			check: func(l *LoginLimiter) error { return l.Check("192.0.2.4", alice) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newTestLoginLimiter()
+			tt.steps(limiter)
+
+			err := tt.check(limiter)
+			if tt.wantSubject == "" {
+				if err != nil {
+					t.Fatalf("check: %v, want no lockout", err)
+				}
+				return
+			}
+
+			var lockoutErr *models.LockoutError
+			if !errors.As(err, &lockoutErr) {
+				t.Fatalf("error = %v, want a lockout", err)
+			}
+			if lockoutErr.Subject != tt.wantSubject {
+				t.Errorf("locked out %q, want %q", lockoutErr.Subject, tt.wantSubject)
+			}
+		})
+	}
+}
+
+func TestLoginLimiterBackoff(t *testing.T) {
+	limiter := newTestLoginLimiter()
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute}
+
+	for i, wantLockout := range want {
+		limiter.mutex.Lock()
+		for attempt := 0; attempt < limiter.config.Security.MaxLoginAttempts; attempt++ {
+			limiter.fail("ip:192.0.2.1")
+		}
+		entry := *limiter.entries["ip:192.0.2.1"]
+		limiter.mutex.Unlock()
+
+		if got := entry.LockedUntil.Sub(entry.LastFailure); got != wantLockout {
+			t.Errorf("lockout %d = %s, want %s", i+1, got, wantLockout)
+		}
+		if entry.Failures != 0 {
+			t.Errorf("lockout %d left %d failures, want them reset", i+1, entry.Failures)
+		}
+	}
+}
+
+func TestLoginLimiterClearUnknown(t *testing.T) {
+	if err := newTestLoginLimiter().Clear("ip:192.0.2.1"); !errors.Is(err, models.ErrLockoutNotFound) {
+		t.Errorf("error = %v, want %v", err, models.ErrLockoutNotFound)
+	}
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"sftp-gui/internal/models"
+)
+
+// LoginFlow tracks an SSH handshake that may pause to relay
+// keyboard-interactive challenges to the browser
+type LoginFlow struct {
+	ID      string
+	Request *models.LoginRequest
+
+	// webSessionID is the browser session that started the login; only it
+	// may answer the challenges. Guarded by the service mutex.
+	webSessionID string
+
+	timeout   time.Duration
+	expiresAt time.Time
+
+	challenges chan *models.LoginChallenge
+	answers    chan []string
+	done       chan struct{}
+	abandoned  chan struct{}
+
+	mutex   sync.Mutex
+	pending *models.LoginChallenge
+	session *models.Session
+	err     error
+}
+
+// BeginLogin starts an SSH handshake in the background for a browser
+// session. The returned flow reports either a challenge the user has to
+// answer or the final result.
+func (s *SessionService) BeginLogin(webSessionID string, req *models.LoginRequest) (*LoginFlow, error) {
+	id, err := s.generateSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := s.config.Security.ChallengeTimeout
+	flow := &LoginFlow{
+		ID:           id,
+		Request:      req,
+		webSessionID: webSessionID,
+		timeout:      timeout,
+		expiresAt:    time.Now().Add(timeout),
+		challenges:   make(chan *models.LoginChallenge, 1),
+		answers:      make(chan []string, 1),
+		done:         make(chan struct{}),
+		abandoned:    make(chan struct{}),
+	}
+
+	s.mutex.Lock()
+	s.loginFlows[id] = flow
+	s.mutex.Unlock()
+
+	go func() {
+		session, err := s.createSession(req, flow)
+
+		flow.mutex.Lock()
+		abandoned := flow.isAbandoned()
+		if !abandoned {
+			flow.session, flow.err = session, err
+		}
+		flow.pending = nil
+		flow.expiresAt = time.Now().Add(timeout)
+		close(flow.done)
+		flow.mutex.Unlock()
+
+		// Nobody is waiting for a connection that completed after its
+		// login timed out, so it must not be left open without an owner
+		if abandoned && session != nil {
+			s.mutex.Lock()
+			s.removeSession(session.ID)
+			s.mutex.Unlock()
+		}
+	}()
+
+	return flow, nil
+}
+
+// GetLoginFlow returns a login flow of a browser session that is waiting
+// for challenge answers. Flows of other browser sessions are reported as
+// expired, so a leaked flow ID cannot be used to answer for someone else.
+func (s *SessionService) GetLoginFlow(webSessionID, id string) (*LoginFlow, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	flow, exists := s.loginFlows[id]
+	if !exists || flow.webSessionID != webSessionID {
+		return nil, models.ErrLoginExpired
+	}
+
+	return flow, nil
+}
+
+// EndLogin forgets a login flow once its result has been consumed
+func (s *SessionService) EndLogin(flow *LoginFlow) {
+	s.mutex.Lock()
+	delete(s.loginFlows, flow.ID)
+	s.mutex.Unlock()
+}
+
+// Wait blocks until the handshake needs challenge answers or completes.
+// Exactly one of the challenge or the session/error result is set.
+func (f *LoginFlow) Wait() (*models.LoginChallenge, *models.Session, error) {
+	select {
+	case challenge := <-f.challenges:
+		return challenge, nil, nil
+	case <-f.done:
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		return nil, f.session, f.err
+	case <-time.After(f.timeout):
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		// The handshake may have completed just as the timeout fired
+		if f.finished() {
+			return nil, f.session, f.err
+		}
+		if !f.isAbandoned() {
+			close(f.abandoned)
+		}
+		return nil, nil, models.ErrChallengeTimeout
+	}
+}
+
+// Answer delivers the user's answers to the pending challenge
+func (f *LoginFlow) Answer(answers []string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.pending == nil {
+		return models.ErrNoChallenge
+	}
+	if len(answers) != len(f.pending.Prompts) {
+		return models.NewValidationError("an answer is required for every prompt")
+	}
+
+	f.pending = nil
+	f.answers <- answers
+	return nil
+}
+
+// isAbandoned reports whether the user stopped waiting for the handshake.
+// The caller must hold the mutex.
+func (f *LoginFlow) isAbandoned() bool {
+	select {
+	case <-f.abandoned:
+		return true
+	default:
+		return false
+	}
+}
+
+// finished reports whether the handshake has completed
+func (f *LoginFlow) finished() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// challengeFor returns a keyboard-interactive handler for one hop of the
+// connection that hands the prompts to the browser and waits for the answers
+func (f *LoginFlow) challengeFor(hop models.JumpHost) ssh.KeyboardInteractiveChallenge {
+	passwordUsed := false
+
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		// Servers may send informational rounds without any prompt
+		if len(questions) == 0 {
+			return []string{}, nil
+		}
+
+		// PAM commonly asks for the password through keyboard-interactive;
+		// answer it once with the password from the login form
+		if hop.Password != "" && !passwordUsed && isPasswordPrompt(questions, echos) {
+			passwordUsed = true
+			return []string{hop.Password}, nil
+		}
+
+		prompts := make([]models.ChallengePrompt, len(questions))
+		for i, question := range questions {
+			prompts[i] = models.ChallengePrompt{Text: question, Echo: echos[i]}
+		}
+
+		challenge := &models.LoginChallenge{
+			FlowID:      f.ID,
+			Host:        hop.Host,
+			Name:        name,
+			Instruction: instruction,
+			Prompts:     prompts,
+		}
+
+		f.mutex.Lock()
+		f.pending = challenge
+		f.expiresAt = time.Now().Add(f.timeout)
+		f.mutex.Unlock()
+
+		select {
+		case f.challenges <- challenge:
+		case <-f.abandoned:
+			return nil, models.ErrChallengeTimeout
+		}
+
+		select {
+		case answers := <-f.answers:
+			return answers, nil
+		case <-f.abandoned:
+			return nil, models.ErrChallengeTimeout
+		case <-time.After(f.timeout):
+			f.mutex.Lock()
+			f.pending = nil
+			f.mutex.Unlock()
+			return nil, models.ErrChallengeTimeout
+		}
+	}
+}
@@ -0,0 +1,505 @@
+package services
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+// OIDCSource marks accounts created through OpenID Connect sign-in
+const OIDCSource = "oidc"
+
+const (
+	// oidcFlowTimeout is how long the provider may take to send the user back
+	oidcFlowTimeout = 10 * time.Minute
+	// oidcClockSkew is tolerated between our clock and the provider's
+	oidcClockSkew = time.Minute
+	// oidcKeyRefresh limits how often unknown key IDs trigger a JWKS fetch
+	oidcKeyRefresh = time.Minute
+)
+
+// oidcProvider holds the endpoints from the provider's discovery document
+type oidcProvider struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	AuthMethods           []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// oidcFlow is a sign-in waiting for the provider to redirect back
+type oidcFlow struct {
+	verifier    string
+	nonce       string
+	redirectURL string
+	expires     time.Time
+}
+
+// OIDCIdentity is the account an ID token maps to
+type OIDCIdentity struct {
+	Username string
+	Role     string
+	// Subject is the issuer and sub claim joined by "#", which together
+	// identify the user at the provider for good
+	Subject string
+}
+
+// OIDCService signs users in with an OpenID Connect provider using the
+// authorization code flow with PKCE. ID tokens must be RS256-signed by a
+// key from the provider's JWKS.
+type OIDCService struct {
+	config *config.Config
+	client *http.Client
+
+	mutex     sync.Mutex
+	provider  *oidcProvider
+	keys      map[string]*rsa.PublicKey
+	keysFetch time.Time
+	flows     map[string]*oidcFlow
+}
+
+// NewOIDCService creates a new OIDC service. The provider is contacted on
+// the first sign-in, so it need not be reachable at startup.
+func NewOIDCService(cfg *config.Config) *OIDCService {
+	return &OIDCService{
+		config: cfg,
+		client: &http.Client{Timeout: 15 * time.Second},
+		keys:   make(map[string]*rsa.PublicKey),
+		flows:  make(map[string]*oidcFlow),
+	}
+}
+
+// Enabled reports whether OIDC sign-in is configured
+func (o *OIDCService) Enabled() bool {
+	return o.config.Security.OIDC.Enabled()
+}
+
+// ProviderName returns the label of the sign-in button
+func (o *OIDCService) ProviderName() string {
+	return o.config.Security.OIDC.ProviderName
+}
+
+// Begin starts a sign-in and returns the provider URL to send the browser
+// to, together with the state that must come back with the code
+func (o *OIDCService) Begin(redirectURL string) (authURL, state string, err error) {
+	provider, err := o.discover()
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err = randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	cfg := o.config.Security.OIDC
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {cfg.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	o.mutex.Lock()
+	o.removeExpiredFlows()
+	o.flows[state] = &oidcFlow{
+		verifier:    verifier,
+		nonce:       nonce,
+		redirectURL: redirectURL,
+		expires:     time.Now().Add(oidcFlowTimeout),
+	}
+	o.mutex.Unlock()
+
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return provider.AuthorizationEndpoint + separator + params.Encode(), state, nil
+}
+
+// Finish redeems the authorization code of a sign-in started with Begin,
+// verifies the ID token and maps its claims to an app user and role
+func (o *OIDCService) Finish(state, code string) (*OIDCIdentity, error) {
+	o.mutex.Lock()
+	flow, exists := o.flows[state]
+	delete(o.flows, state)
+	o.mutex.Unlock()
+
+	if !exists || time.Now().After(flow.expires) {
+		return nil, models.NewAuthError("sign-in request has expired, please try again")
+	}
+
+	rawIDToken, err := o.exchange(code, flow)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := o.verify(rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, _ := claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(flow.nonce)) != 1 {
+		return nil, fmt.Errorf("%w: ID token nonce does not match", models.ErrSSOFailed)
+	}
+
+	return o.identity(claims)
+}
+
+// identity maps verified ID token claims to an app user
+func (o *OIDCService) identity(claims map[string]interface{}) (*OIDCIdentity, error) {
+	cfg := o.config.Security.OIDC
+
+	username, _ := claims[cfg.UsernameClaim].(string)
+	if username == "" {
+		return nil, fmt.Errorf("%w: ID token has no %s claim", models.ErrSSOFailed, cfg.UsernameClaim)
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: ID token has no sub claim", models.ErrSSOFailed)
+	}
+	issuer, _ := claims["iss"].(string)
+
+	role := o.config.Policy.DefaultRole
+	if cfg.RolesClaim != "" {
+		role = mapRole(o.config, claimStrings(claims[cfg.RolesClaim]), cfg.RoleMapping)
+	}
+
+	return &OIDCIdentity{
+		Username: username,
+		Role:     role,
+		Subject:  issuer + "#" + subject,
+	}, nil
+}
+
+// exchange redeems an authorization code for an ID token at the token
+// endpoint, proving possession of the PKCE verifier
+func (o *OIDCService) exchange(code string, flow *oidcFlow) (string, error) {
+	provider, err := o.discover()
+	if err != nil {
+		return "", err
+	}
+
+	cfg := o.config.Security.OIDC
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {flow.redirectURL},
+		"client_id":     {cfg.ClientID},
+		"code_verifier": {flow.verifier},
+	}
+
+	// Confidential clients authenticate with HTTP Basic unless the provider
+	// only supports client_secret_post
+	useBasic := cfg.ClientSecret != "" &&
+		(len(provider.AuthMethods) == 0 || containsString(provider.AuthMethods, "client_secret_basic"))
+	if cfg.ClientSecret != "" && !useBasic {
+		form.Set("client_secret", cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasic {
+		req.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(cfg.ClientSecret))
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: token request failed: %v", models.ErrSSOFailed, err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("%w: invalid token response: %v", models.ErrSSOFailed, err)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return "", fmt.Errorf("%w: token endpoint returned %s %s", models.ErrSSOFailed, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return "", fmt.Errorf("%w: token response has no ID token", models.ErrSSOFailed)
+	}
+
+	return token.IDToken, nil
+}
+
+// verify checks the signature, issuer, audience and lifetime of an ID token
+// and returns its claims
+func (o *OIDCService) verify(rawIDToken string) (map[string]interface{}, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed ID token", models.ErrSSOFailed)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed ID token header", models.ErrSSOFailed)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported ID token algorithm %q", models.ErrSSOFailed, header.Alg)
+	}
+
+	key, err := o.signingKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed ID token signature", models.ErrSSOFailed)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("%w: invalid ID token signature", models.ErrSSOFailed)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed ID token claims", models.ErrSSOFailed)
+	}
+
+	cfg := o.config.Security.OIDC
+	if issuer, _ := claims["iss"].(string); issuer != strings.TrimSuffix(cfg.Issuer, "/") && issuer != cfg.Issuer {
+		return nil, fmt.Errorf("%w: ID token issued by %q", models.ErrSSOFailed, issuer)
+	}
+
+	audience := claimStrings(claims["aud"])
+	if !containsString(audience, cfg.ClientID) {
+		return nil, fmt.Errorf("%w: ID token is not meant for this client", models.ErrSSOFailed)
+	}
+	if azp, ok := claims["azp"].(string); ok && len(audience) > 1 && azp != cfg.ClientID {
+		return nil, fmt.Errorf("%w: ID token authorized party is %q", models.ErrSSOFailed, azp)
+	}
+
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("%w: ID token has expired", models.ErrSSOFailed)
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("%w: ID token issued in the future", models.ErrSSOFailed)
+	}
+
+	return claims, nil
+}
+
+// discover fetches and caches the provider's discovery document
+func (o *OIDCService) discover() (*oidcProvider, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.provider != nil {
+		return o.provider, nil
+	}
+
+	issuer := strings.TrimSuffix(o.config.Security.OIDC.Issuer, "/")
+	var provider oidcProvider
+	if err := o.getJSON(issuer+"/.well-known/openid-configuration", &provider); err != nil {
+		return nil, fmt.Errorf("%w: discovery failed: %v", models.ErrSSOFailed, err)
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("%w: provider reports issuer %q", models.ErrSSOFailed, provider.Issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery document is incomplete", models.ErrSSOFailed)
+	}
+
+	o.provider = &provider
+	return o.provider, nil
+}
+
+// signingKey returns the provider key with the given ID, refetching the
+// JWKS when the key is unknown, e.g. after the provider rotated keys
+func (o *OIDCService) signingKey(kid string) (*rsa.PublicKey, error) {
+	provider, err := o.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if key := o.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	if time.Since(o.keysFetch) < oidcKeyRefresh && len(o.keys) > 0 {
+		return nil, fmt.Errorf("%w: unknown signing key %q", models.ErrSSOFailed, kid)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := o.getJSON(provider.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("%w: fetching signing keys failed: %v", models.ErrSSOFailed, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	o.keys = keys
+	o.keysFetch = time.Now()
+
+	if key := o.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown signing key %q", models.ErrSSOFailed, kid)
+}
+
+// lookupKey finds a cached key. A token without key ID is accepted when the
+// provider publishes a single key. The caller must hold the mutex.
+func (o *OIDCService) lookupKey(kid string) *rsa.PublicKey {
+	if key, ok := o.keys[kid]; ok {
+		return key
+	}
+	if kid == "" && len(o.keys) == 1 {
+		for _, key := range o.keys {
+			return key
+		}
+	}
+	return nil
+}
+
+// getJSON fetches a JSON document from the provider
+func (o *OIDCService) getJSON(url string, v interface{}) error {
+	resp, err := o.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// removeExpiredFlows forgets sign-ins the user never completed. The caller
+// must hold the mutex.
+func (o *OIDCService) removeExpiredFlows() {
+	now := time.Now()
+	for state, flow := range o.flows {
+		if now.After(flow.expires) {
+			delete(o.flows, state)
+		}
+	}
+}
+
+// mapRole returns the role ranked first in the policy's role priority that
+// one of the identity provider's group values maps to, or the default role
+// without a match
+func mapRole(cfg *config.Config, values []string, mapping map[string]string) string {
+	best := -1
+	for _, value := range values {
+		mapped, ok := mapping[value]
+		if !ok {
+			continue
+		}
+		for rank, role := range cfg.Policy.RolePriority {
+			if role == mapped && (best < 0 || rank < best) {
+				best = rank
+			}
+		}
+	}
+
+	if best < 0 {
+		return cfg.Policy.DefaultRole
+	}
+	return cfg.Policy.RolePriority[best]
+}
+
+// claimStrings reads a claim that may be a single string or a list
+func claimStrings(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("invalid JSON")
+	}
+	return nil
+}
+
+// randomToken returns 32 random bytes encoded for use in URLs
+func randomToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
@@ -0,0 +1,381 @@
+package services
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+const (
+	testClientID    = "sftp-gui"
+	testRedirectURL = "https://sftp.example.com/auth/oidc/callback"
+	testCode        = "authorization-code"
+)
+
+// mockProvider is an OpenID Connect provider serving discovery, JWKS and
+// token endpoints. The ID token it issues is built by the test.
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+
+	// challenge is the PKCE challenge of the sign-in being completed
+	challenge string
+	// idToken is returned by the token endpoint
+	idToken string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	p := &mockProvider{t: t, key: generateTestKey(t), kid: "key-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"use": "sig",
+				"kid": p.kid,
+				"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if r.FormValue("code") != testCode || r.FormValue("client_id") != testClientID ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != p.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.idToken})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+// claims returns valid ID token claims for a sign-in with the nonce
+func (p *mockProvider) claims(nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":                p.server.URL,
+		"sub":                "user-1234",
+		"aud":                testClientID,
+		"exp":                now.Add(time.Hour).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              nonce,
+		"preferred_username": "alice",
+		"groups":             []string{"staff", "sftp-admins"},
+	}
+}
+
+// sign returns a compact JWT of the header and claims signed with key
+func (p *mockProvider) sign(header, claims map[string]interface{}, key *rsa.PrivateKey) string {
+	p.t.Helper()
+
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			p.t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signingInput := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// newTestOIDCService returns a service configured for the provider
+func newTestOIDCService(p *mockProvider) *OIDCService {
+	cfg := config.DefaultConfig()
+	cfg.Security.OIDC = config.OIDCConfig{
+		Issuer:        p.server.URL,
+		ClientID:      testClientID,
+		Scopes:        []string{"openid", "profile"},
+		UsernameClaim: "preferred_username",
+		RolesClaim:    "groups",
+		RoleMapping:   map[string]string{"sftp-admins": config.RoleAdmin},
+	}
+	return NewOIDCService(cfg)
+}
+
+// beginSignIn starts a sign-in and returns its state and nonce
+func beginSignIn(t *testing.T, o *OIDCService, p *mockProvider) (string, string) {
+	t.Helper()
+
+	authURL, state, err := o.Begin(testRedirectURL)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("authorization URL: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("state") != state || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+	p.challenge = query.Get("code_challenge")
+	return state, query.Get("nonce")
+}
+
+func generateTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestOIDCFinish(t *testing.T) {
+	p := newMockProvider(t)
+	o := newTestOIDCService(p)
+
+	state, nonce := beginSignIn(t, o, p)
+	p.idToken = p.sign(map[string]interface{}{"alg": "RS256", "kid": p.kid}, p.claims(nonce), p.key)
+
+	identity, err := o.Finish(state, testCode)
+	if err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if identity.Username != "alice" {
+		t.Errorf("username = %q, want alice", identity.Username)
+	}
+	if identity.Role != config.RoleAdmin {
+		t.Errorf("role = %q, want %q", identity.Role, config.RoleAdmin)
+	}
+	if want := p.server.URL + "#user-1234"; identity.Subject != want {
+		t.Errorf("subject = %q, want %q", identity.Subject, want)
+	}
+
+	// A state can only be redeemed once
+	if _, err := o.Finish(state, testCode); err == nil {
+		t.Error("Finish accepted a state a second time")
+	}
+}
+
+func TestOIDCFinishRejectsInvalidTokens(t *testing.T) {
+	otherKey := generateTestKey(t)
+
+	tests := []struct {
+		name  string
+		token func(p *mockProvider, claims map[string]interface{}) string
+	}{
+		{
+			name: "signed by another key",
+			token: func(p *mockProvider, claims map[string]interface{}) string {
+				return p.sign(map[string]interface{}{"alg": "RS256", "kid": p.kid}, claims, otherKey)
+			},
+		},
+		{
+			name: "unknown key ID",
+			token: func(p *mockProvider, claims map[string]interface{}) string {
+				return p.sign(map[string]interface{}{"alg": "RS256", "kid": "key-2"}, claims, p.key)
+			},
+		},
+		{
+			name: "unsigned",
+			token: func(p *mockProvider, claims map[string]interface{}) string {
+				token := p.sign(map[string]interface{}{"alg": "none", "kid": p.kid}, claims, p.key)
+				return token[:strings.LastIndex(token, ".")+1]
+			},
+		},
+		{
+			name: "HMAC algorithm",
+			token: func(p *mockProvider, claims map[string]interface{}) string {
+				return p.sign(map[string]interface{}{"alg": "HS256", "kid": p.kid}, claims, p.key)
+			},
+		},
+		{
+			name: "claims changed after signing",
+			token: func(p *mockProvider, claims map[string]interface{}) string {
+				token := p.sign(map[string]interface{}{"alg": "RS256", "kid": p.kid}, claims, p.key)
+				parts := strings.Split(token, ".")
+				claims["preferred_username"] = "admin"
+				data, _ := json.Marshal(claims)
+				return parts[0] + "." + base64.RawURLEncoding.EncodeToString(data) + "." + parts[2]
+			},
+		},
+		{
+			name:  "malformed",
+			token: func(p *mockProvider, claims map[string]interface{}) string { return "not.a-token" },
+		},
+		{
+			name:  "other issuer",
+			token: signedWith(func(claims map[string]interface{}) { claims["iss"] = "https://evil.example.com" }),
+		},
+		{
+			name:  "other audience",
+			token: signedWith(func(claims map[string]interface{}) { claims["aud"] = "another-client" }),
+		},
+		{
+			name: "other authorized party",
+			token: signedWith(func(claims map[string]interface{}) {
+				claims["aud"] = []string{testClientID, "another-client"}
+				claims["azp"] = "another-client"
+			}),
+		},
+		{
+			name:  "expired",
+			token: signedWith(func(claims map[string]interface{}) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }),
+		},
+		{
+			name:  "without expiry",
+			token: signedWith(func(claims map[string]interface{}) { delete(claims, "exp") }),
+		},
+		{
+			name:  "issued in the future",
+			token: signedWith(func(claims map[string]interface{}) { claims["iat"] = time.Now().Add(time.Hour).Unix() }),
+		},
+		{
+			name:  "other nonce",
+			token: signedWith(func(claims map[string]interface{}) { claims["nonce"] = "replayed" }),
+		},
+		{
+			name:  "without subject",
+			token: signedWith(func(claims map[string]interface{}) { delete(claims, "sub") }),
+		},
+		{
+			name:  "without username",
+			token: signedWith(func(claims map[string]interface{}) { delete(claims, "preferred_username") }),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newMockProvider(t)
+			o := newTestOIDCService(p)
+
+			state, nonce := beginSignIn(t, o, p)
+			p.idToken = test.token(p, p.claims(nonce))
+
+			identity, err := o.Finish(state, testCode)
+			if err == nil {
+				t.Fatalf("Finish accepted the token as %+v", identity)
+			}
+			if !errors.Is(err, models.ErrSSOFailed) {
+				t.Errorf("error = %v, want ErrSSOFailed", err)
+			}
+		})
+	}
+}
+
+// signedWith returns a token builder that changes the valid claims before
+// signing them with the provider's key
+func signedWith(change func(claims map[string]interface{})) func(p *mockProvider, claims map[string]interface{}) string {
+	return func(p *mockProvider, claims map[string]interface{}) string {
+		change(claims)
+		return p.sign(map[string]interface{}{"alg": "RS256", "kid": p.kid}, claims, p.key)
+	}
+}
+
+func TestOIDCFinishRejectsWrongVerifier(t *testing.T) {
+	p := newMockProvider(t)
+	o := newTestOIDCService(p)
+
+	state, nonce := beginSignIn(t, o, p)
+	p.idToken = p.sign(map[string]interface{}{"alg": "RS256", "kid": p.kid}, p.claims(nonce), p.key)
+	p.challenge = "challenge-of-another-sign-in"
+
+	if _, err := o.Finish(state, testCode); !errors.Is(err, models.ErrSSOFailed) {
+		t.Fatalf("error = %v, want ErrSSOFailed", err)
+	}
+}
+
+func TestOIDCFinishAfterKeyRotation(t *testing.T) {
+	p := newMockProvider(t)
+	o := newTestOIDCService(p)
+
+	state, nonce := beginSignIn(t, o, p)
+	p.idToken = p.sign(map[string]interface{}{"alg": "RS256", "kid": p.kid}, p.claims(nonce), p.key)
+	if _, err := o.Finish(state, testCode); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+
+	p.key, p.kid = generateTestKey(t), "key-2"
+
+	// Unknown keys are only fetched once per oidcKeyRefresh
+	state, nonce = beginSignIn(t, o, p)
+	p.idToken = p.sign(map[string]interface{}{"alg": "RS256", "kid": p.kid}, p.claims(nonce), p.key)
+	if _, err := o.Finish(state, testCode); err == nil {
+		t.Fatal("Finish fetched the keys again within oidcKeyRefresh")
+	}
+
+	o.mutex.Lock()
+	o.keysFetch = time.Now().Add(-oidcKeyRefresh)
+	o.mutex.Unlock()
+
+	state, nonce = beginSignIn(t, o, p)
+	p.idToken = p.sign(map[string]interface{}{"alg": "RS256", "kid": p.kid}, p.claims(nonce), p.key)
+	if _, err := o.Finish(state, testCode); err != nil {
+		t.Fatalf("Finish after key rotation: %v", err)
+	}
+}
+
+func TestMapRole(t *testing.T) {
+	cfg := config.DefaultConfig()
+	// auditor grants more permissions than viewer but ranks below it
+	cfg.Policy.Roles["auditor"] = []string{config.PermDownload, config.PermPreview, config.PermUpload}
+	cfg.Policy.RolePriority = append(cfg.Policy.RolePriority, "auditor")
+	mapping := map[string]string{
+		"sftp-admins":   config.RoleAdmin,
+		"sftp-editors":  config.RoleEditor,
+		"sftp-viewers":  config.RoleViewer,
+		"sftp-auditors": "auditor",
+	}
+
+	tests := []struct {
+		name   string
+		values []string
+		want   string
+	}{
+		{name: "no groups", want: config.RoleViewer},
+		{name: "unmapped group", values: []string{"staff"}, want: config.RoleViewer},
+		{name: "one group", values: []string{"sftp-editors"}, want: config.RoleEditor},
+		{name: "first ranked wins", values: []string{"sftp-editors", "sftp-admins"}, want: config.RoleAdmin},
+		{name: "rank, not permission count", values: []string{"sftp-auditors", "sftp-viewers"}, want: config.RoleViewer},
+		{name: "role ranked last", values: []string{"staff", "sftp-auditors"}, want: "auditor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mapRole(cfg, tt.values, mapping); got != tt.want {
+				t.Errorf("mapRole(%q) = %q, want %q", tt.values, got, tt.want)
+			}
+		})
+	}
+}
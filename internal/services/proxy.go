@@ -0,0 +1,183 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+// ProxyDirect disables the globally configured proxy for a connection
+const ProxyDirect = models.ProxyDirect
+
+// contextDialer opens outbound TCP connections
+type contextDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// newDialer returns a dialer for the given proxy URL. Supported schemes are
+// socks5:// (also socks5h://) and http:// (HTTP CONNECT); credentials are
+// taken from the URL's user info. An empty URL or "direct" dials directly.
+func newDialer(proxyURL string, timeout time.Duration) (contextDialer, error) {
+	direct := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+
+	if proxyURL == "" || proxyURL == ProxyDirect {
+		return direct, nil
+	}
+
+	u, err := ParseProxyURL(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			password, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: password}
+		}
+
+		dialer, err := proxy.SOCKS5("tcp", u.Host, auth, direct)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SOCKS5 proxy: %w", err)
+		}
+		contextDialer, ok := dialer.(proxy.ContextDialer)
+		if !ok {
+			return nil, fmt.Errorf("SOCKS5 proxy dialer does not support contexts")
+		}
+		return contextDialer, nil
+	default:
+		return &httpConnectDialer{proxy: u, forward: direct}, nil
+	}
+}
+
+// outboundProxy returns the proxy to dial through for a connection that
+// asks for requested, falling back to the configured proxy. A proxy of the
+// user's choosing, or "direct", is refused unless the configuration allows
+// per-connection proxies.
+func outboundProxy(cfg *config.Config, requested string) (string, error) {
+	if requested == "" {
+		return cfg.SSH.Proxy, nil
+	}
+	if !cfg.ConnectionProxyAllowed() {
+		return "", models.ErrProxyNotAllowed
+	}
+	return requested, nil
+}
+
+// ParseProxyURL parses and validates a proxy URL
+func ParseProxyURL(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", models.ErrInvalidProxy, err)
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return nil, fmt.Errorf("%w: unsupported scheme %q (use socks5 or http)", models.ErrInvalidProxy, u.Scheme)
+	}
+
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%w: no host", models.ErrInvalidProxy)
+	}
+	if u.Port() == "" {
+		if u.Scheme == "http" {
+			u.Host = net.JoinHostPort(u.Hostname(), "8080")
+		} else {
+			u.Host = net.JoinHostPort(u.Hostname(), "1080")
+		}
+	}
+
+	return u, nil
+}
+
+// RedactProxyURL removes credentials from a proxy URL for display
+func RedactProxyURL(proxyURL string) string {
+	if proxyURL == "" || proxyURL == ProxyDirect {
+		return ""
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	return u.String()
+}
+
+// httpConnectDialer tunnels TCP connections through an HTTP proxy using
+// the CONNECT method
+type httpConnectDialer struct {
+	proxy   *url.URL
+	forward *net.Dialer
+}
+
+// DialContext implements contextDialer
+func (d *httpConnectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.forward.DialContext(ctx, network, d.proxy.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy: %w", err)
+	}
+
+	// Bound the CONNECT exchange by the dial deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if d.proxy.User != nil {
+		password, _ := d.proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(d.proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT request: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s", models.ErrProxyRefused, strings.TrimSpace(resp.Status))
+	}
+
+	// The SSH server may speak first, so keep anything already buffered
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn is a net.Conn whose reads drain a bufio.Reader first
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read implements net.Conn
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
@@ -0,0 +1,332 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"sftp-gui/internal/models"
+)
+
+const testBanner = "SSH-2.0-target\r\n"
+
+// listen starts a local listener that serves each connection with handle
+func listen(t *testing.T, handle func(conn net.Conn)) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// startTarget starts a server that, like an SSH server, speaks first and
+// then echoes what it is sent
+func startTarget(t *testing.T) string {
+	return listen(t, func(conn net.Conn) {
+		io.WriteString(conn, testBanner)
+		io.Copy(conn, conn)
+	})
+}
+
+// tunnel copies between two connections until either side closes
+func tunnel(client net.Conn, clientReader io.Reader, target net.Conn) {
+	defer target.Close()
+	go func() {
+		io.Copy(target, clientReader)
+		target.Close()
+	}()
+	io.Copy(client, target)
+}
+
+// startSOCKS5Proxy starts a SOCKS5 proxy for CONNECT requests; with a
+// username it requires username/password authentication
+func startSOCKS5Proxy(t *testing.T, username, password string) string {
+	return listen(t, func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+
+		var greeting [2]byte
+		if _, err := io.ReadFull(reader, greeting[:]); err != nil || greeting[0] != 5 {
+			return
+		}
+		methods := make([]byte, greeting[1])
+		if _, err := io.ReadFull(reader, methods); err != nil {
+			return
+		}
+
+		want := byte(0x00)
+		if username != "" {
+			want = 0x02
+		}
+		offered := false
+		for _, method := range methods {
+			offered = offered || method == want
+		}
+		if !offered {
+			conn.Write([]byte{5, 0xff})
+			return
+		}
+		conn.Write([]byte{5, want})
+
+		if username != "" {
+			readField := func() string {
+				length, err := reader.ReadByte()
+				if err != nil {
+					return ""
+				}
+				field := make([]byte, length)
+				io.ReadFull(reader, field)
+				return string(field)
+			}
+			if version, err := reader.ReadByte(); err != nil || version != 1 {
+				return
+			}
+			if readField() != username || readField() != password {
+				conn.Write([]byte{1, 1})
+				return
+			}
+			conn.Write([]byte{1, 0})
+		}
+
+		var request [4]byte
+		if _, err := io.ReadFull(reader, request[:]); err != nil || request[1] != 1 {
+			return
+		}
+		var host string
+		switch request[3] {
+		case 1:
+			ip := make([]byte, net.IPv4len)
+			io.ReadFull(reader, ip)
+			host = net.IP(ip).String()
+		case 3:
+			length, _ := reader.ReadByte()
+			name := make([]byte, length)
+			io.ReadFull(reader, name)
+			host = string(name)
+		case 4:
+			ip := make([]byte, net.IPv6len)
+			io.ReadFull(reader, ip)
+			host = net.IP(ip).String()
+		default:
+			return
+		}
+		var port [2]byte
+		if _, err := io.ReadFull(reader, port[:]); err != nil {
+			return
+		}
+
+		target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))))
+		if err != nil {
+			conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		tunnel(conn, reader, target)
+	})
+}
+
+// startHTTPProxy starts an HTTP proxy for CONNECT requests; with a
+// username it requires basic proxy authorization. The target's first
+// bytes are sent in the same write as the response, as a proxy may do,
+// so the client has to keep what it buffered past the headers.
+func startHTTPProxy(t *testing.T, username, password string) string {
+	return listen(t, func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+		if req.Method != http.MethodConnect {
+			io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+			return
+		}
+
+		if username != "" {
+			want := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+			if req.Header.Get("Proxy-Authorization") != want {
+				io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic realm=\"test\"\r\n\r\n")
+				return
+			}
+		}
+
+		target, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			return
+		}
+		banner := make([]byte, len(testBanner))
+		if _, err := io.ReadFull(target, banner); err != nil {
+			target.Close()
+			return
+		}
+		conn.Write(append([]byte("HTTP/1.1 200 Connection established\r\n\r\n"), banner...))
+		tunnel(conn, reader, target)
+	})
+}
+
+// proxyURL builds a proxy URL, with credentials when a username is given
+func proxyURL(scheme, addr, username, password string) string {
+	u := &url.URL{Scheme: scheme, Host: addr}
+	if username != "" {
+		u.User = url.UserPassword(username, password)
+	}
+	return u.String()
+}
+
+// dialViaProxy dials the target through a proxy
+func dialViaProxy(t *testing.T, proxy, target string) (net.Conn, error) {
+	t.Helper()
+
+	dialer, err := newDialer(proxy, 5*time.Second)
+	if err != nil {
+		t.Fatalf("newDialer(%q): %v", RedactProxyURL(proxy), err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return dialer.DialContext(ctx, "tcp", target)
+}
+
+// checkTunnel checks that a connection reaches the target: the banner
+// arrives first and what is sent comes back
+func checkTunnel(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	if banner, err := reader.ReadString('\n'); err != nil || banner != testBanner {
+		t.Fatalf("banner = %q, %v; want %q", banner, err, testBanner)
+	}
+	io.WriteString(conn, "ping\n")
+	if echo, err := reader.ReadString('\n'); err != nil || echo != "ping\n" {
+		t.Fatalf("echo = %q, %v; want %q", echo, err, "ping\n")
+	}
+}
+
+// proxyCases are the credentials a proxy requires and a client presents
+var proxyCases = []struct {
+	name                   string
+	proxyUser, proxyPass   string
+	clientUser, clientPass string
+	refused                bool
+}{
+	{name: "no auth"},
+	{name: "auth", proxyUser: "alice", proxyPass: "p@ss:word", clientUser: "alice", clientPass: "p@ss:word"},
+	{name: "wrong password", proxyUser: "alice", proxyPass: "p@ss:word", clientUser: "alice", clientPass: "guess", refused: true},
+	{name: "no credentials", proxyUser: "alice", proxyPass: "p@ss:word", refused: true},
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	target := startTarget(t)
+
+	for _, scheme := range []string{"socks5", "socks5h"} {
+		for _, tc := range proxyCases {
+			t.Run(scheme+" "+tc.name, func(t *testing.T) {
+				proxy := proxyURL(scheme, startSOCKS5Proxy(t, tc.proxyUser, tc.proxyPass), tc.clientUser, tc.clientPass)
+
+				conn, err := dialViaProxy(t, proxy, target)
+				if tc.refused {
+					if err == nil {
+						conn.Close()
+						t.Fatal("dial succeeded, want the proxy to refuse it")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("dial: %v", err)
+				}
+				defer conn.Close()
+				checkTunnel(t, conn)
+			})
+		}
+	}
+}
+
+func TestSOCKS5ProxyResolvesHostNames(t *testing.T) {
+	_, port, _ := net.SplitHostPort(startTarget(t))
+	proxy := proxyURL("socks5h", startSOCKS5Proxy(t, "", ""), "", "")
+
+	conn, err := dialViaProxy(t, proxy, net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	checkTunnel(t, conn)
+}
+
+func TestHTTPConnectProxy(t *testing.T) {
+	target := startTarget(t)
+
+	for _, tc := range proxyCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy := proxyURL("http", startHTTPProxy(t, tc.proxyUser, tc.proxyPass), tc.clientUser, tc.clientPass)
+
+			conn, err := dialViaProxy(t, proxy, target)
+			if tc.refused {
+				if !errors.Is(err, models.ErrProxyRefused) {
+					t.Fatalf("error = %v, want ErrProxyRefused", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer conn.Close()
+			checkTunnel(t, conn)
+		})
+	}
+}
+
+func TestHTTPConnectProxyUnreachableTarget(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	closed := listener.Addr().String()
+	listener.Close()
+
+	_, err = dialViaProxy(t, proxyURL("http", startHTTPProxy(t, "", ""), "", ""), closed)
+	if !errors.Is(err, models.ErrProxyRefused) {
+		t.Fatalf("error = %v, want ErrProxyRefused", err)
+	}
+}
+
+func TestProxyUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	closed := listener.Addr().String()
+	listener.Close()
+
+	for _, scheme := range []string{"socks5", "http"} {
+		if conn, err := dialViaProxy(t, proxyURL(scheme, closed, "", ""), startTarget(t)); err == nil {
+			conn.Close()
+			t.Errorf("%s: dial through a closed port succeeded", scheme)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"sftp-gui/internal/models"
+)
+
+// sessionQuota limits the connections that match it
+type sessionQuota struct {
+	limit   int
+	matches func(session *models.Session) bool
+	err     error
+}
+
+// sessionQuotas returns the limits a new connection for req counts against
+func (s *SessionService) sessionQuotas(req *models.LoginRequest) []sessionQuota {
+	quotas := []sessionQuota{{
+		limit:   s.config.Session.MaxSessions,
+		matches: func(*models.Session) bool { return true },
+		err:     models.ErrTooManySessions,
+	}}
+
+	if limit := s.config.Session.MaxSessionsPerUser; limit > 0 && req.AppUser != "" {
+		quotas = append(quotas, sessionQuota{
+			limit:   limit,
+			matches: func(session *models.Session) bool { return session.AppUser == req.AppUser },
+			err:     models.ErrUserSessionQuota,
+		})
+	}
+	if limit := s.config.Session.MaxSessionsPerHost; limit > 0 {
+		quotas = append(quotas, sessionQuota{
+			limit:   limit,
+			matches: func(session *models.Session) bool { return strings.EqualFold(session.Host, req.Host) },
+			err:     models.ErrHostSessionQuota,
+		})
+	}
+
+	return quotas
+}
+
+// admitSession makes room for a new connection under every limit it
+// counts against, closing the least recently used idle connections that
+// stand in the way. Without evict nothing is closed; it only reports
+// whether the connection would be admitted, so a login can be refused
+// before dialing. The caller must hold the mutex, for writing if evict is
+// set.
+func (s *SessionService) admitSession(req *models.LoginRequest, evict bool) error {
+	sessions := s.store.Sessions()
+	evicted := make(map[string]bool)
+
+	for _, quota := range s.sessionQuotas(req) {
+		var counted []*models.Session
+		for _, session := range sessions {
+			if !evicted[session.ID] && quota.matches(session) {
+				counted = append(counted, session)
+			}
+		}
+
+		for open := len(counted); open >= quota.limit; open-- {
+			victim := s.evictionCandidate(counted, evicted)
+			if victim == nil {
+				return quota.err
+			}
+			evicted[victim.ID] = true
+		}
+	}
+
+	if evict {
+		for id := range evicted {
+			s.evictSession(id)
+		}
+	}
+	return nil
+}
+
+// evictionCandidate returns the least recently used connection that has
+// been idle for EvictIdle and is not busy with a file operation, or nil
+func (s *SessionService) evictionCandidate(sessions []*models.Session, evicted map[string]bool) *models.Session {
+	idle := s.config.Session.EvictIdle
+	if idle <= 0 {
+		return nil
+	}
+
+	var candidate *models.Session
+	for _, session := range sessions {
+		if evicted[session.ID] || session.Operation() != "" || time.Since(session.LastAccess) < idle {
+			continue
+		}
+		if candidate == nil || session.LastAccess.Before(candidate.LastAccess) {
+			candidate = session
+		}
+	}
+	return candidate
+}
+
+// evictSession closes an idle connection to make room for another and
+// tells its owner why. The caller must hold the mutex.
+func (s *SessionService) evictSession(sessionID string) {
+	session, exists := s.store.Session(sessionID)
+	if !exists {
+		return
+	}
+
+	fmt.Printf("Evicted session %s of %s to %s, idle since %s\n",
+		session.ID, session.AppUser, session.Host, session.LastAccess.Format(time.RFC3339))
+
+	if webSession, ok := s.store.WebSession(session.WebSessionID); ok {
+		webSession.Notices = append(webSession.Notices, fmt.Sprintf(
+			"Your connection %s was closed because it was not used for a while and room was needed for another; connect again to continue",
+			session.Name))
+		s.store.SaveWebSession(webSession)
+	}
+	s.removeSession(sessionID)
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+// openConnection describes a connection already open when a login asks
+// to be admitted
+type openConnection struct {
+	id      string
+	appUser string
+	host    string
+	idle    time.Duration
+	busy    bool
+}
+
+func TestAdmitSession(t *testing.T) {
+	login := &models.LoginRequest{Host: "files.example", Port: 22, Username: "deploy", AppUser: "alice"}
+
+	tests := []struct {
+		name string
+		// session holds the limits and EvictIdle
+		session config.SessionConfig
+		open    []openConnection
+		evict   bool
+		wantErr error
+		// wantEvicted lists the connections closed to make room
+		wantEvicted []string
+	}{
+		{
+			name:    "room left",
+			session: config.SessionConfig{MaxSessions: 3, EvictIdle: time.Minute},
+			open:    []openConnection{{id: "a", idle: time.Hour}, {id: "b", idle: time.Hour}},
+			evict:   true,
+		},
+		{
+			name:    "least recently used is evicted",
+			session: config.SessionConfig{MaxSessions: 2, EvictIdle: time.Minute},
+			open: []openConnection{
+				{id: "a", idle: 10 * time.Minute},
+				{id: "b", idle: time.Hour},
+			},
+			evict:       true,
+			wantEvicted: []string{"b"},
+		},
+		{
+			name:    "busy connection is skipped",
+			session: config.SessionConfig{MaxSessions: 2, EvictIdle: time.Minute},
+			open: []openConnection{
+				{id: "a", idle: 10 * time.Minute},
+				{id: "b", idle: time.Hour, busy: true},
+			},
+			evict:       true,
+			wantEvicted: []string{"a"},
+		},
+		{
+			name:    "recently used connections are kept",
+			session: config.SessionConfig{MaxSessions: 2, EvictIdle: time.Hour},
+			open: []openConnection{
+				{id: "a", idle: 10 * time.Minute},
+				{id: "b", idle: 30 * time.Minute},
+			},
+			evict:   true,
+			wantErr: models.ErrTooManySessions,
+		},
+		{
+			name:    "eviction disabled",
+			session: config.SessionConfig{MaxSessions: 1},
+			open:    []openConnection{{id: "a", idle: 24 * time.Hour}},
+			evict:   true,
+			wantErr: models.ErrTooManySessions,
+		},
+		{
+			name:    "check only closes nothing",
+			session: config.SessionConfig{MaxSessions: 1, EvictIdle: time.Minute},
+			open:    []openConnection{{id: "a", idle: time.Hour}},
+		},
+		{
+			name:    "evicts as many as needed",
+			session: config.SessionConfig{MaxSessions: 2, EvictIdle: time.Minute},
+			open: []openConnection{
+				{id: "a", idle: time.Hour},
+				{id: "b", idle: 2 * time.Hour},
+				{id: "c", idle: 3 * time.Hour},
+			},
+			evict:       true,
+			wantEvicted: []string{"b", "c"},
+		},
+		{
+			name:    "user quota evicts only the user's connections",
+			session: config.SessionConfig{MaxSessions: 10, MaxSessionsPerUser: 1, EvictIdle: time.Minute},
+			open: []openConnection{
+				{id: "a", appUser: "alice", idle: time.Hour},
+				{id: "b", appUser: "bob", idle: 2 * time.Hour},
+			},
+			evict:       true,
+			wantEvicted: []string{"a"},
+		},
+		{
+			name:    "user quota reached",
+			session: config.SessionConfig{MaxSessions: 10, MaxSessionsPerUser: 1, EvictIdle: time.Hour},
+			open:    []openConnection{{id: "a", appUser: "alice", idle: time.Minute}},
+			evict:   true,
+			wantErr: models.ErrUserSessionQuota,
+		},
+		{
+			name:    "host quota compares names without case",
+			session: config.SessionConfig{MaxSessions: 10, MaxSessionsPerHost: 1, EvictIdle: time.Minute},
+			open: []openConnection{
+				{id: "a", appUser: "bob", host: "FILES.example", idle: time.Hour},
+				{id: "b", appUser: "bob", host: "other.example", idle: 2 * time.Hour},
+			},
+			evict:       true,
+			wantEvicted: []string{"a"},
+		},
+		{
+			name:    "host quota reached",
+			session: config.SessionConfig{MaxSessions: 10, MaxSessionsPerHost: 1, EvictIdle: time.Minute},
+			open:    []openConnection{{id: "a", appUser: "bob", host: "files.example", idle: time.Hour, busy: true}},
+			evict:   true,
+			wantErr: models.ErrHostSessionQuota,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Session.MaxSessions = tt.session.MaxSessions
+			cfg.Session.MaxSessionsPerUser = tt.session.MaxSessionsPerUser
+			cfg.Session.MaxSessionsPerHost = tt.session.MaxSessionsPerHost
+			cfg.Session.EvictIdle = tt.session.EvictIdle
+			sessions := newTestSessionService(t, cfg)
+
+			sessions.store.SaveWebSession(&models.WebSession{ID: "web-1"})
+			for _, open := range tt.open {
+				session := &models.Session{
+					ID:           open.id,
+					Name:         "connection " + open.id,
+					Host:         open.host,
+					AppUser:      open.appUser,
+					LastAccess:   time.Now().Add(-open.idle),
+					WebSessionID: "web-1",
+				}
+				if open.busy {
+					defer session.BeginOperation("upload")()
+				}
+				sessions.store.SaveSession(session)
+			}
+
+			sessions.mutex.Lock()
+			err := sessions.admitSession(login, tt.evict)
+			sessions.mutex.Unlock()
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("admitSession error = %v, want %v", err, tt.wantErr)
+			}
+
+			var evicted []string
+			for _, open := range tt.open {
+				if _, exists := sessions.store.Session(open.id); !exists {
+					evicted = append(evicted, open.id)
+				}
+			}
+			if !reflect.DeepEqual(evicted, tt.wantEvicted) {
+				t.Errorf("evicted %v, want %v", evicted, tt.wantEvicted)
+			}
+
+			webSession, _ := sessions.store.WebSession("web-1")
+			if len(webSession.Notices) != len(tt.wantEvicted) {
+				t.Fatalf("notices = %q, want one per evicted connection", webSession.Notices)
+			}
+			sort.Strings(webSession.Notices)
+			for i, id := range tt.wantEvicted {
+				if !strings.Contains(webSession.Notices[i], "connection "+id) {
+					t.Errorf("notice %q does not name connection %s", webSession.Notices[i], id)
+				}
+			}
+		})
+	}
+}
@@ -3,7 +3,9 @@ package services
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -14,28 +16,76 @@ import (
 	"sftp-gui/internal/models"
 )
 
-// SessionService manages SFTP sessions
+// pendingLoginTimeout bounds how long a login waits for user confirmation
+const pendingLoginTimeout = 5 * time.Minute
+
+// SessionService manages SFTP sessions and the browser sessions that own
+// them
 type SessionService struct {
-	sessions map[string]*models.Session
-	mutex    sync.RWMutex
-	config   *config.Config
+	store         SessionStore
+	pendingLogins map[string]*pendingLogin
+	loginFlows    map[string]*LoginFlow
+	mutex         sync.RWMutex
+	// reconnectMutex guards swapping the connections of broken sessions
+	reconnectMutex sync.Mutex
+	reconnecting   map[string]chan struct{}
+	config         *config.Config
+	hostKeys       *HostKeyService
+	// vault and sshConfig supply the credentials of connections restored
+	// after a restart
+	vault     *VaultService
+	sshConfig *SSHConfigService
+	// cookieKey signs session cookies
+	cookieKey []byte
+}
+
+// pendingLogin is a login request held while the user confirms a host key
+type pendingLogin struct {
+	request      *models.LoginRequest
+	webSessionID string
+	expiresAt    time.Time
 }
 
-// NewSessionService creates a new session service
-func NewSessionService(cfg *config.Config) *SessionService {
+// NewSessionService creates a new session service that keeps sessions in
+// store. Connections restored from the store are re-dialed from the vault
+// profile or ssh_config entry they were opened with.
+func NewSessionService(cfg *config.Config, hostKeys *HostKeyService, store SessionStore, vault *VaultService, sshConfig *SSHConfigService) (*SessionService, error) {
+	cookieKey, err := sessionCookieKey(cfg.Security.SessionSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	// Connections of restored sessions are re-dialed on first use
+	if webSessions := store.WebSessions(); len(webSessions) > 0 {
+		fmt.Printf("Restored %d browser sessions with %d connections\n", len(webSessions), len(store.Sessions()))
+	}
+
 	service := &SessionService{
-		sessions: make(map[string]*models.Session),
-		config:   cfg,
+		store:         store,
+		pendingLogins: make(map[string]*pendingLogin),
+		loginFlows:    make(map[string]*LoginFlow),
+		reconnecting:  make(map[string]chan struct{}),
+		config:        cfg,
+		hostKeys:      hostKeys,
+		vault:         vault,
+		sshConfig:     sshConfig,
+		cookieKey:     cookieKey,
 	}
 
 	// Start cleanup goroutine
 	go service.cleanupExpiredSessions()
 
-	return service
+	return service, nil
 }
 
 // CreateSession creates a new SFTP session
 func (s *SessionService) CreateSession(req *models.LoginRequest) (*models.Session, error) {
+	return s.createSession(req, nil)
+}
+
+// createSession dials the SSH server and opens an SFTP session. flow relays
+// keyboard-interactive prompts to the user and may be nil.
+func (s *SessionService) createSession(req *models.LoginRequest, flow *LoginFlow) (*models.Session, error) {
 	// Validate request
 	if err := req.Validate(); err != nil {
 		return nil, err
@@ -43,87 +93,377 @@ func (s *SessionService) CreateSession(req *models.LoginRequest) (*models.Sessio
 
 	// Check session limits
 	s.mutex.RLock()
-	if len(s.sessions) >= s.config.Session.MaxSessions {
-		s.mutex.RUnlock()
-		return nil, fmt.Errorf("maximum number of sessions reached")
-	}
+	err := s.admitSession(req, false)
 	s.mutex.RUnlock()
-
-	// Create SSH client config
-	sshConfig := &ssh.ClientConfig{
-		User: req.Username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(req.Password),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // Note: In production, use proper host key verification
-		Timeout:         30 * time.Second,
-	}
-
-	// Connect to SSH server
-	addr := fmt.Sprintf("%s:%d", req.Host, req.Port)
-	sshClient, err := ssh.Dial("tcp", addr, sshConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to SSH server: %w", err)
+		return nil, err
 	}
 
-	// Create SFTP client
-	sftpClient, err := sftp.NewClient(sshClient)
+	conn, err := s.dialSession(req, flow)
 	if err != nil {
-		sshClient.Close()
-		return nil, fmt.Errorf("failed to create SFTP client: %w", err)
+		return nil, err
 	}
 
-	// Get home directory
-	homeDir, err := sftpClient.Getwd()
-	if err != nil {
-		homeDir = "/"
+	// Get home directory, unless the connection asks for another start path
+	homeDir := req.DefaultPath
+	if homeDir == "" {
+		if homeDir, err = conn.sftpClient.Getwd(); err != nil {
+			homeDir = "/"
+		}
 	}
 
 	// Generate session ID
 	sessionID, err := s.generateSessionID()
 	if err != nil {
-		sftpClient.Close()
-		sshClient.Close()
+		conn.close()
 		return nil, fmt.Errorf("failed to generate session ID: %w", err)
 	}
 
-	// Create session
+	name := req.Name
+	if name == "" {
+		name = fmt.Sprintf("%s@%s", req.Username, req.Host)
+	}
+
+	// The dial went through, so the proxy was allowed
+	proxyURL, _ := outboundProxy(s.config, req.Proxy)
+
+	// Create session. The owner is recorded now, not when the connection
+	// is added to a browser session, so per-user quotas count it at once
 	session := &models.Session{
-		ID:         sessionID,
-		SSHClient:  sshClient,
-		SFTPClient: sftpClient,
-		CreatedAt:  time.Now(),
-		LastAccess: time.Now(),
-		HomeDir:    homeDir,
-		Username:   req.Username,
-		Host:       req.Host,
-		Port:       req.Port,
-		IsActive:   true,
-	}
-
-	// Store session
+		ID:          sessionID,
+		Name:        name,
+		SSHClient:   conn.sshClient,
+		SFTPClient:  conn.sftpClient,
+		CreatedAt:   time.Now(),
+		LastAccess:  time.Now(),
+		HomeDir:     homeDir,
+		Username:    req.Username,
+		Host:        req.Host,
+		Port:        req.Port,
+		AuthMethod:  authMethodName(req),
+		IsActive:    true,
+		JumpHosts:   req.JumpHostStrings(),
+		JumpClients: conn.jumpClients,
+		Proxy:       RedactProxyURL(proxyURL),
+		Algorithms:  conn.algorithms,
+		Credentials: req,
+		AppUser:     req.AppUser,
+		ProfileID:   req.ProfileID,
+		SSHHost:     req.SSHHost,
+	}
+
+	// Store the session, making room for it if other logins took the
+	// free slots while dialing
 	s.mutex.Lock()
-	s.sessions[sessionID] = session
+	if err := s.admitSession(req, true); err != nil {
+		s.mutex.Unlock()
+		conn.close()
+		return nil, err
+	}
+	s.store.SaveSession(session)
 	s.mutex.Unlock()
 
+	go s.keepalive(session, conn.sshClient)
+
 	return session, nil
 }
 
-// GetSession retrieves a session by ID
-func (s *SessionService) GetSession(sessionID string) (*models.Session, error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+// connection is an SSH connection to a server with its SFTP client
+type connection struct {
+	sshClient   *ssh.Client
+	jumpClients []*ssh.Client
+	sftpClient  *sftp.Client
+	algorithms  *models.NegotiatedAlgorithms
+}
 
-	session, exists := s.sessions[sessionID]
+// close closes the SFTP client, the target connection and then its jump hosts
+func (c *connection) close() {
+	if c.sftpClient != nil {
+		c.sftpClient.Close()
+	}
+	c.sshClient.Close()
+	for i := len(c.jumpClients) - 1; i >= 0; i-- {
+		c.jumpClients[i].Close()
+	}
+}
+
+// dialSession connects to the SSH server, through the jump hosts if any,
+// and opens the SFTP subsystem
+func (s *SessionService) dialSession(req *models.LoginRequest, flow *LoginFlow) (*connection, error) {
+	options := s.connectionOptions(req)
+	if err := ValidateAlgorithms(options); err != nil {
+		return nil, err
+	}
+
+	sshClient, jumpClients, recorder, err := s.dialChain(req, flow, options)
+	if err != nil {
+		return nil, err
+	}
+	conn := &connection{
+		sshClient:   sshClient,
+		jumpClients: jumpClients,
+		algorithms:  recorder.Negotiated(),
+	}
+
+	conn.sftpClient, err = sftp.NewClient(sshClient, sftpClientOptions(options)...)
+	if err != nil {
+		conn.close()
+		return nil, fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+
+	return conn, nil
+}
+
+// reconnect re-dials a session whose connection was found to be dead,
+// using the credentials kept in memory, or those of its vault profile or
+// ssh_config entry if it was restored after a restart. Concurrent requests
+// for the same session wait for a single attempt.
+func (s *SessionService) reconnect(session *models.Session) error {
+	s.reconnectMutex.Lock()
+	if !session.IsBroken() {
+		s.reconnectMutex.Unlock()
+		return nil
+	}
+	if wait, inProgress := s.reconnecting[session.ID]; inProgress {
+		s.reconnectMutex.Unlock()
+		<-wait
+		if session.IsBroken() {
+			return models.ErrConnectionLost
+		}
+		return nil
+	}
+	credentials := session.Credentials
+	if credentials == nil && !session.Restored {
+		s.reconnectMutex.Unlock()
+		return models.ErrConnectionLost
+	}
+	done := make(chan struct{})
+	s.reconnecting[session.ID] = done
+	s.reconnectMutex.Unlock()
+
+	var conn *connection
+	var err error
+	if credentials == nil {
+		credentials, err = s.restoredCredentials(session)
+	}
+	if err == nil {
+		conn, err = s.dialSession(credentials, nil)
+	}
+
+	s.reconnectMutex.Lock()
+	defer s.reconnectMutex.Unlock()
+	delete(s.reconnecting, session.ID)
+	defer close(done)
+
+	if err != nil {
+		fmt.Printf("Failed to reconnect session %s to %s: %v\n", session.ID, session.Host, err)
+		if errors.Is(err, models.ErrLoginRequired) || errors.Is(err, models.ErrRestoreVaultLocked) {
+			return err
+		}
+		return models.ErrConnectionLost
+	}
+
+	// The user may have logged out while we were dialing
+	if !session.IsActive {
+		conn.close()
+		return models.ErrSessionNotFound
+	}
+
+	session.Reconnected(conn.sshClient, conn.sftpClient, conn.jumpClients)
+	session.Algorithms = conn.algorithms
+	session.Credentials = credentials
+	session.Restored = false
+	fmt.Printf("Reconnected session %s to %s\n", session.ID, session.Host)
+
+	go s.keepalive(session, conn.sshClient)
+
+	return nil
+}
+
+// restoredCredentials looks up the credentials of a connection restored
+// after a restart: its vault profile, once the owner has unlocked the
+// vault again, or the server's ssh_config entry. They must still lead to
+// the server and account the connection was opened to.
+func (s *SessionService) restoredCredentials(session *models.Session) (*models.LoginRequest, error) {
+	s.mutex.RLock()
+	webSession, exists := s.store.WebSession(session.WebSessionID)
+	var appUser, role string
+	if exists {
+		appUser, role = webSession.Username, webSession.Role
+	}
+	s.mutex.RUnlock()
 	if !exists {
 		return nil, models.ErrSessionNotFound
 	}
 
-	if session.IsExpired(s.config.Session.Timeout) {
+	var req *models.LoginRequest
+	switch {
+	case session.ProfileID != "" && s.vault != nil:
+		profile, err := s.vault.Get(session.WebSessionID, session.ProfileID)
+		if errors.Is(err, models.ErrVaultLocked) {
+			return nil, models.ErrRestoreVaultLocked
+		}
+		if err != nil {
+			return nil, models.ErrLoginRequired
+		}
+		req = profile.LoginRequest()
+	case session.SSHHost != "" && s.sshConfig != nil:
+		host, key, err := s.sshConfig.Identity(session.SSHHost)
+		if err != nil || host == nil {
+			return nil, models.ErrLoginRequired
+		}
+		req = &models.LoginRequest{
+			Host:       host.Host,
+			Port:       host.Port,
+			Username:   session.Username,
+			PrivateKey: key,
+			SSHHost:    session.SSHHost,
+		}
+		if host.Username != "" {
+			req.Username = host.Username
+		}
+	default:
+		return nil, models.ErrLoginRequired
+	}
+
+	if req.Host != session.Host || req.Port != session.Port || req.Username != session.Username {
+		return nil, models.ErrLoginRequired
+	}
+	req.AppUser, req.Role = appUser, role
+
+	return req, nil
+}
+
+// keepalive sends keepalive requests on a session's SSH connection and
+// marks the session broken once the connection dies
+func (s *SessionService) keepalive(session *models.Session, client *ssh.Client) {
+	closed := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(closed)
+	}()
+
+	var tick <-chan time.Time
+	if interval := s.config.SSH.KeepaliveInterval; interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	missed := 0
+	for {
+		select {
+		case <-closed:
+			s.reconnectMutex.Lock()
+			// Only flag the connection the session is still using
+			if session.IsActive && session.SSHClient == client {
+				session.MarkBroken()
+				fmt.Printf("Connection of session %s to %s lost\n", session.ID, session.Host)
+			}
+			s.reconnectMutex.Unlock()
+			return
+		case <-tick:
+			if sendKeepalive(client, s.config.SSH.KeepaliveInterval) {
+				missed = 0
+				continue
+			}
+
+			missed++
+			if missed >= s.config.SSH.KeepaliveCountMax {
+				// Closing the client ends Wait, which marks the session
+				client.Close()
+			}
+		}
+	}
+}
+
+// sendKeepalive sends a keepalive request and reports whether the server
+// answered within the timeout. Servers reject the unknown request type, but
+// any reply proves the connection is alive.
+func sendKeepalive(client *ssh.Client, timeout time.Duration) bool {
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		return err == nil
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// HoldLogin keeps a login request of a browser session in memory while the
+// user confirms the server's host key and returns a token to resume it
+func (s *SessionService) HoldLogin(webSessionID string, req *models.LoginRequest) (string, error) {
+	token, err := s.generateSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	s.mutex.Lock()
+	s.pendingLogins[token] = &pendingLogin{
+		request:      req,
+		webSessionID: webSessionID,
+		expiresAt:    time.Now().Add(pendingLoginTimeout),
+	}
+	s.mutex.Unlock()
+
+	return token, nil
+}
+
+// ResumeLogin removes and returns a login request held by HoldLogin for
+// the same browser session
+func (s *SessionService) ResumeLogin(webSessionID, token string) (*models.LoginRequest, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	pending, exists := s.pendingLogins[token]
+	if !exists || pending.webSessionID != webSessionID {
+		return nil, models.ErrLoginExpired
+	}
+	delete(s.pendingLogins, token)
+
+	if time.Now().After(pending.expiresAt) {
+		return nil, models.ErrLoginExpired
+	}
+
+	return pending.request, nil
+}
+
+// GetSession retrieves a session by ID. Access times are only read and
+// written under the mutex, since the session store copies them.
+func (s *SessionService) GetSession(sessionID string) (*models.Session, error) {
+	s.mutex.RLock()
+	session, exists := s.store.Session(sessionID)
+	expired := exists && session.IsExpired(s.config.Session.Timeout)
+	s.mutex.RUnlock()
+	if !exists {
+		return nil, models.ErrSessionNotFound
+	}
+	if expired {
 		return nil, models.ErrSessionExpired
 	}
 
+	// Re-dial transparently if the connection dropped since the last
+	// request or was restored after a restart. A restored connection that
+	// cannot be re-dialed without the user is closed.
+	if session.IsBroken() {
+		if err := s.reconnect(session); err != nil {
+			if errors.Is(err, models.ErrLoginRequired) {
+				s.mutex.Lock()
+				s.removeSession(sessionID)
+				s.mutex.Unlock()
+			}
+			return nil, err
+		}
+	}
+
+	s.mutex.Lock()
 	session.UpdateAccess()
+	s.mutex.Unlock()
 	return session, nil
 }
 
@@ -132,18 +472,11 @@ func (s *SessionService) DeleteSession(sessionID string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	session, exists := s.sessions[sessionID]
-	if !exists {
+	if _, exists := s.store.Session(sessionID); !exists {
 		return models.ErrSessionNotFound
 	}
 
-	// Close connections
-	if err := session.Close(); err != nil {
-		// Log error but continue with deletion
-		fmt.Printf("Error closing session connections: %v\n", err)
-	}
-
-	delete(s.sessions, sessionID)
+	s.removeSession(sessionID)
 	return nil
 }
 
@@ -152,8 +485,9 @@ func (s *SessionService) ListSessions() []*models.Session {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	sessions := make([]*models.Session, 0, len(s.sessions))
-	for _, session := range s.sessions {
+	stored := s.store.Sessions()
+	sessions := make([]*models.Session, 0, len(stored))
+	for _, session := range stored {
 		if !session.IsExpired(s.config.Session.Timeout) {
 			sessions = append(sessions, session)
 		}
@@ -162,13 +496,74 @@ func (s *SessionService) ListSessions() []*models.Session {
 	return sessions
 }
 
+// ActiveSessions describes the unexpired connections for the admin
+// dashboard, oldest first
+func (s *SessionService) ActiveSessions() []models.ActiveSession {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	stored := s.store.Sessions()
+	sessions := make([]models.ActiveSession, 0, len(stored))
+	for _, session := range stored {
+		if session.IsExpired(s.config.Session.Timeout) {
+			continue
+		}
+
+		active := models.ActiveSession{
+			ID:               session.ID,
+			Name:             session.Name,
+			AppUser:          session.AppUser,
+			Host:             session.Host,
+			Port:             session.Port,
+			Username:         session.Username,
+			JumpHosts:        session.JumpHosts,
+			CreatedAt:        session.CreatedAt,
+			LastAccess:       session.LastAccess,
+			BytesTransferred: session.Transferred(),
+			Operation:        session.Operation(),
+			Reconnects:       session.Reconnects,
+		}
+		if webSession, ok := s.store.WebSession(session.WebSessionID); ok {
+			active.ClientIP = webSession.ClientIP
+		}
+		sessions = append(sessions, active)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+
+	return sessions
+}
+
+// TerminateSession closes a connection on behalf of an administrator. The
+// notice is shown to the user who owned it.
+func (s *SessionService) TerminateSession(sessionID, notice string) (*models.Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, exists := s.store.Session(sessionID)
+	if !exists {
+		return nil, models.ErrSessionNotFound
+	}
+
+	if webSession, ok := s.store.WebSession(session.WebSessionID); ok {
+		webSession.Notices = append(webSession.Notices, notice)
+		s.store.SaveWebSession(webSession)
+	}
+	s.removeSession(sessionID)
+
+	return session, nil
+}
+
 // GetStats returns session statistics
 func (s *SessionService) GetStats() models.SessionStats {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
+	sessions := s.store.Sessions()
 	activeSessions := 0
-	for _, session := range s.sessions {
+	for _, session := range sessions {
 		if !session.IsExpired(s.config.Session.Timeout) && session.IsActive {
 			activeSessions++
 		}
@@ -176,7 +571,7 @@ func (s *SessionService) GetStats() models.SessionStats {
 
 	return models.SessionStats{
 		ActiveSessions: activeSessions,
-		TotalSessions:  len(s.sessions),
+		TotalSessions:  len(sessions),
 	}
 }
 
@@ -186,23 +581,53 @@ func (s *SessionService) CleanupExpiredSessions() int {
 	defer s.mutex.Unlock()
 
 	var expiredSessions []string
-	for id, session := range s.sessions {
+	for _, session := range s.store.Sessions() {
 		if session.IsExpired(s.config.Session.Timeout) {
-			expiredSessions = append(expiredSessions, id)
+			expiredSessions = append(expiredSessions, session.ID)
 		}
 	}
 
+	// Connections expire on their own, before the browser session that
+	// owns them
 	for _, id := range expiredSessions {
-		session := s.sessions[id]
-		if err := session.Close(); err != nil {
-			fmt.Printf("Error closing expired session %s: %v\n", id, err)
+		s.removeSession(id)
+	}
+	for _, webSession := range s.store.WebSessions() {
+		if s.webSessionExpired(webSession) {
+			s.removeWebSession(webSession)
+		}
+	}
+
+	// Drop logins that were never confirmed or completed
+	now := time.Now()
+	for token, pending := range s.pendingLogins {
+		if now.After(pending.expiresAt) {
+			delete(s.pendingLogins, token)
+		}
+	}
+	for id, flow := range s.loginFlows {
+		if flow.finished() && now.After(flow.expiresAt) {
+			delete(s.loginFlows, id)
 		}
-		delete(s.sessions, id)
+	}
+
+	// Save the access times recorded since the last change
+	if err := s.store.Flush(); err != nil {
+		fmt.Printf("Failed to save sessions: %v\n", err)
 	}
 
 	return len(expiredSessions)
 }
 
+// Close saves the sessions for the next start. It is called when the
+// server shuts down; the SSH connections close with the process.
+func (s *SessionService) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.store.Close()
+}
+
 // generateSessionID generates a random session ID
 func (s *SessionService) generateSessionID() (string, error) {
 	bytes := make([]byte, 16)
@@ -0,0 +1,470 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+// SessionStore keeps SFTP sessions and the browser sessions that own them.
+// SessionService serialises all calls with its mutex and saves a session
+// again after changing it. Saves, deletes, Flush and Close are called with
+// the mutex held for writing, so stores that persist sessions copy them
+// there and may finish writing after the mutex is released.
+type SessionStore interface {
+	Session(id string) (*models.Session, bool)
+	Sessions() []*models.Session
+	SaveSession(session *models.Session)
+	DeleteSession(id string)
+
+	WebSession(id string) (*models.WebSession, bool)
+	WebSessions() []*models.WebSession
+	SaveWebSession(webSession *models.WebSession)
+	DeleteWebSession(id string)
+
+	Flush() error
+	Close() error
+}
+
+// NewSessionStore creates the session store selected by the configuration
+func NewSessionStore(cfg *config.Config) (SessionStore, error) {
+	switch cfg.Session.Store {
+	case config.SessionStoreDisk:
+		store, err := NewDiskSessionStore(cfg.Session.StoreFile, cfg.Security.SessionSecret)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return NewMemorySessionStore(), nil
+	}
+}
+
+// MemorySessionStore keeps sessions in memory only, so they are lost when
+// the server stops
+type MemorySessionStore struct {
+	sessions    map[string]*models.Session
+	webSessions map[string]*models.WebSession
+}
+
+// NewMemorySessionStore creates an empty in-memory session store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions:    make(map[string]*models.Session),
+		webSessions: make(map[string]*models.WebSession),
+	}
+}
+
+// Session returns the SFTP session with the given ID
+func (m *MemorySessionStore) Session(id string) (*models.Session, bool) {
+	session, exists := m.sessions[id]
+	return session, exists
+}
+
+// Sessions returns all SFTP sessions
+func (m *MemorySessionStore) Sessions() []*models.Session {
+	sessions := make([]*models.Session, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// SaveSession adds or updates an SFTP session
+func (m *MemorySessionStore) SaveSession(session *models.Session) {
+	m.sessions[session.ID] = session
+}
+
+// DeleteSession removes an SFTP session
+func (m *MemorySessionStore) DeleteSession(id string) {
+	delete(m.sessions, id)
+}
+
+// WebSession returns the browser session with the given ID
+func (m *MemorySessionStore) WebSession(id string) (*models.WebSession, bool) {
+	webSession, exists := m.webSessions[id]
+	return webSession, exists
+}
+
+// WebSessions returns all browser sessions
+func (m *MemorySessionStore) WebSessions() []*models.WebSession {
+	webSessions := make([]*models.WebSession, 0, len(m.webSessions))
+	for _, webSession := range m.webSessions {
+		webSessions = append(webSessions, webSession)
+	}
+	return webSessions
+}
+
+// SaveWebSession adds or updates a browser session
+func (m *MemorySessionStore) SaveWebSession(webSession *models.WebSession) {
+	m.webSessions[webSession.ID] = webSession
+}
+
+// DeleteWebSession removes a browser session
+func (m *MemorySessionStore) DeleteWebSession(id string) {
+	delete(m.webSessions, id)
+}
+
+// Flush does nothing, since nothing is written anywhere
+func (m *MemorySessionStore) Flush() error {
+	return nil
+}
+
+// Close does nothing
+func (m *MemorySessionStore) Close() error {
+	return nil
+}
+
+// sessionStoreVersion is the current on-disk session store format
+const sessionStoreVersion = 1
+
+// sessionStoreFile is the encrypted session store as stored on disk
+type sessionStoreFile struct {
+	Version int       `json:"version"`
+	Nonce   []byte    `json:"nonce"`
+	Data    []byte    `json:"data"`
+	Updated time.Time `json:"updated"`
+}
+
+// storedSessions is the plaintext content of the session store file
+type storedSessions struct {
+	Sessions    []storedSession    `json:"sessions"`
+	WebSessions []storedWebSession `json:"web_sessions"`
+}
+
+// storedSession is what is kept of an SFTP session: enough to show it and
+// to find the vault profile or ssh_config entry it was opened with, but no
+// credentials
+type storedSession struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastAccess   time.Time `json:"last_access"`
+	HomeDir      string    `json:"home_dir"`
+	Username     string    `json:"username"`
+	Host         string    `json:"host"`
+	Port         int       `json:"port"`
+	AuthMethod   string    `json:"auth_method"`
+	JumpHosts    []string  `json:"jump_hosts,omitempty"`
+	Proxy        string    `json:"proxy,omitempty"`
+	WebSessionID string    `json:"web_session_id"`
+	AppUser      string    `json:"app_user"`
+	ProfileID    string    `json:"profile_id,omitempty"`
+	SSHHost      string    `json:"ssh_host,omitempty"`
+}
+
+// storedWebSession is a browser session as kept on disk
+type storedWebSession struct {
+	ID            string    `json:"id"`
+	Username      string    `json:"username"`
+	Role          string    `json:"role"`
+	CreatedAt     time.Time `json:"created_at"`
+	LastAccess    time.Time `json:"last_access"`
+	ConnectionIDs []string  `json:"connection_ids"`
+	ClientIP      string    `json:"client_ip"`
+	UserAgent     string    `json:"user_agent"`
+	CSRFToken     string    `json:"csrf_token"`
+	Notices       []string  `json:"notices,omitempty"`
+}
+
+// DiskSessionStore keeps sessions in memory and writes them to an
+// encrypted file whenever one is saved or deleted, and on every flush for
+// the access times, so browser sessions survive a restart.
+// Neither SSH connections nor credentials are saved: restored connections
+// are re-dialed when they are next used if they were opened from a vault
+// profile or the server's ssh_config; otherwise the user is asked to log
+// in to the server again.
+type DiskSessionStore struct {
+	*MemorySessionStore
+	path string
+	aead cipher.AEAD
+	// pending hands copies of the sessions to the writer goroutine, which
+	// closes done when it stops
+	pending chan storedSessions
+	done    chan struct{}
+	closed  bool
+}
+
+// NewDiskSessionStore opens the session store file at path, or starts an
+// empty store if it does not exist. The file is encrypted with a key
+// derived from secret; sessions saved under another secret are discarded.
+func NewDiskSessionStore(path, secret string) (*DiskSessionStore, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(secret), nil, []byte("sftp-gui session store")), key); err != nil {
+		return nil, fmt.Errorf("failed to derive session store key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise session store cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise session store cipher: %w", err)
+	}
+
+	store := &DiskSessionStore{
+		MemorySessionStore: NewMemorySessionStore(),
+		path:               path,
+		aead:               aead,
+		pending:            make(chan storedSessions, 1),
+		done:               make(chan struct{}),
+	}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	go store.writer()
+
+	return store, nil
+}
+
+// SaveSession adds or updates an SFTP session and writes the store
+func (d *DiskSessionStore) SaveSession(session *models.Session) {
+	d.MemorySessionStore.SaveSession(session)
+	d.Flush()
+}
+
+// DeleteSession removes an SFTP session and writes the store
+func (d *DiskSessionStore) DeleteSession(id string) {
+	d.MemorySessionStore.DeleteSession(id)
+	d.Flush()
+}
+
+// SaveWebSession adds or updates a browser session and writes the store
+func (d *DiskSessionStore) SaveWebSession(webSession *models.WebSession) {
+	d.MemorySessionStore.SaveWebSession(webSession)
+	d.Flush()
+}
+
+// DeleteWebSession removes a browser session and writes the store
+func (d *DiskSessionStore) DeleteWebSession(id string) {
+	d.MemorySessionStore.DeleteWebSession(id)
+	d.Flush()
+}
+
+// Flush copies the sessions and has them written in the background. The
+// caller must hold the session mutex for writing.
+func (d *DiskSessionStore) Flush() error {
+	if d.closed {
+		return nil
+	}
+
+	// Replace a copy the writer has not picked up yet
+	select {
+	case <-d.pending:
+	default:
+	}
+	d.pending <- d.snapshot()
+	return nil
+}
+
+// Close stops the writer and writes the sessions one last time for the
+// next start. The caller must hold the session mutex for writing.
+func (d *DiskSessionStore) Close() error {
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+
+	stored := d.snapshot()
+	close(d.pending)
+	<-d.done
+	return d.persist(stored)
+}
+
+// writer writes the copies handed over by Flush. A failed write is logged
+// and retried with the next flush, so requests never wait for the disk.
+func (d *DiskSessionStore) writer() {
+	defer close(d.done)
+
+	for stored := range d.pending {
+		if err := d.persist(stored); err != nil {
+			fmt.Printf("Failed to save sessions: %v\n", err)
+		}
+	}
+}
+
+// snapshot copies the sessions for writing. The caller must hold the
+// session mutex, which guards the access times.
+func (d *DiskSessionStore) snapshot() storedSessions {
+	var stored storedSessions
+	for _, session := range d.sessions {
+		stored.Sessions = append(stored.Sessions, newStoredSession(session))
+	}
+	for _, webSession := range d.webSessions {
+		stored.WebSessions = append(stored.WebSessions, newStoredWebSession(webSession))
+	}
+	return stored
+}
+
+// load reads the sessions saved in the store file
+func (d *DiskSessionStore) load() error {
+	data, err := os.ReadFile(d.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read session store: %w", err)
+	}
+
+	var file sessionStoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse session store: %w", err)
+	}
+	if file.Version != sessionStoreVersion {
+		return fmt.Errorf("unsupported session store format")
+	}
+
+	plaintext, err := d.aead.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		// The session secret was changed, which invalidates every cookie
+		fmt.Printf("Discarding saved sessions, which were encrypted with another session secret\n")
+		return nil
+	}
+
+	var stored storedSessions
+	if err := json.Unmarshal(plaintext, &stored); err != nil {
+		return fmt.Errorf("failed to parse saved sessions: %w", err)
+	}
+
+	for _, record := range stored.WebSessions {
+		d.MemorySessionStore.SaveWebSession(record.webSession())
+	}
+	for _, record := range stored.Sessions {
+		d.MemorySessionStore.SaveSession(record.session())
+	}
+
+	return nil
+}
+
+// persist encrypts a copy of the sessions and atomically replaces the
+// store file
+func (d *DiskSessionStore) persist(stored storedSessions) error {
+	plaintext, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("failed to encode sessions: %w", err)
+	}
+
+	nonce := make([]byte, d.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate session store nonce: %w", err)
+	}
+
+	data, err := json.Marshal(sessionStoreFile{
+		Version: sessionStoreVersion,
+		Nonce:   nonce,
+		Data:    d.aead.Seal(nil, nonce, plaintext, nil),
+		Updated: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode session store: %w", err)
+	}
+
+	if dir := filepath.Dir(d.path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create session store directory: %w", err)
+		}
+	}
+
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write session store: %w", err)
+	}
+	if err := os.Rename(tmp, d.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write session store: %w", err)
+	}
+
+	return nil
+}
+
+// newStoredSession records an SFTP session for the store file
+func newStoredSession(session *models.Session) storedSession {
+	return storedSession{
+		ID:           session.ID,
+		Name:         session.Name,
+		CreatedAt:    session.CreatedAt,
+		LastAccess:   session.LastAccess,
+		HomeDir:      session.HomeDir,
+		Username:     session.Username,
+		Host:         session.Host,
+		Port:         session.Port,
+		AuthMethod:   session.AuthMethod,
+		JumpHosts:    session.JumpHosts,
+		Proxy:        session.Proxy,
+		WebSessionID: session.WebSessionID,
+		AppUser:      session.AppUser,
+		ProfileID:    session.ProfileID,
+		SSHHost:      session.SSHHost,
+	}
+}
+
+// session restores an SFTP session without a connection or credentials.
+// It is marked broken, so it is re-dialed on first use.
+func (s storedSession) session() *models.Session {
+	session := &models.Session{
+		ID:           s.ID,
+		Name:         s.Name,
+		CreatedAt:    s.CreatedAt,
+		LastAccess:   s.LastAccess,
+		HomeDir:      s.HomeDir,
+		Username:     s.Username,
+		Host:         s.Host,
+		Port:         s.Port,
+		AuthMethod:   s.AuthMethod,
+		IsActive:     true,
+		JumpHosts:    s.JumpHosts,
+		Proxy:        s.Proxy,
+		WebSessionID: s.WebSessionID,
+		AppUser:      s.AppUser,
+		ProfileID:    s.ProfileID,
+		SSHHost:      s.SSHHost,
+		Restored:     true,
+	}
+	session.MarkBroken()
+	return session
+}
+
+// newStoredWebSession records a browser session for the store file
+func newStoredWebSession(webSession *models.WebSession) storedWebSession {
+	return storedWebSession{
+		ID:            webSession.ID,
+		Username:      webSession.Username,
+		Role:          webSession.Role,
+		CreatedAt:     webSession.CreatedAt,
+		LastAccess:    webSession.LastAccess,
+		ConnectionIDs: webSession.ConnectionIDs,
+		ClientIP:      webSession.ClientIP,
+		UserAgent:     webSession.UserAgent,
+		CSRFToken:     webSession.CSRFToken,
+		Notices:       webSession.Notices,
+	}
+}
+
+// webSession restores a browser session
+func (s storedWebSession) webSession() *models.WebSession {
+	return &models.WebSession{
+		ID:            s.ID,
+		Username:      s.Username,
+		Role:          s.Role,
+		CreatedAt:     s.CreatedAt,
+		LastAccess:    s.LastAccess,
+		ConnectionIDs: s.ConnectionIDs,
+		ClientIP:      s.ClientIP,
+		UserAgent:     s.UserAgent,
+		CSRFToken:     s.CSRFToken,
+		Notices:       s.Notices,
+	}
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"sftp-gui/internal/models"
+)
+
+// newTestSessions returns a browser session owning one connection that was
+// opened from a vault profile with a password
+func newTestSessions() (*models.WebSession, *models.Session) {
+	now := time.Now().UTC().Truncate(time.Second)
+	webSession := &models.WebSession{
+		ID:            "web-1",
+		Username:      "alice",
+		Role:          "editor",
+		CreatedAt:     now.Add(-time.Hour),
+		LastAccess:    now,
+		ConnectionIDs: []string{"conn-1"},
+		ClientIP:      "192.0.2.10",
+		UserAgent:     "Mozilla/5.0",
+		CSRFToken:     "csrf-token",
+		Notices:       []string{"connection closed by an administrator"},
+	}
+	session := &models.Session{
+		ID:           "conn-1",
+		Name:         "staging",
+		CreatedAt:    now.Add(-time.Hour),
+		LastAccess:   now,
+		HomeDir:      "/home/deploy",
+		Username:     "deploy",
+		Host:         "staging.example",
+		Port:         2222,
+		AuthMethod:   "password",
+		IsActive:     true,
+		JumpHosts:    []string{"jump@bastion.example:22"},
+		Proxy:        "socks5://proxy.example:1080",
+		Credentials:  &models.LoginRequest{Host: "staging.example", Port: 2222, Username: "deploy", Password: "s3cret-password"},
+		WebSessionID: "web-1",
+		AppUser:      "alice",
+		ProfileID:    "profile-1",
+		SSHHost:      "staging",
+	}
+	return webSession, session
+}
+
+// openTestStore opens the store at path and closes it when the test ends
+func openTestStore(t *testing.T, path, secret string) *DiskSessionStore {
+	t.Helper()
+
+	store, err := NewDiskSessionStore(path, secret)
+	if err != nil {
+		t.Fatalf("NewDiskSessionStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestDiskSessionStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	webSession, session := newTestSessions()
+
+	store := openTestStore(t, path, "secret")
+	store.SaveWebSession(webSession)
+	store.SaveSession(session)
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	for _, plaintext := range []string{"s3cret-password", "staging.example", "csrf-token", "alice"} {
+		if strings.Contains(string(data), plaintext) {
+			t.Errorf("store file contains %q in plain text", plaintext)
+		}
+	}
+
+	restored := openTestStore(t, path, "secret")
+
+	gotWeb, ok := restored.WebSession("web-1")
+	if !ok {
+		t.Fatal("browser session was not restored")
+	}
+	if !reflect.DeepEqual(gotWeb, webSession) {
+		t.Errorf("browser session =\n%+v\nwant\n%+v", gotWeb, webSession)
+	}
+
+	got, ok := restored.Session("conn-1")
+	if !ok {
+		t.Fatal("connection was not restored")
+	}
+	if got.Credentials != nil {
+		t.Error("credentials were restored, want them never written")
+	}
+	if !got.Restored || !got.IsBroken() {
+		t.Errorf("Restored = %v, IsBroken = %v; want both set so the connection is re-dialed", got.Restored, got.IsBroken())
+	}
+	if !reflect.DeepEqual(newStoredSession(got), newStoredSession(session)) {
+		t.Errorf("connection =\n%+v\nwant\n%+v", newStoredSession(got), newStoredSession(session))
+	}
+}
+
+func TestDiskSessionStoreLoad(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare writes the store file before it is opened again
+		prepare      func(t *testing.T, path string)
+		secret       string
+		wantErr      bool
+		wantSessions int
+	}{
+		{name: "same secret", secret: "secret", wantSessions: 1},
+		{name: "other secret discards the sessions", secret: "changed", wantSessions: 0},
+		{
+			name:   "missing file starts empty",
+			secret: "secret",
+			prepare: func(t *testing.T, path string) {
+				if err := os.Remove(path); err != nil {
+					t.Fatalf("Remove: %v", err)
+				}
+			},
+		},
+		{
+			name:   "deleted connection stays deleted",
+			secret: "secret",
+			prepare: func(t *testing.T, path string) {
+				store := openTestStore(t, path, "secret")
+				store.DeleteSession("conn-1")
+				store.Close()
+			},
+		},
+		{
+			name:   "unsupported version",
+			secret: "secret",
+			prepare: func(t *testing.T, path string) {
+				rewriteSessionStoreFile(t, path, func(file *sessionStoreFile) { file.Version = sessionStoreVersion + 1 })
+			},
+			wantErr: true,
+		},
+		{
+			name:   "unreadable file",
+			secret: "secret",
+			prepare: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, []byte("not json"), 0600); err != nil {
+					t.Fatalf("WriteFile: %v", err)
+				}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sessions.db")
+			webSession, session := newTestSessions()
+
+			store := openTestStore(t, path, "secret")
+			store.SaveWebSession(webSession)
+			store.SaveSession(session)
+			store.Close()
+
+			if tt.prepare != nil {
+				tt.prepare(t, path)
+			}
+
+			restored, err := NewDiskSessionStore(path, tt.secret)
+			if tt.wantErr {
+				if err == nil {
+					restored.Close()
+					t.Fatal("NewDiskSessionStore succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewDiskSessionStore: %v", err)
+			}
+			defer restored.Close()
+
+			if got := len(restored.Sessions()); got != tt.wantSessions {
+				t.Errorf("restored %d connections, want %d", got, tt.wantSessions)
+			}
+		})
+	}
+}
+
+// rewriteSessionStoreFile applies change to the store file on disk
+func rewriteSessionStoreFile(t *testing.T, path string, change func(file *sessionStoreFile)) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var file sessionStoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	change(&file)
+	if data, err = json.Marshal(file); err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/ssh"
+
+	"sftp-gui/internal/models"
+)
+
+// dialChain connects to the target of a login request, tunnelling through
+// its jump hosts in order. It returns the client for the target, the
+// clients of the intermediate hops, which must be closed after the target,
+// and the key exchange recorded with the target. The configured algorithms
+// apply to every hop; per-connection overrides only to the target. Every
+// hop, and the proxy, is checked against the target policy before anything
+// is dialed.
+func (s *SessionService) dialChain(req *models.LoginRequest, flow *LoginFlow, options models.ConnectionOptions) (*ssh.Client, []*ssh.Client, *kexRecorder, error) {
+	hops := append(append([]models.JumpHost{}, req.JumpHosts...), targetHop(req))
+
+	proxyURL, err := outboundProxy(s.config, req.Proxy)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	guard := newTargetGuard(s.config, req.AppUser, req.Role)
+	dialAddrs := make([]string, len(hops))
+	for i, hop := range hops {
+		dialAddr, err := guard.dialAddress(hop.Host, hop.Port, i > 0)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		dialAddrs[i] = dialAddr
+	}
+
+	var (
+		jumpClients []*ssh.Client
+		client      *ssh.Client
+		recorder    *kexRecorder
+	)
+	closeAll := func() {
+		for i := len(jumpClients) - 1; i >= 0; i-- {
+			jumpClients[i].Close()
+		}
+	}
+
+	// Only the first hop is dialed from here; later hops are reached
+	// through the previous one
+	dialer, err := newDialer(proxyURL, s.config.SSH.DialTimeout)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for i, hop := range hops {
+		addr := net.JoinHostPort(hop.Host, strconv.Itoa(hop.Port))
+
+		var challenge ssh.KeyboardInteractiveChallenge
+		if flow != nil {
+			challenge = flow.challengeFor(hop)
+		} else if hop.Password != "" {
+			// Without a user to relay prompts to, only password prompts
+			// can be answered, e.g. when reconnecting a dropped session
+			challenge = passwordChallenge(hop.Password)
+		}
+
+		auth := &authAttempt{}
+		authMethods, err := buildAuthMethods(hop, challenge, auth)
+		if err != nil {
+			closeAll()
+			return nil, nil, nil, err
+		}
+
+		sshConfig := &ssh.ClientConfig{
+			User:              hop.Username,
+			Auth:              authMethods,
+			HostKeyCallback:   s.hostKeys.Callback(req.AcceptedHostKeys),
+			HostKeyAlgorithms: s.hostKeys.KnownKeyAlgorithms(addr),
+			Timeout:           s.config.SSH.DialTimeout,
+		}
+		if i == len(hops)-1 {
+			applyAlgorithms(sshConfig, options)
+		} else {
+			applyAlgorithms(sshConfig, s.defaultConnectionOptions())
+		}
+
+		if i == 0 {
+			client, recorder, err = dialDirect(dialer, dialAddrs[i], addr, sshConfig)
+		} else {
+			client, recorder, err = dialThrough(jumpClients[i-1], dialAddrs[i], addr, sshConfig)
+		}
+		if err != nil {
+			closeAll()
+			err = auth.classify(err)
+			if i < len(hops)-1 {
+				return nil, nil, nil, fmt.Errorf("failed to connect to jump host %s: %w", hop.String(), err)
+			}
+			return nil, nil, nil, fmt.Errorf("failed to connect to SSH server: %w", err)
+		}
+
+		if i < len(hops)-1 {
+			jumpClients = append(jumpClients, client)
+		}
+	}
+
+	return client, jumpClients, recorder, nil
+}
+
+// dialDirect opens an SSH connection to addr using the outbound dialer.
+// dialAddr is where to connect, which may be the checked IP address of addr.
+func dialDirect(dialer contextDialer, dialAddr, addr string, sshConfig *ssh.ClientConfig) (*ssh.Client, *kexRecorder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sshConfig.Timeout)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, "tcp", dialAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return handshake(conn, addr, sshConfig)
+}
+
+// dialThrough opens an SSH connection to addr tunnelled through a jump host
+func dialThrough(jump *ssh.Client, dialAddr, addr string, sshConfig *ssh.ClientConfig) (*ssh.Client, *kexRecorder, error) {
+	conn, err := jump.Dial("tcp", dialAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return handshake(conn, addr, sshConfig)
+}
+
+// handshake runs the SSH handshake over an established connection,
+// recording the key exchange
+func handshake(conn net.Conn, addr string, sshConfig *ssh.ClientConfig) (*ssh.Client, *kexRecorder, error) {
+	recorder := newKexRecorder(conn)
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(recorder, addr, sshConfig)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return ssh.NewClient(clientConn, chans, reqs), recorder, nil
+}
+
+// targetHop returns the final server of a login request as a hop
+func targetHop(req *models.LoginRequest) models.JumpHost {
+	return models.JumpHost{
+		Host:       req.Host,
+		Port:       req.Port,
+		Username:   req.Username,
+		Password:   req.Password,
+		PrivateKey: req.PrivateKey,
+		Passphrase: req.Passphrase,
+	}
+}
+
+// authFailedError reports that a server refused the credentials it was
+// offered, as opposed to network, host key or protocol problems
+type authFailedError struct {
+	err error
+}
+
+func (e *authFailedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *authFailedError) Unwrap() error {
+	return e.err
+}
+
+// IsAuthFailure reports whether a login failed because the server rejected
+// the credentials
+func IsAuthFailure(err error) bool {
+	var authErr *authFailedError
+	return errors.As(err, &authErr)
+}
+
+// authAttempt records whether credentials were sent to a server during a
+// handshake, so a failed handshake can be told apart from a refused login
+type authAttempt struct {
+	offered atomic.Bool
+}
+
+// classify marks a handshake error as an authentication failure when
+// credentials had been offered and the server did not simply time out
+func (a *authAttempt) classify(err error) error {
+	var netErr net.Error
+	if !a.offered.Load() || errors.As(err, &netErr) && netErr.Timeout() {
+		return err
+	}
+	return &authFailedError{err: err}
+}
+
+// buildAuthMethods returns the SSH authentication methods for a hop, which
+// record in auth when they send credentials. Public key authentication is
+// tried first so that servers accepting either method do not see a wasted
+// password attempt. Keyboard-interactive is only offered when a challenge
+// handler is supplied.
+func buildAuthMethods(hop models.JumpHost, challenge ssh.KeyboardInteractiveChallenge, auth *authAttempt) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+
+	if hop.PrivateKey != "" {
+		signer, err := parsePrivateKey([]byte(hop.PrivateKey), hop.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			auth.offered.Store(true)
+			return []ssh.Signer{signer}, nil
+		}))
+	}
+
+	if hop.Password != "" {
+		methods = append(methods, ssh.PasswordCallback(func() (string, error) {
+			auth.offered.Store(true)
+			return hop.Password, nil
+		}))
+	}
+
+	if challenge != nil {
+		methods = append(methods, ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			answers, err := challenge(name, instruction, questions, echos)
+			if err == nil && len(questions) > 0 {
+				auth.offered.Store(true)
+			}
+			return answers, err
+		}))
+	}
+
+	if len(methods) == 0 {
+		return nil, models.ErrMissingCredentials
+	}
+
+	return methods, nil
+}
+
+// passwordChallenge returns a keyboard-interactive handler that answers a
+// password prompt and fails on any other prompt
+func passwordChallenge(password string) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		if len(questions) == 0 {
+			return []string{}, nil
+		}
+		if isPasswordPrompt(questions, echos) {
+			return []string{password}, nil
+		}
+		return nil, models.NewAuthError("server requires interactive authentication")
+	}
+}
+
+// isPasswordPrompt reports whether a keyboard-interactive round is a single
+// hidden prompt asking for the password, as sent by PAM
+func isPasswordPrompt(questions []string, echos []bool) bool {
+	return len(questions) == 1 && !echos[0] && strings.Contains(strings.ToLower(questions[0]), "password")
+}
+
+// authMethodName describes which credentials a login request carries
+func authMethodName(req *models.LoginRequest) string {
+	switch {
+	case req.PrivateKey != "" && req.Password != "":
+		return "publickey+password"
+	case req.PrivateKey != "":
+		return "publickey"
+	case req.Password != "":
+		return "password"
+	default:
+		return "keyboard-interactive"
+	}
+}
+
+// parsePrivateKey parses an OpenSSH, PEM (PKCS#1/SEC1) or PKCS#8 private key,
+// decrypting it with the passphrase when the key is encrypted
+func parsePrivateKey(key []byte, passphrase string) (ssh.Signer, error) {
+	// Normalize keys pasted from Windows clipboards or textarea fields
+	key = []byte(strings.TrimSpace(strings.ReplaceAll(string(key), "\r\n", "\n")) + "\n")
+
+	// A passphrase supplied for an unencrypted key is simply ignored
+	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) && passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+	}
+
+	if err != nil {
+		switch {
+		case errors.As(err, &missing):
+			return nil, models.ErrPassphraseRequired
+		case errors.Is(err, x509.IncorrectPasswordError):
+			return nil, models.ErrInvalidPassphrase
+		default:
+			return nil, models.ErrInvalidPrivateKey
+		}
+	}
+
+	return signer, nil
+}
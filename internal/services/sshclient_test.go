@@ -0,0 +1,48 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestAuthAttemptClassify(t *testing.T) {
+	refused := errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none password], no supported methods remain")
+	timeout := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+
+	tests := []struct {
+		name    string
+		offered bool
+		err     error
+		want    bool
+	}{
+		{name: "credentials refused", offered: true, err: refused, want: true},
+		{name: "wrapped refusal", offered: true, err: fmt.Errorf("failed to connect to SSH server: %w", refused), want: true},
+		{name: "no credentials sent", offered: false, err: refused},
+		{name: "timeout after credentials were sent", offered: true, err: timeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := &authAttempt{}
+			auth.offered.Store(tt.offered)
+
+			err := auth.classify(tt.err)
+			if got := IsAuthFailure(err); got != tt.want {
+				t.Errorf("IsAuthFailure = %v, want %v", got, tt.want)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("classified error %v does not wrap %v", err, tt.err)
+			}
+			if err.Error() != tt.err.Error() {
+				t.Errorf("message = %q, want it unchanged", err.Error())
+			}
+		})
+	}
+
+	if IsAuthFailure(nil) {
+		t.Error("IsAuthFailure(nil) = true, want false")
+	}
+}
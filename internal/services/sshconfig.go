@@ -0,0 +1,338 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+// maxSSHConfigSize limits the size of ssh_config files that are imported
+const maxSSHConfigSize = 256 << 10
+
+// SSHConfigService offers the Host blocks of OpenSSH client config files as
+// connections on the login page
+type SSHConfigService struct {
+	config   *config.Config
+	mutex    sync.RWMutex
+	uploaded map[string][]models.SSHConfigHost
+}
+
+// NewSSHConfigService creates a new ssh_config import service
+func NewSSHConfigService(cfg *config.Config) *SSHConfigService {
+	return &SSHConfigService{
+		config:   cfg,
+		uploaded: make(map[string][]models.SSHConfigHost),
+	}
+}
+
+// Hosts returns the hosts of the configured ssh_config file followed by the
+// hosts the application user imported from uploaded files
+func (s *SSHConfigService) Hosts(username string) []models.SSHConfigHost {
+	hosts, err := s.configuredHosts()
+	if err != nil {
+		fmt.Printf("Error reading ssh_config file: %v\n", err)
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return append(hosts, s.uploaded[username]...)
+}
+
+// Import parses an uploaded ssh_config file and replaces the hosts the
+// application user uploaded before. Identity files of uploaded hosts refer
+// to the user's own machine, so they are only shown as a hint and never
+// read.
+func (s *SSHConfigService) Import(username string, r io.Reader) (int, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSSHConfigSize+1))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read ssh_config: %w", err)
+	}
+	if len(data) > maxSSHConfigSize {
+		return 0, models.NewValidationError("ssh_config file is too large")
+	}
+
+	hosts, err := ParseSSHConfig(strings.NewReader(string(data)), "")
+	if err != nil {
+		return 0, err
+	}
+	if len(hosts) == 0 {
+		return 0, models.NewValidationError("no Host entries found in ssh_config")
+	}
+
+	s.mutex.Lock()
+	s.uploaded[username] = hosts
+	s.mutex.Unlock()
+
+	return len(hosts), nil
+}
+
+// Identity returns a host in the configured ssh_config file that names an
+// IdentityFile, together with the private key read from that file. The host
+// is nil when no such host exists. The key may only be used to connect to
+// the host, port and user of the returned entry.
+func (s *SSHConfigService) Identity(alias string) (*models.SSHConfigHost, string, error) {
+	hosts, err := s.configuredHosts()
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, host := range hosts {
+		if host.Alias != alias || host.IdentityFile == "" {
+			continue
+		}
+
+		file, err := os.Open(host.IdentityFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to open identity file for %s: %w", alias, err)
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(io.LimitReader(file, maxSSHConfigSize))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read identity file for %s: %w", alias, err)
+		}
+		return &host, string(data), nil
+	}
+
+	return nil, "", nil
+}
+
+// configuredHosts parses the ssh_config file named in the configuration
+func (s *SSHConfigService) configuredHosts() ([]models.SSHConfigHost, error) {
+	configPath := s.config.SSH.ClientConfigFile
+	if configPath == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(configPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	home, _ := os.UserHomeDir()
+	hosts, err := ParseSSHConfig(io.LimitReader(file, maxSSHConfigSize), home)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range hosts {
+		hosts[i].Configured = true
+		if identity := hosts[i].IdentityFile; identity != "" && !filepath.IsAbs(identity) {
+			hosts[i].IdentityFile = filepath.Join(filepath.Dir(configPath), identity)
+		}
+	}
+
+	return hosts, nil
+}
+
+// sshConfigBlock is a Host block and the options set in it
+type sshConfigBlock struct {
+	patterns []string
+	options  map[string]string
+}
+
+// matches reports whether the block applies to a host alias
+func (b *sshConfigBlock) matches(alias string) bool {
+	matched := false
+	for _, pattern := range b.patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if ok, _ := path.Match(strings.ToLower(strings.TrimPrefix(pattern, "!")), strings.ToLower(alias)); ok {
+			if negated {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// ParseSSHConfig reads the Host blocks of an OpenSSH client config. As in
+// OpenSSH, the first value found for an option wins and wildcard blocks
+// such as "Host *" supply defaults. Only concrete host aliases are returned.
+// home expands "~" in IdentityFile paths; when empty they are left as is.
+// Match and Include directives are not supported and are skipped.
+func ParseSSHConfig(r io.Reader, home string) ([]models.SSHConfigHost, error) {
+	// Options before the first Host line apply to every host
+	blocks := []*sshConfigBlock{{patterns: []string{"*"}, options: map[string]string{}}}
+	current := blocks[0]
+
+	var aliases []string
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		keyword, args := splitSSHConfigLine(scanner.Text())
+		if keyword == "" {
+			continue
+		}
+
+		switch keyword {
+		case "host":
+			if len(args) == 0 {
+				return nil, models.NewValidationError(fmt.Sprintf("ssh_config line %d: Host requires a pattern", lineNumber))
+			}
+			current = &sshConfigBlock{patterns: args, options: map[string]string{}}
+			blocks = append(blocks, current)
+
+			for _, pattern := range args {
+				if !strings.ContainsAny(pattern, "*?!") && !seen[pattern] {
+					seen[pattern] = true
+					aliases = append(aliases, pattern)
+				}
+			}
+		case "match":
+			current = nil
+		default:
+			if current == nil || len(args) == 0 {
+				continue
+			}
+			if _, exists := current.options[keyword]; !exists {
+				current.options[keyword] = strings.Join(args, " ")
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ssh_config: %w", err)
+	}
+
+	lookup := func(alias, keyword string) string {
+		for _, block := range blocks {
+			if value, exists := block.options[keyword]; exists && block.matches(alias) {
+				return value
+			}
+		}
+		return ""
+	}
+
+	// resolve maps an alias to its real host name, port and user
+	resolve := func(alias string) (string, int, string) {
+		hostname := alias
+		if value := lookup(alias, "hostname"); value != "" {
+			hostname = strings.ReplaceAll(strings.ReplaceAll(value, "%h", alias), "%%", "%")
+		}
+		port, err := strconv.Atoi(lookup(alias, "port"))
+		if err != nil {
+			port = 22
+		}
+		return hostname, port, lookup(alias, "user")
+	}
+
+	hosts := make([]models.SSHConfigHost, 0, len(aliases))
+	for _, alias := range aliases {
+		hostname, port, user := resolve(alias)
+
+		host := models.SSHConfigHost{
+			Alias:    alias,
+			Host:     hostname,
+			Port:     port,
+			Username: user,
+		}
+
+		identity := lookup(alias, "identityfile")
+		if identity != "" && home != "" {
+			identity = strings.ReplaceAll(identity, "%d", home)
+			identity = strings.ReplaceAll(identity, "%h", hostname)
+			if identity == "~" || strings.HasPrefix(identity, "~/") {
+				identity = filepath.Join(home, identity[1:])
+			}
+		}
+		host.IdentityFile = identity
+
+		if proxyJump := lookup(alias, "proxyjump"); proxyJump != "" && !strings.EqualFold(proxyJump, "none") {
+			for _, hop := range strings.Split(proxyJump, ",") {
+				hopUser, hopHost, hopPort := splitJumpSpec(strings.TrimSpace(hop))
+
+				// Jump hosts may themselves be aliases defined in the file
+				resolvedHost, resolvedPort, resolvedUser := resolve(hopHost)
+				if hopUser == "" {
+					hopUser = resolvedUser
+				}
+				if hopPort == 0 {
+					hopPort = resolvedPort
+				}
+
+				address := net.JoinHostPort(resolvedHost, strconv.Itoa(hopPort))
+				if hopUser != "" {
+					address = hopUser + "@" + address
+				}
+				host.JumpHosts = append(host.JumpHosts, address)
+			}
+		}
+
+		hosts = append(hosts, host)
+	}
+
+	return hosts, nil
+}
+
+// splitSSHConfigLine returns the lower-cased keyword and the arguments of
+// an ssh_config line. Keywords may be separated from their arguments by
+// whitespace or "=", and arguments may be double-quoted.
+func splitSSHConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil
+	}
+
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimSpace(strings.TrimPrefix(rest, "="))
+
+	var args []string
+	var arg strings.Builder
+	inQuotes, hasArg := false, false
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasArg = true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if hasArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				hasArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			hasArg = true
+		}
+	}
+	if hasArg {
+		args = append(args, arg.String())
+	}
+
+	return keyword, args
+}
+
+// splitJumpSpec splits a ProxyJump entry of the form [user@]host[:port]
+func splitJumpSpec(spec string) (string, string, int) {
+	var user string
+	if at := strings.LastIndex(spec, "@"); at >= 0 {
+		user, spec = spec[:at], spec[at+1:]
+	}
+
+	if host, portValue, err := net.SplitHostPort(spec); err == nil {
+		if port, err := strconv.Atoi(portValue); err == nil {
+			return user, host, port
+		}
+	}
+
+	return user, strings.Trim(spec, "[]"), 0
+}
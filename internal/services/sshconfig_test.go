@@ -0,0 +1,181 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"sftp-gui/internal/models"
+)
+
+func TestParseSSHConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		home    string
+		want    []models.SSHConfigHost
+		wantErr bool
+	}{
+		{
+			name: "single host",
+			config: `
+Host web
+    HostName web.example.com
+    Port 2222
+    User deploy
+`,
+			want: []models.SSHConfigHost{{Alias: "web", Host: "web.example.com", Port: 2222, Username: "deploy"}},
+		},
+		{
+			name:   "alias without HostName or Port",
+			config: "Host db.example.com\n",
+			want:   []models.SSHConfigHost{{Alias: "db.example.com", Host: "db.example.com", Port: 22}},
+		},
+		{
+			name: "first value wins and wildcards supply defaults",
+			config: `
+Host web
+    User deploy
+    User ignored
+Host *
+    User fallback
+    Port 2200
+Host db
+    User late
+`,
+			want: []models.SSHConfigHost{
+				{Alias: "web", Host: "web", Port: 2200, Username: "deploy"},
+				{Alias: "db", Host: "db", Port: 2200, Username: "fallback"},
+			},
+		},
+		{
+			name: "options before the first Host apply to every host",
+			config: `User everyone
+Host web
+    User deploy
+`,
+			want: []models.SSHConfigHost{{Alias: "web", Host: "web", Port: 22, Username: "everyone"}},
+		},
+		{
+			name: "negated pattern excludes a host",
+			config: `
+Host web db
+Host * !db
+    User deploy
+`,
+			want: []models.SSHConfigHost{
+				{Alias: "web", Host: "web", Port: 22, Username: "deploy"},
+				{Alias: "db", Host: "db", Port: 22},
+			},
+		},
+		{
+			name: "equals sign, quotes and comments",
+			config: `
+# a comment
+Host=web
+    HostName = "web.example.com"
+    IdentityFile "/keys/my key"
+`,
+			want: []models.SSHConfigHost{{Alias: "web", Host: "web.example.com", Port: 22, IdentityFile: "/keys/my key"}},
+		},
+		{
+			name:   "HostName tokens",
+			config: "Host web\n    HostName %h.internal.example\n",
+			want:   []models.SSHConfigHost{{Alias: "web", Host: "web.internal.example", Port: 22}},
+		},
+		{
+			name:   "identity file in the home directory",
+			config: "Host web\n    IdentityFile ~/.ssh/id_ed25519\n",
+			home:   "/home/alice",
+			want:   []models.SSHConfigHost{{Alias: "web", Host: "web", Port: 22, IdentityFile: "/home/alice/.ssh/id_ed25519"}},
+		},
+		{
+			name:   "identity file left as is without a home directory",
+			config: "Host web\n    IdentityFile ~/.ssh/id_ed25519\n",
+			want:   []models.SSHConfigHost{{Alias: "web", Host: "web", Port: 22, IdentityFile: "~/.ssh/id_ed25519"}},
+		},
+		{
+			name: "ProxyJump through aliases",
+			config: `
+Host bastion
+    HostName bastion.example.com
+    User jump
+    Port 2022
+Host app
+    HostName 10.0.0.5
+    ProxyJump bastion,ops@[2001:db8::1]:2200
+`,
+			want: []models.SSHConfigHost{
+				{Alias: "bastion", Host: "bastion.example.com", Port: 2022, Username: "jump"},
+				{Alias: "app", Host: "10.0.0.5", Port: 22, JumpHosts: []string{"jump@bastion.example.com:2022", "ops@[2001:db8::1]:2200"}},
+			},
+		},
+		{
+			name:   "ProxyJump none",
+			config: "Host app\n    ProxyJump none\n",
+			want:   []models.SSHConfigHost{{Alias: "app", Host: "app", Port: 22}},
+		},
+		{
+			name: "Match blocks are skipped",
+			config: `
+Match user root
+    User ignored
+Host web
+`,
+			want: []models.SSHConfigHost{{Alias: "web", Host: "web", Port: 22}},
+		},
+		{
+			name:   "only wildcard hosts",
+			config: "Host *.example.com\n    User deploy\n",
+			want:   []models.SSHConfigHost{},
+		},
+		{
+			name:    "Host without a pattern",
+			config:  "Host\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSSHConfig(strings.NewReader(tt.config), tt.home)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseSSHConfig = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSSHConfig: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSSHConfig =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitJumpSpec(t *testing.T) {
+	tests := []struct {
+		spec     string
+		wantUser string
+		wantHost string
+		wantPort int
+	}{
+		{spec: "bastion", wantHost: "bastion"},
+		{spec: "jump@bastion", wantUser: "jump", wantHost: "bastion"},
+		{spec: "jump@bastion:2022", wantUser: "jump", wantHost: "bastion", wantPort: 2022},
+		{spec: "[2001:db8::1]:2200", wantHost: "2001:db8::1", wantPort: 2200},
+		{spec: "[2001:db8::1]", wantHost: "2001:db8::1"},
+		{spec: "me@corp@bastion", wantUser: "me@corp", wantHost: "bastion"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			user, host, port := splitJumpSpec(tt.spec)
+			if user != tt.wantUser || host != tt.wantHost || port != tt.wantPort {
+				t.Errorf("splitJumpSpec = %q, %q, %d; want %q, %q, %d", user, host, port, tt.wantUser, tt.wantHost, tt.wantPort)
+			}
+		})
+	}
+}
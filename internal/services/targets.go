@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+// targetGuard checks the SSH servers a user connects to against the
+// target policy
+type targetGuard struct {
+	config *config.Config
+	user   string
+	role   string
+}
+
+// newTargetGuard returns the guard for the user of a login request
+func newTargetGuard(cfg *config.Config, user, role string) targetGuard {
+	return targetGuard{config: cfg, user: user, role: role}
+}
+
+// dialAddress checks a server against the target policy and returns the
+// address to dial. Names are resolved here and the checked IP address is
+// dialed instead of the name, so a DNS answer that changes after the check
+// cannot redirect the connection. Hops behind a jump host may name hosts
+// that only resolve there; they are passed on by name when a host name
+// rule allows them. Without a policy the address is returned unchanged.
+func (g targetGuard) dialAddress(host string, port int, behindJump bool) (string, error) {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	targets := g.config.Policy.Targets
+	if !targets.Enabled() {
+		return addr, nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if !g.config.AllowsTarget(g.role, host, ip, port) {
+			return "", g.deny(addr, "address is not allowed")
+		}
+		return addr, nil
+	}
+
+	if behindJump && g.config.AllowsTarget(g.role, host, nil, port) {
+		return addr, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.config.SSH.DialTimeout)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+
+	for _, ip := range ips {
+		if g.config.AllowsTarget(g.role, host, ip, port) {
+			return net.JoinHostPort(ip.String(), strconv.Itoa(port)), nil
+		}
+	}
+	return "", g.deny(addr, fmt.Sprintf("no allowed address among %v", ips))
+}
+
+// deny logs a refused target and returns the error shown to the user
+func (g targetGuard) deny(addr, reason string) error {
+	fmt.Printf("Denied connection to %s for user %q (role %s): %s\n", addr, g.user, g.role, reason)
+	return fmt.Errorf("%w: %s", models.ErrTargetNotAllowed, addr)
+}
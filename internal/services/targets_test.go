@@ -0,0 +1,133 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+// errAny marks a case where any error will do, e.g. a failed lookup
+var errAny = errors.New("any error")
+
+// newTestTargetConfig allows port 2222 on loopback, except 127.0.0.2, and
+// hosts named *.behind.example
+func newTestTargetConfig() *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.Policy.Targets = config.TargetPolicy{
+		Allow: []config.TargetRule{
+			{Host: "127.0.0.0/8", Ports: []string{"2222"}},
+			{Host: "*.behind.example"},
+		},
+		Deny: []string{"127.0.0.2"},
+	}
+	cfg.SSH.DialTimeout = 2 * time.Second
+	return cfg
+}
+
+func TestTargetGuardDialAddress(t *testing.T) {
+	guard := newTargetGuard(newTestTargetConfig(), "alice", config.RoleViewer)
+
+	tests := []struct {
+		name       string
+		host       string
+		port       int
+		behindJump bool
+		want       string
+		wantErr    error
+	}{
+		{name: "allowed address", host: "127.0.0.1", port: 2222, want: "127.0.0.1:2222"},
+		{name: "port not allowed", host: "127.0.0.1", port: 22, wantErr: models.ErrTargetNotAllowed},
+		{name: "denied address", host: "127.0.0.2", port: 2222, wantErr: models.ErrTargetNotAllowed},
+		{name: "name is dialed by its checked address", host: "localhost", port: 2222, want: "127.0.0.1:2222"},
+		{name: "name rule behind a jump host", host: "db.behind.example", port: 22, behindJump: true, want: "db.behind.example:22"},
+		{name: "name rule must resolve without a jump host", host: "db.behind.example", port: 22, wantErr: errAny},
+		{name: "unknown name behind a jump host", host: "other.example", port: 22, behindJump: true, wantErr: errAny},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := guard.dialAddress(tt.host, tt.port, tt.behindJump)
+			switch {
+			case tt.wantErr == errAny:
+				if err == nil {
+					t.Fatalf("dialAddress = %q, want an error", got)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("dialAddress: %v", err)
+			case got != tt.want:
+				t.Errorf("dialAddress = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetGuardWithoutPolicy(t *testing.T) {
+	guard := newTargetGuard(config.DefaultConfig(), "alice", config.RoleViewer)
+
+	got, err := guard.dialAddress("unresolvable.invalid", 2222, false)
+	if err != nil || got != "unresolvable.invalid:2222" {
+		t.Errorf("dialAddress = %q, %v; want the address unchanged", got, err)
+	}
+}
+
+func TestOutboundProxy(t *testing.T) {
+	const configured = "socks5://proxy.example.com:1080"
+
+	tests := []struct {
+		name      string
+		policy    bool
+		disabled  bool
+		requested string
+		want      string
+		wantErr   error
+	}{
+		{name: "configured proxy", want: configured},
+		{name: "per-connection proxy", requested: "http://other.example.com:3128", want: "http://other.example.com:3128"},
+		{name: "direct", requested: ProxyDirect, want: ProxyDirect},
+		{name: "configured proxy under a policy", policy: true, want: configured},
+		{name: "per-connection proxy under a policy", policy: true, requested: "http://other.example.com:3128", wantErr: models.ErrProxyNotAllowed},
+		{name: "direct under a policy", policy: true, requested: ProxyDirect, wantErr: models.ErrProxyNotAllowed},
+		{name: "configured proxy when turned off", disabled: true, want: configured},
+		{name: "per-connection proxy when turned off", disabled: true, requested: "http://other.example.com:3128", wantErr: models.ErrProxyNotAllowed},
+		{name: "direct when turned off", disabled: true, requested: ProxyDirect, wantErr: models.ErrProxyNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			if tt.policy {
+				cfg = newTestTargetConfig()
+			}
+			cfg.SSH.Proxy = configured
+			cfg.SSH.AllowConnectionProxy = !tt.disabled
+
+			got, err := outboundProxy(cfg, tt.requested)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("outboundProxy = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiagnosticsRefusesProxyUnderPolicy(t *testing.T) {
+	cfg := newTestTargetConfig()
+	d := NewDiagnosticsService(cfg, nil)
+
+	report := d.Run("127.0.0.1", 2222, "", "socks5://127.0.0.1:1080", "alice", config.RoleViewer)
+	if len(report.Steps) != 1 || report.Steps[0].OK || report.Steps[0].Name != "Target policy" {
+		t.Fatalf("steps = %+v, want only a failed policy step", report.Steps)
+	}
+	if report.Steps[0].Error != models.ErrProxyNotAllowed.Error() {
+		t.Errorf("error = %q, want %q", report.Steps[0].Error, models.ErrProxyNotAllowed)
+	}
+}
@@ -0,0 +1,390 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+// minPasswordLength is the shortest password accepted for an account
+const minPasswordLength = 8
+
+// bootstrapAdmin is the account created when no users exist yet
+const bootstrapAdmin = "admin"
+
+// validUsername restricts account names to characters that are safe in
+// logs, URLs and file names
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,64}$`)
+
+// dummyPasswordHash is compared against when a user does not exist, so
+// unknown and known usernames take the same time to reject
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("sftp-gui dummy password"), bcrypt.DefaultCost)
+
+// UserService manages the application accounts allowed to use the web
+// client. Accounts are stored with bcrypt password hashes in a JSON file.
+type UserService struct {
+	config *config.Config
+	mutex  sync.RWMutex
+	users  map[string]*models.User
+}
+
+// NewUserService loads the users file. When it holds no accounts, an admin
+// account is created with the password from SFTP_ADMIN_PASSWORD, or a
+// random one that is printed once.
+func NewUserService(cfg *config.Config) (*UserService, error) {
+	service := &UserService{
+		config: cfg,
+		users:  make(map[string]*models.User),
+	}
+
+	if err := service.load(); err != nil {
+		return nil, err
+	}
+
+	if len(service.users) == 0 {
+		if err := service.bootstrap(); err != nil {
+			return nil, err
+		}
+	}
+
+	return service, nil
+}
+
+// Authenticate checks a username and password and records the login
+func (u *UserService) Authenticate(username, password string) (*models.User, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	user, exists := u.users[username]
+	if !exists {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, models.ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, models.ErrInvalidCredentials
+	}
+	if user.Disabled {
+		return nil, models.NewAuthError("account is disabled")
+	}
+
+	user.LastLogin = time.Now()
+	if err := u.persist(); err != nil {
+		fmt.Printf("Error saving users file: %v\n", err)
+	}
+
+	return redactUser(user), nil
+}
+
+// IsLocal reports whether an account with a local password exists
+func (u *UserService) IsLocal(username string) bool {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+
+	user, exists := u.users[username]
+	return exists && user.Source == ""
+}
+
+// Get returns an account without its password hash
+func (u *UserService) Get(username string) (*models.User, error) {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+
+	user, exists := u.users[username]
+	if !exists {
+		return nil, models.ErrUserNotFound
+	}
+
+	return redactUser(user), nil
+}
+
+// List returns all accounts sorted by name, without password hashes
+func (u *UserService) List() []*models.User {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+
+	users := make([]*models.User, 0, len(u.users))
+	for _, user := range u.users {
+		users = append(users, redactUser(user))
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+
+	return users
+}
+
+// Save creates an account, or updates it when the username exists. The
+// password is required for new accounts and kept when left empty on update.
+// An empty role selects the policy's default role.
+func (u *UserService) Save(username, password, role string, disabled bool) (*models.User, error) {
+	if !validUsername.MatchString(username) {
+		return nil, models.NewValidationError("username must be 1-64 letters, digits or . _ @ -")
+	}
+	if role == "" {
+		role = u.config.Policy.DefaultRole
+	}
+	if _, ok := u.config.Policy.Roles[role]; !ok {
+		return nil, models.ErrUnknownRole
+	}
+	if password != "" && len(password) < minPasswordLength {
+		return nil, models.ErrWeakPassword
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	now := time.Now()
+	existing, exists := u.users[username]
+
+	user := &models.User{
+		Username:  username,
+		Role:      role,
+		Disabled:  disabled,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if exists {
+		if existing.Source != "" && password != "" {
+			return nil, models.ErrExternalAccount
+		}
+		user.PasswordHash = existing.PasswordHash
+		user.Source = existing.Source
+		user.Subject = existing.Subject
+		user.CreatedAt = existing.CreatedAt
+		user.LastLogin = existing.LastLogin
+	} else if password == "" {
+		return nil, models.ErrWeakPassword
+	}
+
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+		user.PasswordHash = string(hash)
+	}
+
+	u.users[username] = user
+	if !u.hasAdmin() {
+		u.restore(username, existing)
+		return nil, models.ErrLastAdmin
+	}
+	if err := u.persist(); err != nil {
+		u.restore(username, existing)
+		return nil, err
+	}
+
+	return redactUser(user), nil
+}
+
+// Provision signs in an account vouched for by an identity provider,
+// creating it on first sign-in. The role from the provider replaces the
+// stored one. Local accounts are never taken over, and disabled accounts
+// stay locked out. The subject is recorded on first sign-in and must match
+// on every later one.
+func (u *UserService) Provision(username, source, subject, role string) (*models.User, error) {
+	if !validUsername.MatchString(username) {
+		return nil, models.NewAuthError(fmt.Sprintf("identity provider username %q is not a valid account name", username))
+	}
+	if _, ok := u.config.Policy.Roles[role]; !ok {
+		return nil, models.ErrUnknownRole
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	now := time.Now()
+	user, exists := u.users[username]
+	switch {
+	case !exists:
+		user = &models.User{
+			Username:  username,
+			Source:    source,
+			Subject:   subject,
+			CreatedAt: now,
+		}
+		u.users[username] = user
+		fmt.Printf("Created %s account %s with role %s\n", source, username, role)
+	case user.Source != source:
+		return nil, models.ErrAccountConflict
+	case user.Subject != subject:
+		return nil, models.ErrSubjectMismatch
+	case user.Disabled:
+		return nil, models.NewAuthError("account is disabled")
+	}
+
+	if user.Role != role {
+		user.Role = role
+		user.UpdatedAt = now
+	}
+	user.LastLogin = now
+	if err := u.persist(); err != nil {
+		fmt.Printf("Error saving users file: %v\n", err)
+	}
+
+	return redactUser(user), nil
+}
+
+// Delete removes an account
+func (u *UserService) Delete(username string) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	user, exists := u.users[username]
+	if !exists {
+		return models.ErrUserNotFound
+	}
+
+	delete(u.users, username)
+	if !u.hasAdmin() {
+		u.users[username] = user
+		return models.ErrLastAdmin
+	}
+	if err := u.persist(); err != nil {
+		u.users[username] = user
+		return err
+	}
+
+	return nil
+}
+
+// hasAdmin reports whether an enabled admin account exists. The caller
+// must hold the mutex.
+func (u *UserService) hasAdmin() bool {
+	for _, user := range u.users {
+		if !user.Disabled && u.config.Allows(user.Role, config.PermAdmin) {
+			return true
+		}
+	}
+	return false
+}
+
+// restore puts back the previous version of an account after a failed
+// change. The caller must hold the mutex.
+func (u *UserService) restore(username string, previous *models.User) {
+	if previous != nil {
+		u.users[username] = previous
+	} else {
+		delete(u.users, username)
+	}
+}
+
+// bootstrap creates the initial admin account. The caller must hold the
+// mutex or have exclusive access.
+func (u *UserService) bootstrap() error {
+	password := os.Getenv("SFTP_ADMIN_PASSWORD")
+	generated := password == ""
+	if generated {
+		bytes := make([]byte, 12)
+		if _, err := rand.Read(bytes); err != nil {
+			return fmt.Errorf("failed to generate admin password: %w", err)
+		}
+		password = base64.RawURLEncoding.EncodeToString(bytes)
+	} else if len(password) < minPasswordLength {
+		return fmt.Errorf("SFTP_ADMIN_PASSWORD: %w", models.ErrWeakPassword)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash admin password: %w", err)
+	}
+
+	now := time.Now()
+	u.users[bootstrapAdmin] = &models.User{
+		Username:     bootstrapAdmin,
+		PasswordHash: string(hash),
+		Role:         config.RoleAdmin,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := u.persist(); err != nil {
+		return err
+	}
+
+	if generated {
+		fmt.Printf("Created admin account %q with password %s - change it after signing in\n", bootstrapAdmin, password)
+	} else {
+		fmt.Printf("Created admin account %q with the password from SFTP_ADMIN_PASSWORD\n", bootstrapAdmin)
+	}
+	return nil
+}
+
+// load reads the users file if it exists
+func (u *UserService) load() error {
+	data, err := os.ReadFile(u.config.Security.UsersFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read users file: %w", err)
+	}
+
+	var users []models.User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return fmt.Errorf("failed to parse users file: %w", err)
+	}
+
+	for _, user := range users {
+		user := user
+		if _, ok := u.config.Policy.Roles[user.Role]; !ok {
+			fmt.Printf("User %s has role %q, which the policy does not define; it grants no permissions\n", user.Username, user.Role)
+		}
+		u.users[user.Username] = &user
+	}
+	return nil
+}
+
+// persist atomically replaces the users file. The caller must hold the
+// mutex.
+func (u *UserService) persist() error {
+	users := make([]*models.User, 0, len(u.users))
+	for _, user := range u.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode users: %w", err)
+	}
+
+	path := u.config.Security.UsersFile
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create users directory: %w", err)
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write users file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write users file: %w", err)
+	}
+
+	return nil
+}
+
+// redactUser returns a copy of an account without its password hash
+func redactUser(user *models.User) *models.User {
+	copied := *user
+	copied.PasswordHash = ""
+	return &copied
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+func newTestUserService(t *testing.T) *UserService {
+	t.Helper()
+
+	cfg := config.DefaultConfig()
+	cfg.Security.UsersFile = filepath.Join(t.TempDir(), "users.json")
+	t.Setenv("SFTP_ADMIN_PASSWORD", "bootstrap-password")
+
+	users, err := NewUserService(cfg)
+	if err != nil {
+		t.Fatalf("NewUserService: %v", err)
+	}
+	return users
+}
+
+func TestProvisionChecksSubject(t *testing.T) {
+	users := newTestUserService(t)
+
+	user, err := users.Provision("alice", OIDCSource, "https://idp.example.com#1", config.RoleViewer)
+	if err != nil {
+		t.Fatalf("first sign-in: %v", err)
+	}
+	if user.Subject != "https://idp.example.com#1" {
+		t.Errorf("subject = %q, want it recorded", user.Subject)
+	}
+
+	if _, err := users.Provision("alice", OIDCSource, "https://idp.example.com#1", config.RoleViewer); err != nil {
+		t.Errorf("sign-in with the same subject: %v", err)
+	}
+
+	// The username was given to another person at the provider
+	if _, err := users.Provision("alice", OIDCSource, "https://idp.example.com#2", config.RoleAdmin); !errors.Is(err, models.ErrSubjectMismatch) {
+		t.Errorf("sign-in with another subject: error = %v, want ErrSubjectMismatch", err)
+	}
+	if user, _ := users.Get("alice"); user.Role != config.RoleViewer {
+		t.Errorf("role = %q after a refused sign-in, want it unchanged", user.Role)
+	}
+}
+
+func TestProvisionRefusesSubjectForAccountWithout(t *testing.T) {
+	users := newTestUserService(t)
+
+	// A provider that has no subjects, like LDAP, creates accounts without
+	if _, err := users.Provision("bob", OIDCSource, "", config.RoleViewer); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+
+	if _, err := users.Provision("bob", OIDCSource, "https://idp.example.com#7", config.RoleViewer); !errors.Is(err, models.ErrSubjectMismatch) {
+		t.Errorf("error = %v, want ErrSubjectMismatch", err)
+	}
+	if user, _ := users.Get("bob"); user.Subject != "" {
+		t.Errorf("subject = %q, want the account left unbound", user.Subject)
+	}
+}
+
+func TestProvisionKeepsLocalAccounts(t *testing.T) {
+	users := newTestUserService(t)
+
+	if _, err := users.Provision(bootstrapAdmin, OIDCSource, "https://idp.example.com#1", config.RoleAdmin); !errors.Is(err, models.ErrAccountConflict) {
+		t.Errorf("error = %v, want ErrAccountConflict", err)
+	}
+}
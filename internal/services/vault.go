@@ -0,0 +1,505 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+// vaultVersion is the current on-disk vault format
+const vaultVersion = 1
+
+// vaultFile is the encrypted vault as stored on disk
+type vaultFile struct {
+	Version int       `json:"version"`
+	KDF     vaultKDF  `json:"kdf"`
+	Salt    []byte    `json:"salt"`
+	Nonce   []byte    `json:"nonce"`
+	Data    []byte    `json:"data"`
+	Updated time.Time `json:"updated"`
+}
+
+// vaultKDF holds the argon2id parameters used to derive the vault key
+type vaultKDF struct {
+	Algorithm string `json:"algorithm"`
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory"`
+	Threads   uint8  `json:"threads"`
+}
+
+// defaultVaultKDF is used when a new vault is created
+var defaultVaultKDF = vaultKDF{
+	Algorithm: "argon2id",
+	Time:      3,
+	Memory:    64 * 1024,
+	Threads:   4,
+}
+
+// VaultService stores connection profiles encrypted at rest with a key
+// derived from a master passphrase. Every application user has a vault of
+// their own, and the key stays with the browser session that unlocked it.
+type VaultService struct {
+	config   *config.Config
+	mutex    sync.Mutex
+	unlocked map[string]*vaultKey
+}
+
+// vaultKey is the key a browser session derived for its user's vault
+type vaultKey struct {
+	username   string
+	key        []byte
+	lastAccess time.Time
+}
+
+// NewVaultService creates a new vault service
+func NewVaultService(cfg *config.Config) *VaultService {
+	service := &VaultService{
+		config:   cfg,
+		unlocked: make(map[string]*vaultKey),
+	}
+
+	// Start auto-lock goroutine
+	go service.autoLock()
+
+	return service
+}
+
+// vaultPath returns the vault file of an application user, next to the
+// configured vault file with the username before its extension
+func (v *VaultService) vaultPath(username string) string {
+	path := v.config.Security.VaultFile
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + username + ext
+}
+
+// Exists reports whether the user has created a vault
+func (v *VaultService) Exists(username string) bool {
+	_, err := os.Stat(v.vaultPath(username))
+	return err == nil
+}
+
+// IsUnlocked reports whether the browser session has unlocked its user's
+// vault
+func (v *VaultService) IsUnlocked(webSessionID string) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	_, unlocked := v.unlocked[webSessionID]
+	return unlocked
+}
+
+// Unlock decrypts the user's vault with the passphrase and keeps the key
+// for the browser session. If the user has no vault yet, a new empty one
+// is created and protected with the passphrase.
+func (v *VaultService) Unlock(webSessionID, username, passphrase string) error {
+	if passphrase == "" {
+		return models.NewValidationError("vault passphrase is required")
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	file, err := v.readFile(username)
+	if os.IsNotExist(err) {
+		return v.create(webSessionID, username, passphrase)
+	}
+	if err != nil {
+		return err
+	}
+
+	key := deriveVaultKey(passphrase, file.Salt, file.KDF)
+	if _, err := openVault(file, key); err != nil {
+		return err
+	}
+
+	v.lock(webSessionID)
+	v.unlocked[webSessionID] = &vaultKey{
+		username:   username,
+		key:        key,
+		lastAccess: time.Now(),
+	}
+
+	return nil
+}
+
+// Lock forgets the key the browser session derived
+func (v *VaultService) Lock(webSessionID string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.lock(webSessionID)
+}
+
+// Rekey moves the key a browser session derived to the session's new ID
+// after it was rotated, so the vault stays unlocked
+func (v *VaultService) Rekey(oldWebSessionID, newWebSessionID string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if unlocked, exists := v.unlocked[oldWebSessionID]; exists {
+		delete(v.unlocked, oldWebSessionID)
+		v.lock(newWebSessionID)
+		v.unlocked[newWebSessionID] = unlocked
+	}
+}
+
+// List returns all profiles sorted by name, without their secrets
+func (v *VaultService) List(webSessionID string) ([]models.ConnectionProfile, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	_, stored, err := v.load(webSessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make([]models.ConnectionProfile, 0, len(stored))
+	for _, profile := range stored {
+		profiles = append(profiles, profile.Redacted())
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		return strings.ToLower(profiles[i].Name) < strings.ToLower(profiles[j].Name)
+	})
+
+	return profiles, nil
+}
+
+// Get returns a profile including its secrets
+func (v *VaultService) Get(webSessionID, id string) (*models.ConnectionProfile, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	_, profiles, err := v.load(webSessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, exists := profiles[id]
+	if !exists {
+		return nil, models.ErrProfileNotFound
+	}
+	return profile, nil
+}
+
+// Save creates a profile, or updates it when the ID is already known.
+// Secrets left empty on update keep their stored values so the browser
+// never needs to receive them.
+func (v *VaultService) Save(webSessionID string, profile *models.ConnectionProfile) (*models.ConnectionProfile, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	file, profiles, err := v.load(webSessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	existing, exists := profiles[profile.ID]
+	if profile.ID != "" && !exists {
+		return nil, models.ErrProfileNotFound
+	}
+
+	if exists {
+		if profile.Password == "" {
+			profile.Password = existing.Password
+		}
+		if profile.PrivateKey == "" {
+			profile.PrivateKey = existing.PrivateKey
+			if profile.Passphrase == "" {
+				profile.Passphrase = existing.Passphrase
+			}
+		}
+		keepJumpSecrets(profile.JumpHosts, existing.JumpHosts)
+		profile.CreatedAt = existing.CreatedAt
+	} else {
+		id, err := generateProfileID()
+		if err != nil {
+			return nil, err
+		}
+		profile.ID = id
+		profile.CreatedAt = now
+	}
+
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+	if err := ValidateAlgorithms(profile.Options); err != nil {
+		return nil, err
+	}
+
+	profile.AuthMethod = authMethodName(profile.LoginRequest())
+	profile.UpdatedAt = now
+
+	profiles[profile.ID] = profile
+	if err := v.persist(webSessionID, file, profiles); err != nil {
+		return nil, err
+	}
+
+	redacted := profile.Redacted()
+	return &redacted, nil
+}
+
+// Delete removes a profile from the vault
+func (v *VaultService) Delete(webSessionID, id string) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	file, profiles, err := v.load(webSessionID)
+	if err != nil {
+		return err
+	}
+
+	if _, exists := profiles[id]; !exists {
+		return models.ErrProfileNotFound
+	}
+
+	delete(profiles, id)
+	return v.persist(webSessionID, file, profiles)
+}
+
+// keepJumpSecrets copies stored jump host secrets into an updated chain for
+// hops that are unchanged and were submitted without credentials
+func keepJumpSecrets(updated, stored []models.JumpHost) {
+	for i := range updated {
+		if i >= len(stored) || updated[i].String() != stored[i].String() {
+			continue
+		}
+		if updated[i].Password == "" {
+			updated[i].Password = stored[i].Password
+		}
+		if updated[i].PrivateKey == "" {
+			updated[i].PrivateKey = stored[i].PrivateKey
+			if updated[i].Passphrase == "" {
+				updated[i].Passphrase = stored[i].Passphrase
+			}
+		}
+	}
+}
+
+// create initialises an empty vault for the user protected by the
+// passphrase. The caller must hold the mutex.
+func (v *VaultService) create(webSessionID, username, passphrase string) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate vault salt: %w", err)
+	}
+
+	file := &vaultFile{
+		Version: vaultVersion,
+		KDF:     defaultVaultKDF,
+		Salt:    salt,
+	}
+
+	v.lock(webSessionID)
+	v.unlocked[webSessionID] = &vaultKey{
+		username:   username,
+		key:        deriveVaultKey(passphrase, salt, file.KDF),
+		lastAccess: time.Now(),
+	}
+
+	if err := v.persist(webSessionID, file, map[string]*models.ConnectionProfile{}); err != nil {
+		v.lock(webSessionID)
+		return err
+	}
+
+	return nil
+}
+
+// readFile reads the user's vault file without decrypting it
+func (v *VaultService) readFile(username string) (*vaultFile, error) {
+	data, err := os.ReadFile(v.vaultPath(username))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read vault: %w", err)
+	}
+
+	var file vaultFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse vault: %w", err)
+	}
+	if file.Version != vaultVersion || file.KDF.Algorithm != "argon2id" {
+		return nil, fmt.Errorf("unsupported vault format")
+	}
+
+	return &file, nil
+}
+
+// load decrypts the vault the browser session has unlocked and records
+// the access for auto-lock. The vault is read on every call, so changes
+// saved from the user's other browser sessions are never overwritten. The
+// caller must hold the mutex.
+func (v *VaultService) load(webSessionID string) (*vaultFile, map[string]*models.ConnectionProfile, error) {
+	unlocked, exists := v.unlocked[webSessionID]
+	if !exists {
+		return nil, nil, models.ErrVaultLocked
+	}
+
+	file, err := v.readFile(unlocked.username)
+	if os.IsNotExist(err) {
+		v.lock(webSessionID)
+		return nil, nil, models.ErrVaultLocked
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	profiles, err := openVault(file, unlocked.key)
+	if err != nil {
+		// The vault was replaced, e.g. with a new passphrase
+		v.lock(webSessionID)
+		return nil, nil, models.ErrVaultLocked
+	}
+
+	unlocked.lastAccess = time.Now()
+	return file, profiles, nil
+}
+
+// openVault decrypts the profiles in a vault file
+func openVault(file *vaultFile, key []byte) (map[string]*models.ConnectionProfile, error) {
+	gcm, err := newVaultCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return nil, models.ErrVaultPassphrase
+	}
+
+	var profiles []*models.ConnectionProfile
+	if err := json.Unmarshal(plaintext, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse vault contents: %w", err)
+	}
+
+	byID := make(map[string]*models.ConnectionProfile, len(profiles))
+	for _, profile := range profiles {
+		byID[profile.ID] = profile
+	}
+	return byID, nil
+}
+
+// persist encrypts the profiles with the browser session's key and
+// atomically replaces its user's vault file. The caller must hold the
+// mutex.
+func (v *VaultService) persist(webSessionID string, file *vaultFile, profiles map[string]*models.ConnectionProfile) error {
+	unlocked := v.unlocked[webSessionID]
+
+	list := make([]*models.ConnectionProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		list = append(list, profile)
+	}
+
+	plaintext, err := json.Marshal(list)
+	if err != nil {
+		return fmt.Errorf("failed to encode profiles: %w", err)
+	}
+
+	gcm, err := newVaultCipher(unlocked.key)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate vault nonce: %w", err)
+	}
+
+	data, err := json.MarshalIndent(vaultFile{
+		Version: vaultVersion,
+		KDF:     file.KDF,
+		Salt:    file.Salt,
+		Nonce:   nonce,
+		Data:    gcm.Seal(nil, nonce, plaintext, nil),
+		Updated: time.Now(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode vault: %w", err)
+	}
+
+	path := v.vaultPath(unlocked.username)
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create vault directory: %w", err)
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write vault: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write vault: %w", err)
+	}
+
+	return nil
+}
+
+// lock clears the key a browser session derived. The caller must hold the
+// mutex.
+func (v *VaultService) lock(webSessionID string) {
+	unlocked, exists := v.unlocked[webSessionID]
+	if !exists {
+		return
+	}
+	for i := range unlocked.key {
+		unlocked.key[i] = 0
+	}
+	delete(v.unlocked, webSessionID)
+}
+
+// autoLock runs periodically and forgets vault keys that have been idle
+func (v *VaultService) autoLock() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		v.mutex.Lock()
+		for webSessionID, unlocked := range v.unlocked {
+			if time.Since(unlocked.lastAccess) > v.config.Security.VaultAutoLock {
+				v.lock(webSessionID)
+				fmt.Printf("Profile vault of %s locked after inactivity\n", unlocked.username)
+			}
+		}
+		v.mutex.Unlock()
+	}
+}
+
+// deriveVaultKey derives the AES-256 vault key from the passphrase
+func deriveVaultKey(passphrase string, salt []byte, kdf vaultKDF) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, kdf.Time, kdf.Memory, kdf.Threads, 32)
+}
+
+// newVaultCipher returns an AES-GCM cipher for the vault key
+func newVaultCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise vault cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// generateProfileID generates a random profile ID
+func generateProfileID() (string, error) {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate profile ID: %w", err)
+	}
+	return fmt.Sprintf("%x", bytes), nil
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+func newTestVaultService(t *testing.T) *VaultService {
+	t.Helper()
+
+	cfg := config.DefaultConfig()
+	cfg.Security.VaultFile = filepath.Join(t.TempDir(), "profiles.vault")
+	return NewVaultService(cfg)
+}
+
+// newTestProfile returns a profile that passes validation, with a password
+// that must never reach the disk unencrypted
+func newTestProfile() *models.ConnectionProfile {
+	return &models.ConnectionProfile{
+		Name:     "staging",
+		Host:     "staging.example",
+		Port:     22,
+		Username: "deploy",
+		Password: "s3cret-password",
+	}
+}
+
+// rewriteVaultFile applies change to a user's vault file on disk
+func rewriteVaultFile(t *testing.T, vault *VaultService, username string, change func(file *vaultFile)) {
+	t.Helper()
+
+	file, err := vault.readFile(username)
+	if err != nil {
+		t.Fatalf("readFile: %v", err)
+	}
+	change(file)
+	data, err := json.Marshal(file)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if err := os.WriteFile(vault.vaultPath(username), data, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func TestVaultEncryptsProfiles(t *testing.T) {
+	vault := newTestVaultService(t)
+	if err := vault.Unlock("web-1", "alice", "correct horse"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	saved, err := vault.Save("web-1", newTestProfile())
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if saved.Password != "" {
+		t.Errorf("Save returned the password, want it redacted")
+	}
+
+	data, err := os.ReadFile(vault.vaultPath("alice"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	for _, plaintext := range []string{"s3cret-password", "staging.example", "deploy"} {
+		if strings.Contains(string(data), plaintext) {
+			t.Errorf("vault file contains %q in plain text", plaintext)
+		}
+	}
+
+	// A new service, as after a restart, decrypts it with the passphrase
+	restarted := NewVaultService(vault.config)
+	if err := restarted.Unlock("web-2", "alice", "correct horse"); err != nil {
+		t.Fatalf("Unlock after restart: %v", err)
+	}
+	profile, err := restarted.Get("web-2", saved.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if profile.Password != "s3cret-password" || profile.Host != "staging.example" {
+		t.Errorf("Get = %+v, want the saved profile with its password", profile)
+	}
+}
+
+func TestVaultUnlock(t *testing.T) {
+	tests := []struct {
+		name       string
+		passphrase string
+		change     func(file *vaultFile)
+		wantErr    error
+	}{
+		{name: "correct passphrase", passphrase: "correct horse"},
+		{name: "wrong passphrase", passphrase: "wrong horse", wantErr: models.ErrVaultPassphrase},
+		{name: "empty passphrase", passphrase: "", wantErr: errAny},
+		{
+			name:       "tampered ciphertext",
+			passphrase: "correct horse",
+			change:     func(file *vaultFile) { file.Data[0] ^= 0xff },
+			wantErr:    models.ErrVaultPassphrase,
+		},
+		{
+			name:       "different salt",
+			passphrase: "correct horse",
+			change:     func(file *vaultFile) { file.Salt[0] ^= 0xff },
+			wantErr:    models.ErrVaultPassphrase,
+		},
+		{
+			name:       "unsupported version",
+			passphrase: "correct horse",
+			change:     func(file *vaultFile) { file.Version = vaultVersion + 1 },
+			wantErr:    errAny,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault := newTestVaultService(t)
+			if err := vault.Unlock("web-1", "alice", "correct horse"); err != nil {
+				t.Fatalf("creating the vault: %v", err)
+			}
+			if _, err := vault.Save("web-1", newTestProfile()); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			if tt.change != nil {
+				rewriteVaultFile(t, vault, "alice", tt.change)
+			}
+
+			err := vault.Unlock("web-2", "alice", tt.passphrase)
+			switch {
+			case tt.wantErr == errAny:
+				if err == nil {
+					t.Fatal("Unlock succeeded, want an error")
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("Unlock: %v", err)
+			}
+			if unlocked := vault.IsUnlocked("web-2"); unlocked != (tt.wantErr == nil) {
+				t.Errorf("IsUnlocked = %v, want %v", unlocked, tt.wantErr == nil)
+			}
+		})
+	}
+}
+
+func TestVaultSessions(t *testing.T) {
+	vault := newTestVaultService(t)
+	if err := vault.Unlock("web-1", "alice", "correct horse"); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if _, err := vault.Save("web-1", newTestProfile()); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := vault.Unlock("web-bob", "bob", "battery staple"); err != nil {
+		t.Fatalf("Unlock for bob: %v", err)
+	}
+	vault.Rekey("web-1", "web-2")
+
+	tests := []struct {
+		name         string
+		webSessionID string
+		wantProfiles int
+		wantErr      error
+	}{
+		{name: "rotated session keeps the vault", webSessionID: "web-2", wantProfiles: 1},
+		{name: "old session ID is locked", webSessionID: "web-1", wantErr: models.ErrVaultLocked},
+		{name: "other user has a vault of their own", webSessionID: "web-bob", wantProfiles: 0},
+		{name: "unknown session is locked", webSessionID: "web-3", wantErr: models.ErrVaultLocked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profiles, err := vault.List(tt.webSessionID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if len(profiles) != tt.wantProfiles {
+				t.Errorf("List returned %d profiles, want %d", len(profiles), tt.wantProfiles)
+			}
+		})
+	}
+
+	vault.Lock("web-2")
+	if _, err := vault.List("web-2"); !errors.Is(err, models.ErrVaultLocked) {
+		t.Errorf("List after Lock: error = %v, want %v", err, models.ErrVaultLocked)
+	}
+}
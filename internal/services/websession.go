@@ -0,0 +1,518 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"sftp-gui/internal/models"
+)
+
+// CreateWebSession starts a browser session for an application user who
+// has signed in from the given client. The client address must be the one
+// resolved by middleware.RealIP, which only believes forwarding headers
+// sent by trusted proxies, so a client cannot bind its session to an
+// address of its choosing.
+func (s *SessionService) CreateWebSession(user *models.User, clientIP, userAgent string) (*models.WebSession, error) {
+	id, err := s.generateSessionID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	csrfToken, err := s.generateSessionID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CSRF token: %w", err)
+	}
+
+	now := time.Now()
+	webSession := &models.WebSession{
+		ID:         id,
+		Username:   user.Username,
+		Role:       user.Role,
+		CreatedAt:  now,
+		LastAccess: now,
+		ClientIP:   canonicalIP(clientIP),
+		UserAgent:  userAgent,
+		CSRFToken:  csrfToken,
+	}
+
+	s.mutex.Lock()
+	s.store.SaveWebSession(webSession)
+	s.mutex.Unlock()
+
+	return webSession, nil
+}
+
+// AddConnection attaches a newly created SFTP connection to the browser
+// session of the user who opened it. The connection is closed if it cannot
+// be added.
+func (s *SessionService) AddConnection(webSessionID string, session *models.Session) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	webSession, exists := s.store.WebSession(webSessionID)
+	if !exists {
+		s.removeSession(session.ID)
+		return models.ErrSessionNotFound
+	}
+
+	if len(webSession.ConnectionIDs) >= s.config.Session.MaxConnections {
+		s.removeSession(session.ID)
+		return models.ErrTooManyConnections
+	}
+
+	session.WebSessionID = webSession.ID
+	session.AppUser = webSession.Username
+	webSession.ConnectionIDs = append(webSession.ConnectionIDs, session.ID)
+	s.store.SaveSession(session)
+	s.store.SaveWebSession(webSession)
+
+	return nil
+}
+
+// CheckConnectionLimit reports whether a browser session may open another
+// connection, so a login can be refused before dialing
+func (s *SessionService) CheckConnectionLimit(webSessionID string) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if webSession, exists := s.store.WebSession(webSessionID); exists && len(webSession.ConnectionIDs) >= s.config.Session.MaxConnections {
+		return models.ErrTooManyConnections
+	}
+	return nil
+}
+
+// GetWebSession retrieves a browser session by its signed cookie value and
+// records the access. Idle browser sessions expire like connections do, and
+// every session ends after LoginTimeout. A session presented by a client it
+// is not bound to is ended, since its cookie has probably been stolen. Like
+// in CreateWebSession, clientIP is the address resolved by
+// middleware.RealIP.
+func (s *SessionService) GetWebSession(cookieValue, clientIP, userAgent string) (*models.WebSession, error) {
+	return s.findWebSession(cookieValue, clientIP, userAgent, true)
+}
+
+// PeekWebSession is GetWebSession for status requests that browsers send
+// on their own, which must not keep an idle session alive
+func (s *SessionService) PeekWebSession(cookieValue, clientIP, userAgent string) (*models.WebSession, error) {
+	return s.findWebSession(cookieValue, clientIP, userAgent, false)
+}
+
+// findWebSession resolves and checks a session cookie, recording the
+// access when touch is set
+func (s *SessionService) findWebSession(cookieValue, clientIP, userAgent string, touch bool) (*models.WebSession, error) {
+	webSessionID, err := s.ParseSessionCookie(cookieValue)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	webSession, exists := s.store.WebSession(webSessionID)
+	if !exists {
+		return nil, models.ErrSessionNotFound
+	}
+	if s.webSessionExpired(webSession) {
+		s.removeWebSession(webSession)
+		return nil, models.ErrSessionExpired
+	}
+	if !s.boundTo(webSession, clientIP, userAgent) {
+		fmt.Printf("Ended session of %s: signed in from %s (%q), presented from %s (%q)\n",
+			webSession.Username, webSession.ClientIP, webSession.UserAgent, clientIP, userAgent)
+		s.removeWebSession(webSession)
+		return nil, models.ErrSessionMoved
+	}
+
+	if touch {
+		webSession.LastAccess = time.Now()
+	}
+	return webSession, nil
+}
+
+// RotateWebSession gives a browser session a new ID, e.g. after the role of
+// its user changed, and records the new role. The old ID stops working
+// at once. The CSRF token, an unlocked profile vault and logins in progress
+// are kept, so pages that are already open can still submit their forms.
+func (s *SessionService) RotateWebSession(webSessionID, role string) (*models.WebSession, error) {
+	id, err := s.generateSessionID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	webSession, exists := s.store.WebSession(webSessionID)
+	if !exists {
+		return nil, models.ErrSessionNotFound
+	}
+
+	s.store.DeleteWebSession(webSessionID)
+	if s.vault != nil {
+		s.vault.Rekey(webSessionID, id)
+	}
+	webSession.ID = id
+	webSession.Role = role
+	s.store.SaveWebSession(webSession)
+	for _, connectionID := range webSession.ConnectionIDs {
+		if session, ok := s.store.Session(connectionID); ok {
+			session.WebSessionID = id
+			s.store.SaveSession(session)
+		}
+	}
+	for _, flow := range s.loginFlows {
+		if flow.webSessionID == webSessionID {
+			flow.webSessionID = id
+		}
+	}
+	for _, pending := range s.pendingLogins {
+		if pending.webSessionID == webSessionID {
+			pending.webSessionID = id
+		}
+	}
+
+	return webSession, nil
+}
+
+// SessionCookie returns the cookie value of a browser session: its ID and
+// an HMAC of the ID, so forged values are refused without a lookup
+func (s *SessionService) SessionCookie(webSession *models.WebSession) string {
+	return webSession.ID + "." + s.signSessionID(webSession.ID)
+}
+
+// ParseSessionCookie checks the signature of a session cookie value and
+// returns the session ID in it
+func (s *SessionService) ParseSessionCookie(cookieValue string) (string, error) {
+	dot := strings.LastIndexByte(cookieValue, '.')
+	if dot < 0 {
+		return "", models.ErrSessionNotFound
+	}
+
+	id, signature := cookieValue[:dot], cookieValue[dot+1:]
+	if !hmac.Equal([]byte(signature), []byte(s.signSessionID(id))) {
+		return "", models.ErrSessionNotFound
+	}
+	return id, nil
+}
+
+// GetConnection retrieves a connection owned by a browser session. Without
+// a connection ID, the most recently used connection is returned.
+func (s *SessionService) GetConnection(webSessionID, connectionID string) (*models.Session, error) {
+	s.mutex.RLock()
+	webSession, exists := s.store.WebSession(webSessionID)
+	if !exists {
+		s.mutex.RUnlock()
+		return nil, models.ErrSessionNotFound
+	}
+
+	if connectionID == "" {
+		var latest *models.Session
+		for _, id := range webSession.ConnectionIDs {
+			if session, ok := s.store.Session(id); ok && (latest == nil || session.LastAccess.After(latest.LastAccess)) {
+				latest = session
+			}
+		}
+		if latest == nil {
+			s.mutex.RUnlock()
+			return nil, models.ErrSessionNotFound
+		}
+		connectionID = latest.ID
+	} else if !containsString(webSession.ConnectionIDs, connectionID) {
+		s.mutex.RUnlock()
+		return nil, models.ErrConnectionNotFound
+	}
+	s.mutex.RUnlock()
+
+	return s.GetSession(connectionID)
+}
+
+// Connections returns the unexpired connections of a browser session in the
+// order they were opened
+func (s *SessionService) Connections(webSessionID string) []*models.Session {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	webSession, exists := s.store.WebSession(webSessionID)
+	if !exists {
+		return nil
+	}
+
+	connections := make([]*models.Session, 0, len(webSession.ConnectionIDs))
+	for _, id := range webSession.ConnectionIDs {
+		if session, ok := s.store.Session(id); ok && !session.IsExpired(s.config.Session.Timeout) {
+			connections = append(connections, session)
+		}
+	}
+
+	return connections
+}
+
+// DeleteConnection closes one connection of a browser session
+func (s *SessionService) DeleteConnection(webSessionID, connectionID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	webSession, exists := s.store.WebSession(webSessionID)
+	if !exists {
+		return models.ErrSessionNotFound
+	}
+	if !containsString(webSession.ConnectionIDs, connectionID) {
+		return models.ErrConnectionNotFound
+	}
+
+	s.removeSession(connectionID)
+	return nil
+}
+
+// DeleteWebSession closes all connections of a browser session and ends it
+func (s *SessionService) DeleteWebSession(webSessionID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	webSession, exists := s.store.WebSession(webSessionID)
+	if !exists {
+		return models.ErrSessionNotFound
+	}
+
+	s.removeWebSession(webSession)
+	return nil
+}
+
+// DeleteUserSessions ends every browser session of an application user,
+// e.g. after the account was disabled or deleted
+func (s *SessionService) DeleteUserSessions(username string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count := 0
+	for _, webSession := range s.store.WebSessions() {
+		if webSession.Username == username {
+			s.removeWebSession(webSession)
+			count++
+		}
+	}
+
+	return count
+}
+
+// TakeNotices returns the notices waiting for a browser session and
+// forgets them
+func (s *SessionService) TakeNotices(webSessionID string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	webSession, exists := s.store.WebSession(webSessionID)
+	if !exists || len(webSession.Notices) == 0 {
+		return nil
+	}
+
+	notices := webSession.Notices
+	webSession.Notices = nil
+	s.store.SaveWebSession(webSession)
+	return notices
+}
+
+// SessionExpiry reports when a browser session and, if connectionID is
+// set, one of its connections expire unless they are used
+func (s *SessionService) SessionExpiry(webSessionID, connectionID string) (*models.SessionExpiry, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	webSession, exists := s.store.WebSession(webSessionID)
+	if !exists {
+		return nil, models.ErrSessionNotFound
+	}
+
+	return s.sessionExpiry(webSession, connectionID)
+}
+
+// ExtendSession records an access to a browser session and one of its
+// connections without a file operation, which postpones their idle
+// expiry. The lifetime of the sign-in cannot be extended.
+func (s *SessionService) ExtendSession(webSessionID, connectionID string) (*models.SessionExpiry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	webSession, exists := s.store.WebSession(webSessionID)
+	if !exists {
+		return nil, models.ErrSessionNotFound
+	}
+	webSession.LastAccess = time.Now()
+
+	if connectionID != "" {
+		session, ok := s.store.Session(connectionID)
+		if !ok || !containsString(webSession.ConnectionIDs, connectionID) {
+			return nil, models.ErrConnectionNotFound
+		}
+		session.UpdateAccess()
+	}
+
+	return s.sessionExpiry(webSession, connectionID)
+}
+
+// sessionExpiry works out when a browser session or its connection ends.
+// The caller must hold the mutex.
+func (s *SessionService) sessionExpiry(webSession *models.WebSession, connectionID string) (*models.SessionExpiry, error) {
+	timeout := s.config.Session.Timeout
+	expiry := &models.SessionExpiry{
+		ExpiresAt:          webSession.LastAccess.Add(timeout),
+		IdleTimeoutSeconds: int(timeout.Seconds()),
+		SignInExpiresAt:    webSession.CreatedAt.Add(s.config.Security.LoginTimeout),
+		Extendable:         true,
+	}
+
+	if connectionID != "" {
+		session, ok := s.store.Session(connectionID)
+		if !ok || !containsString(webSession.ConnectionIDs, connectionID) {
+			return nil, models.ErrConnectionNotFound
+		}
+		if expiresAt := session.LastAccess.Add(timeout); expiresAt.Before(expiry.ExpiresAt) {
+			expiry.ExpiresAt = expiresAt
+		}
+	}
+
+	if !expiry.ExpiresAt.Before(expiry.SignInExpiresAt) {
+		expiry.ExpiresAt = expiry.SignInExpiresAt
+		expiry.Extendable = false
+	}
+	if remaining := time.Until(expiry.ExpiresAt); remaining > 0 {
+		expiry.RemainingSeconds = int(remaining.Seconds())
+	}
+
+	return expiry, nil
+}
+
+// DisconnectAll closes all connections of a browser session but keeps the
+// user signed in
+func (s *SessionService) DisconnectAll(webSessionID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if webSession, exists := s.store.WebSession(webSessionID); exists {
+		for _, id := range append([]string(nil), webSession.ConnectionIDs...) {
+			s.removeSession(id)
+		}
+	}
+}
+
+// webSessionExpired reports whether a browser session was idle for too
+// long or has outlived LoginTimeout
+func (s *SessionService) webSessionExpired(webSession *models.WebSession) bool {
+	return time.Since(webSession.LastAccess) > s.config.Session.Timeout ||
+		time.Since(webSession.CreatedAt) > s.config.Security.LoginTimeout
+}
+
+// boundTo reports whether a client may use a browser session under the
+// session binding settings
+func (s *SessionService) boundTo(webSession *models.WebSession, clientIP, userAgent string) bool {
+	binding := s.config.Security.SessionBinding
+	if binding.UserAgent && userAgent != webSession.UserAgent {
+		return false
+	}
+
+	if binding.IPv4Prefix == 0 && binding.IPv6Prefix == 0 {
+		return true
+	}
+
+	original, current := net.ParseIP(webSession.ClientIP), net.ParseIP(clientIP)
+	if original == nil || current == nil {
+		return false
+	}
+
+	original4, current4 := original.To4(), current.To4()
+	switch {
+	case original4 != nil && current4 != nil:
+		if binding.IPv4Prefix == 0 {
+			return true
+		}
+		mask := net.CIDRMask(binding.IPv4Prefix, 32)
+		return original4.Mask(mask).Equal(current4.Mask(mask))
+	case original4 == nil && current4 == nil:
+		if binding.IPv6Prefix == 0 {
+			return true
+		}
+		mask := net.CIDRMask(binding.IPv6Prefix, 128)
+		return original.Mask(mask).Equal(current.Mask(mask))
+	default:
+		// The client switched between IPv4 and IPv6
+		return false
+	}
+}
+
+// canonicalIP returns an address in its standard form, so an address
+// written in different ways is recorded the same
+func canonicalIP(addr string) string {
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.String()
+	}
+	return addr
+}
+
+// signSessionID returns the HMAC of a session ID
+func (s *SessionService) signSessionID(id string) string {
+	mac := hmac.New(sha256.New, s.cookieKey)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sessionCookieKey derives the key that signs session cookies from the
+// configured secret, or generates a random one
+func sessionCookieKey(secret string) ([]byte, error) {
+	if secret != "" {
+		key := sha256.Sum256([]byte(secret))
+		return key[:], nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate session cookie key: %w", err)
+	}
+	return key, nil
+}
+
+// removeWebSession closes the connections of a browser session, locks its
+// profile vault and ends it. The caller must hold the mutex.
+func (s *SessionService) removeWebSession(webSession *models.WebSession) {
+	for _, id := range append([]string(nil), webSession.ConnectionIDs...) {
+		s.removeSession(id)
+	}
+	if s.vault != nil {
+		s.vault.Lock(webSession.ID)
+	}
+	s.store.DeleteWebSession(webSession.ID)
+}
+
+// removeSession closes a connection and detaches it from its browser
+// session. The caller must hold the mutex.
+func (s *SessionService) removeSession(sessionID string) {
+	session, exists := s.store.Session(sessionID)
+	if !exists {
+		return
+	}
+
+	s.reconnectMutex.Lock()
+	err := session.Close()
+	s.reconnectMutex.Unlock()
+	if err != nil {
+		// Log error but continue with deletion
+		fmt.Printf("Error closing session %s: %v\n", sessionID, err)
+	}
+	s.store.DeleteSession(sessionID)
+
+	webSession, exists := s.store.WebSession(session.WebSessionID)
+	if !exists {
+		return
+	}
+	for i, id := range webSession.ConnectionIDs {
+		if id == sessionID {
+			webSession.ConnectionIDs = append(webSession.ConnectionIDs[:i], webSession.ConnectionIDs[i+1:]...)
+			s.store.SaveWebSession(webSession)
+			break
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"sftp-gui/internal/config"
+	"sftp-gui/internal/models"
+)
+
+func newTestSessionService(t *testing.T, cfg *config.Config) *SessionService {
+	t.Helper()
+
+	sessions, err := NewSessionService(cfg, NewHostKeyService(cfg), NewMemorySessionStore(), nil, nil)
+	if err != nil {
+		t.Fatalf("NewSessionService: %v", err)
+	}
+	return sessions
+}
+
+// alterLast changes the last character of a string
+func alterLast(value string) string {
+	if strings.HasSuffix(value, "A") {
+		return value[:len(value)-1] + "B"
+	}
+	return value[:len(value)-1] + "A"
+}
+
+func TestParseSessionCookie(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Security.SessionSecret = "first secret"
+	sessions := newTestSessionService(t, cfg)
+
+	other := config.DefaultConfig()
+	other.Security.SessionSecret = "second secret"
+	otherSessions := newTestSessionService(t, other)
+
+	webSession := &models.WebSession{ID: "0123456789abcdef"}
+	cookie := sessions.SessionCookie(webSession)
+	id, signature, _ := strings.Cut(cookie, ".")
+
+	tests := []struct {
+		name    string
+		cookie  string
+		wantErr bool
+	}{
+		{name: "signed cookie", cookie: cookie},
+		{name: "other session ID", cookie: "fedcba9876543210." + signature, wantErr: true},
+		{name: "altered signature", cookie: id + "." + alterLast(signature), wantErr: true},
+		{name: "no signature", cookie: id, wantErr: true},
+		{name: "empty signature", cookie: id + ".", wantErr: true},
+		{name: "signed with another secret", cookie: otherSessions.SessionCookie(webSession), wantErr: true},
+		{name: "empty", cookie: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sessions.ParseSessionCookie(tt.cookie)
+			if tt.wantErr {
+				if !errors.Is(err, models.ErrSessionNotFound) {
+					t.Fatalf("ParseSessionCookie = %q, %v; want %v", got, err, models.ErrSessionNotFound)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSessionCookie: %v", err)
+			}
+			if got != webSession.ID {
+				t.Errorf("ParseSessionCookie = %q, want %q", got, webSession.ID)
+			}
+		})
+	}
+}
+
+func TestSessionCookieSurvivesRestartWithSecret(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Security.SessionSecret = "shared secret"
+	webSession := &models.WebSession{ID: "0123456789abcdef"}
+
+	cookie := newTestSessionService(t, cfg).SessionCookie(webSession)
+	if _, err := newTestSessionService(t, cfg).ParseSessionCookie(cookie); err != nil {
+		t.Errorf("cookie from before the restart: %v", err)
+	}
+
+	cfg.Security.SessionSecret = ""
+	cookie = newTestSessionService(t, cfg).SessionCookie(webSession)
+	if _, err := newTestSessionService(t, cfg).ParseSessionCookie(cookie); err == nil {
+		t.Error("cookie signed with a random key was accepted after a restart")
+	}
+}
+
+func TestSessionBinding(t *testing.T) {
+	const firefox, chrome = "Mozilla/5.0 Firefox/120.0", "Mozilla/5.0 Chrome/120.0"
+
+	tests := []struct {
+		name      string
+		binding   config.SessionBindingConfig
+		clientIP  string
+		userAgent string
+		want      bool
+	}{
+		{name: "unbound session from anywhere", clientIP: "198.51.100.7", userAgent: chrome, want: true},
+		{name: "same browser", binding: config.SessionBindingConfig{UserAgent: true}, clientIP: "192.0.2.10", userAgent: firefox, want: true},
+		{name: "other browser", binding: config.SessionBindingConfig{UserAgent: true}, clientIP: "192.0.2.10", userAgent: chrome},
+		{name: "same /24", binding: config.SessionBindingConfig{IPv4Prefix: 24}, clientIP: "192.0.2.200", userAgent: firefox, want: true},
+		{name: "other /24", binding: config.SessionBindingConfig{IPv4Prefix: 24}, clientIP: "192.0.3.10", userAgent: firefox},
+		{name: "exact address", binding: config.SessionBindingConfig{IPv4Prefix: 32}, clientIP: "192.0.2.11", userAgent: firefox},
+		{name: "switch to IPv6", binding: config.SessionBindingConfig{IPv4Prefix: 24}, clientIP: "2001:db8::1", userAgent: firefox},
+		{name: "unparsable address", binding: config.SessionBindingConfig{IPv4Prefix: 24}, clientIP: "unknown", userAgent: firefox},
+		{name: "IPv4-mapped form of the same address", binding: config.SessionBindingConfig{IPv4Prefix: 32}, clientIP: "::ffff:192.0.2.10", userAgent: firefox, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Security.SessionBinding = tt.binding
+			sessions := newTestSessionService(t, cfg)
+
+			webSession, err := sessions.CreateWebSession(&models.User{Username: "alice", Role: config.RoleViewer}, "192.0.2.10", firefox)
+			if err != nil {
+				t.Fatalf("CreateWebSession: %v", err)
+			}
+			cookie := sessions.SessionCookie(webSession)
+
+			_, err = sessions.GetWebSession(cookie, tt.clientIP, tt.userAgent)
+			if tt.want {
+				if err != nil {
+					t.Fatalf("GetWebSession: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, models.ErrSessionMoved) {
+				t.Fatalf("error = %v, want %v", err, models.ErrSessionMoved)
+			}
+
+			// The session is ended, so the original client is signed out too
+			if _, err := sessions.GetWebSession(cookie, "192.0.2.10", firefox); !errors.Is(err, models.ErrSessionNotFound) {
+				t.Errorf("original client: error = %v, want %v", err, models.ErrSessionNotFound)
+			}
+		})
+	}
+}
+
+func TestSessionBindingIPv6(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Security.SessionBinding = config.SessionBindingConfig{IPv6Prefix: 64}
+	sessions := newTestSessionService(t, cfg)
+	webSession := &models.WebSession{ClientIP: "2001:db8:0:1::10"}
+
+	tests := []struct {
+		clientIP string
+		want     bool
+	}{
+		{clientIP: "2001:db8:0:1::20", want: true},
+		{clientIP: "2001:db8:0:1:ffff::1", want: true},
+		{clientIP: "2001:db8:0:2::10"},
+		{clientIP: "192.0.2.10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.clientIP, func(t *testing.T) {
+			if got := sessions.boundTo(webSession, tt.clientIP, ""); got != tt.want {
+				t.Errorf("boundTo = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
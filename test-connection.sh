@@ -67,6 +67,30 @@ else
     echo "   - Check /etc/ssh/sshd_config for PasswordAuthentication yes"
 fi
 
+# Test 5: Diagnostics as seen from the web interface
+echo
+echo "5. Asking the web interface to diagnose the connection..."
+WEB_URL=${SFTP_WEB_URL:-http://localhost:8082}
+WEB_USER=${SFTP_WEB_USER:-admin}
+COOKIES=$(mktemp)
+trap 'rm -f "$COOKIES"' EXIT
+if [ -z "$SFTP_WEB_PASSWORD" ]; then
+    echo "⚠️  Set SFTP_WEB_PASSWORD (and SFTP_WEB_USER, default admin) to sign in to the web interface"
+elif ! CSRF_TOKEN=$(curl -sf --max-time 10 -c "$COOKIES" "$WEB_URL/login" | sed -n 's/.*name="csrf_token" value="\([^"]*\)".*/\1/p') \
+    || ! curl -sf --max-time 10 -b "$COOKIES" -c "$COOKIES" -o /dev/null --data-urlencode "csrf_token=$CSRF_TOKEN" \
+        --data-urlencode "username=$WEB_USER" --data-urlencode "password=$SFTP_WEB_PASSWORD" "$WEB_URL/login"; then
+    echo "⚠️  Web interface not reachable at $WEB_URL (set SFTP_WEB_URL to override)"
+elif diagnostics=$(curl -sf --max-time 30 -b "$COOKIES" -H "Accept: application/json" -G "$WEB_URL/api/diagnostics" \
+        --data-urlencode "host=$VM_HOST" --data-urlencode "port=$VM_PORT" --data-urlencode "username=$VM_USER"); then
+    if command -v python3 >/dev/null; then
+        echo "$diagnostics" | python3 -m json.tool
+    else
+        echo "$diagnostics"
+    fi
+else
+    echo "⚠️  Diagnostics failed - check the web sign-in for $WEB_USER"
+fi
+
 echo
 echo "=== Connection Summary ==="
 echo "Host: $VM_HOST"
@@ -74,7 +98,8 @@ echo "Port: $VM_PORT"
 echo "User: $VM_USER"
 echo
 echo "If all tests pass, try connecting through the web interface at:"
-echo "http://localhost:8082"
+echo "$WEB_URL"
+echo "Detailed diagnostics: $WEB_URL/diagnostics?host=$VM_HOST&port=$VM_PORT"
 echo
 echo "Common VM SSH setup commands:"
 echo "  sudo systemctl enable ssh"